@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// HonorACL makes reading a file or listing a directory check the POSIX ACL (system.posix_acl_access) of it.
+var HonorACL bool
+
+// ACL entry tags, see acl(5).
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	aclRead = 4
+)
+
+// aclXattr is the extended attribute holding the access ACL.
+const aclXattr = "system.posix_acl_access"
+
+type aclEntry struct {
+	tag, perm uint16
+	id        uint32
+}
+
+// parseACL parses the extended attribute holding an ACL: a version header followed by 8 byte entries.
+func parseACL(buf []byte) []aclEntry {
+	if len(buf) < 4 || binary.LittleEndian.Uint32(buf) != 2 {
+		return nil
+	}
+	var acl []aclEntry
+	for b := buf[4:]; len(b) >= 8; b = b[8:] {
+		acl = append(acl, aclEntry{tag: binary.LittleEndian.Uint16(b), perm: binary.LittleEndian.Uint16(b[2:]), id: binary.LittleEndian.Uint32(b[4:])})
+	}
+	return acl
+}
+
+// readACL returns the extended attribute holding the ACL of path. It is read with a buffer of the size the kernel
+// reports, which is retried when the ACL grows in between.
+func readACL(path string) ([]byte, error) {
+	for {
+		sz, err := unix.Lgetxattr(path, aclXattr, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, sz)
+		sz, err = unix.Lgetxattr(path, aclXattr, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:sz], nil
+	}
+}
+
+// aclAllows returns true if the ACL of path grants caller want, using the access check algorithm from acl(5). When
+// path has no ACL, or the file system doesn't support them, true is returned, the permission bits are then left to the
+// kernel. When the ACL can't be read or parsed, false is returned.
+func aclAllows(path string, caller *fuse.Caller, want uint16) bool {
+	if caller == nil || caller.Uid == 0 {
+		return true
+	}
+	buf, err := readACL(path)
+	switch {
+	case err == unix.ENODATA || err == unix.EOPNOTSUPP:
+		return true
+	case err != nil:
+		return false
+	}
+	acl := parseACL(buf)
+	if len(acl) == 0 {
+		return false
+	}
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(path, &st); err != nil {
+		return false
+	}
+
+	mask := uint16(7)
+	for _, e := range acl {
+		if e.tag == aclMask {
+			mask = e.perm
+		}
+	}
+	for _, e := range acl {
+		switch {
+		case e.tag == aclUserObj && caller.Uid == st.Uid:
+			return e.perm&want == want
+		case e.tag == aclUser && caller.Uid == e.id:
+			return e.perm&mask&want == want
+		}
+	}
+
+	groups := append([]uint32{caller.Gid}, supplementaryGroups(caller.Pid)...)
+	member := func(gid uint32) bool {
+		for _, g := range groups {
+			if g == gid {
+				return true
+			}
+		}
+		return false
+	}
+	matched := false
+	for _, e := range acl {
+		if (e.tag == aclGroupObj && member(st.Gid)) || (e.tag == aclGroup && member(e.id)) {
+			if e.perm&mask&want == want {
+				return true
+			}
+			matched = true
+		}
+	}
+	if matched {
+		return false
+	}
+	for _, e := range acl {
+		if e.tag == aclOther {
+			return e.perm&want == want
+		}
+	}
+	return false
+}
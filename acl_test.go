@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// encodeACL returns the extended attribute holding acl.
+func encodeACL(acl []aclEntry) []byte {
+	buf := make([]byte, 4+8*len(acl))
+	binary.LittleEndian.PutUint32(buf, 2)
+	for i, e := range acl {
+		b := buf[4+8*i:]
+		binary.LittleEndian.PutUint16(b, e.tag)
+		binary.LittleEndian.PutUint16(b[2:], e.perm)
+		binary.LittleEndian.PutUint32(b[4:], e.id)
+	}
+	return buf
+}
+
+func TestACLAllows(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	caller := &fuse.Caller{Owner: fuse.Owner{Uid: 1000, Gid: 1000}, Pid: 1}
+
+	if !aclAllows(file, caller, aclRead) {
+		t.Errorf("without an ACL read is denied")
+	}
+	if aclAllows(filepath.Join(dir, "missing"), caller, aclRead) {
+		t.Errorf("for a missing file read is allowed, want it to fail closed")
+	}
+
+	// deny uid 1000, and add enough entries to need more than a small buffer
+	acl := []aclEntry{{tag: aclUserObj, perm: 6}, {tag: aclUser, perm: 0, id: 1000}}
+	for i := uint32(0); i < 200; i++ {
+		acl = append(acl, aclEntry{tag: aclUser, perm: 4, id: 2000 + i})
+	}
+	acl = append(acl, aclEntry{tag: aclGroupObj, perm: 4}, aclEntry{tag: aclMask, perm: 4}, aclEntry{tag: aclOther, perm: 4})
+	if err := unix.Lsetxattr(file, aclXattr, encodeACL(acl), 0); err != nil {
+		t.Skipf("can't set an ACL: %s", err)
+	}
+	if aclAllows(file, caller, aclRead) {
+		t.Errorf("uid 1000 is allowed to read, want it denied")
+	}
+	caller.Uid = 2100
+	if !aclAllows(file, caller, aclRead) {
+		t.Errorf("uid 2100 is denied to read, want it allowed")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Audit enables sending denials and grace period allows to the Linux audit subsystem.
+var Audit bool
+
+const auditTrustedApp = 1121 // AUDIT_TRUSTED_APP from linux/audit.h
+
+// auditQueueSize is the number of audit records that can wait to be sent, more are dropped.
+const auditQueueSize = 1024
+
+// auditq holds the audit records waiting to be sent, they are sent from a goroutine so a slow audit subsystem
+// doesn't hold up the file system. auditOff is set once we aren't allowed to send them.
+var (
+	auditq   chan string
+	auditOff int32
+)
+
+// openAudit opens the audit netlink socket and starts sending the audit records to it.
+func openAudit() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_AUDIT)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	if err := syscall.Connect(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	startAudit(fd)
+	return nil
+}
+
+// startAudit starts sending the queued audit records to fd. When we aren't allowed to write audit records (no
+// CAP_AUDIT_WRITE) this is logged once, after which auditing is disabled.
+func startAudit(fd int) {
+	auditq = make(chan string, auditQueueSize)
+	go func() {
+		seq := uint32(0)
+		for msg := range auditq {
+			seq++
+			err := sendAudit(fd, seq, msg)
+			if err == nil {
+				continue
+			}
+			if err == syscall.EPERM || err == syscall.EACCES {
+				log.Printf("Not allowed to write audit records, disabling auditd: %s", err)
+				atomic.StoreInt32(&auditOff, 1)
+				syscall.Close(fd)
+				return
+			}
+			log.Printf("Failed to write audit record: %s", err)
+		}
+	}()
+}
+
+// audit queues an audit record for op on actualPath from caller, res is "success" or "failed". If the queue is full
+// the record is dropped.
+func audit(op, actualPath string, caller *fuse.Caller, res string) {
+	if auditq == nil || atomic.LoadInt32(&auditOff) == 1 {
+		return
+	}
+	msg := fmt.Sprintf("op=mutfs-%s path=%q pid=%d uid=%d gid=%d res=%s", op, actualPath, caller.Pid, caller.Owner.Uid, caller.Owner.Gid, res)
+	select {
+	case auditq <- msg:
+	default:
+		log.Printf("Failed to write audit record for %q: queue full", actualPath)
+	}
+}
+
+// sendAudit sends msg as an audit record with sequence number seq to fd, and waits for the acknowledgement.
+func sendAudit(fd int, seq uint32, msg string) error {
+	hdr := syscall.NlMsghdr{
+		Len:   uint32(syscall.NLMSG_HDRLEN + len(msg) + 1),
+		Type:  auditTrustedApp,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Seq:   seq,
+	}
+	buf := make([]byte, (hdr.Len+syscall.NLMSG_ALIGNTO-1)&^(syscall.NLMSG_ALIGNTO-1))
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&buf[0])) = hdr
+	copy(buf[syscall.NLMSG_HDRLEN:], msg)
+
+	if _, err := syscall.Write(fd, buf); err != nil {
+		return err
+	}
+	return auditAck(fd)
+}
+
+// auditAck reads the acknowledgement of the last audit record and returns the error it carries.
+func auditAck(fd int) error {
+	buf := make([]byte, syscall.Getpagesize())
+	n, err := syscall.Read(fd, buf)
+	if err != nil {
+		return err
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+		if errno := -*(*int32)(unsafe.Pointer(&m.Data[0])); errno != 0 {
+			return syscall.Errno(errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mockAudit starts sending audit records to one end of a socket pair and returns the other end, which stands in for
+// the audit subsystem.
+func mockAudit(t *testing.T) int {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(auditq)
+		auditq = nil
+		atomic.StoreInt32(&auditOff, 0)
+		syscall.Close(fds[1])
+	})
+	startAudit(fds[0])
+	return fds[1]
+}
+
+// readAudit reads an audit record from fd and acknowledges it with errno.
+func readAudit(t *testing.T, fd int, errno syscall.Errno) (syscall.NlMsghdr, string) {
+	t.Helper()
+	buf := make([]byte, 4096)
+	n, err := syscall.Read(fd, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected one netlink message, got %d: %v", len(msgs), err)
+	}
+
+	ack := make([]byte, syscall.NLMSG_HDRLEN+4+syscall.NLMSG_HDRLEN)
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&ack[0])) = syscall.NlMsghdr{Len: uint32(len(ack)), Type: syscall.NLMSG_ERROR, Seq: msgs[0].Header.Seq}
+	*(*int32)(unsafe.Pointer(&ack[syscall.NLMSG_HDRLEN])) = -int32(errno)
+	if _, err := syscall.Write(fd, ack); err != nil {
+		t.Fatal(err)
+	}
+	return msgs[0].Header, strings.TrimRight(string(msgs[0].Data), "\x00")
+}
+
+func TestAudit(t *testing.T) {
+	fd := mockAudit(t)
+	caller := &fuse.Caller{Owner: fuse.Owner{Uid: 1000, Gid: 1000}, Pid: 42}
+
+	audit("unlink", "/olddir/file", caller, "failed")
+	hdr, msg := readAudit(t, fd, 0)
+	if hdr.Type != auditTrustedApp || hdr.Seq != 1 {
+		t.Errorf("got type %d and sequence number %d, want %d and 1", hdr.Type, hdr.Seq, auditTrustedApp)
+	}
+	if want := `op=mutfs-unlink path="/olddir/file" pid=42 uid=1000 gid=1000 res=failed`; msg != want {
+		t.Errorf("got record %q, want %q", msg, want)
+	}
+
+	// a denial from the audit subsystem disables auditing
+	audit("rename", "/olddir/file", caller, "success")
+	if hdr, _ := readAudit(t, fd, syscall.EPERM); hdr.Seq != 2 {
+		t.Errorf("got sequence number %d, want 2", hdr.Seq)
+	}
+	for i := 0; atomic.LoadInt32(&auditOff) == 0; i++ {
+		if i == 100 {
+			t.Fatal("expected auditing to be disabled after EPERM")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuditDoesntBlock(t *testing.T) {
+	mockAudit(t)
+	caller := &fuse.Caller{}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// nothing reads the records, so all but the first stay queued, and once the queue is full they are dropped
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 2*auditQueueSize; i++ {
+			audit("unlink", "/olddir/file", caller, "failed")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("audit blocked on an audit subsystem that doesn't answer")
+	}
+}
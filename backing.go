@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// LogBackingPath adds the absolute path in the backing store, with symlinks resolved, to the logs and events.
+var LogBackingPath bool
+
+// backingRoot maps the root as mutfs sees it (from) to its absolute path in the backing store (to).
+type backingRoot struct{ from, to string }
+
+// backingRoots holds a []backingRoot, one for each mount.
+var backingRoots atomic.Value
+
+// setBackingRoot records that from is a root, which is olddir in the backing store.
+func setBackingRoot(from, olddir string) error {
+	to, err := filepath.Abs(olddir)
+	if err != nil {
+		return err
+	}
+	if to, err = filepath.EvalSymlinks(to); err != nil {
+		return err
+	}
+	brs, _ := backingRoots.Load().([]backingRoot)
+	backingRoots.Store(append(append([]backingRoot{}, brs...), backingRoot{from: from, to: to}))
+	return nil
+}
+
+// moveBackingRoot records that the root olddir is now seen as from, after chrooting.
+func moveBackingRoot(olddir, from string) {
+	brs, _ := backingRoots.Load().([]backingRoot)
+	moved := make([]backingRoot, len(brs))
+	for i, br := range brs {
+		if br.from == olddir {
+			br.from = from
+		}
+		moved[i] = br
+	}
+	backingRoots.Store(moved)
+}
+
+// backing returns the absolute path in the backing store of actualPath. Paths outside of the roots (i.e. in upper) are
+// only made absolute.
+func backing(actualPath string) string {
+	brs, _ := backingRoots.Load().([]backingRoot)
+	for _, br := range brs {
+		rel, err := filepath.Rel(br.from, actualPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		return filepath.Join(br.to, rel)
+	}
+	abs, _ := filepath.Abs(actualPath)
+	return abs
+}
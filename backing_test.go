@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestLogBackingPath(t *testing.T) {
+	defer func(l, b bool) { Log, LogBackingPath = l, b }(Log, LogBackingPath)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	brs, _ := backingRoots.Load().([]backingRoot)
+	defer backingRoots.Store(brs)
+	Log, LogBackingPath, Grace = true, true, 0
+
+	// olddir is given relative to the working directory, through a symlink
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "real", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "real", "sub", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	m := &mount{olddir: "link"}
+	root, err := m.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.NewNodeFS(root, &fs.Options{})
+	n := root.(*MutNode)
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	n.deny(callerContext(context.Background(), 42, 1000, 1000), "unlink", "sub/file")
+
+	want := filepath.Join(dir, "real", "sub", "file")
+	if got := buf.String(); !strings.Contains(got, `"link/sub/file": `) || !strings.Contains(got, "backing path "+strconv.Quote(want)) {
+		t.Errorf("log %q doesn't hold the path %q and the backing path %q", got, "link/sub/file", want)
+	}
+	if e := newEvent("unlink", n.path("sub/file"), &fuse.Caller{}, "FAN_DENY"); e.Backing != want {
+		t.Errorf("got event with backing path %q, want %q", e.Backing, want)
+	}
+	// paths outside of the roots are only made absolute
+	if got := backing("upper/file"); got != filepath.Join(dir, "upper", "file") {
+		t.Errorf("got backing path %q, want %q", got, filepath.Join(dir, "upper", "file"))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// backoff returns the time to wait before retry attempt (counting from 0): an exponential backoff starting at base,
+// capped at max, with up to 50% jitter.
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// breaker is a circuit breaker: after threshold consecutive failures it opens for cooldown, during which calls
+// should not be made. The first call after that closes it again if it succeeds, or reopens it if it fails.
+type breaker struct {
+	sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	failures  int
+	openUntil time.Time
+}
+
+// allow returns true if a call may be made.
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	return time.Now().After(b.openUntil)
+}
+
+// done records the outcome of a call.
+func (b *breaker) done(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Printf("Circuit breaker for %s closed", b.name)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Printf("Circuit breaker for %s opened for %s after %d failures: %s", b.name, b.cooldown, b.failures, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// ReaddirBatch is the number of directory entries read from the underlying directory in one go.
+var ReaddirBatch int
+
+// maxReclen is the maximum size of a single directory entry as returned by getdents64(2).
+const maxReclen = 280
+
+// batchDirStream is a DirStream that reads at least batch entries with each getdents64(2) system call.
+type batchDirStream struct {
+	sync.Mutex
+	fd    int
+	buf   []byte
+	todo  []byte
+	errno syscall.Errno // error from loading the next batch, returned by the next call to Next
+}
+
+// dirent is like syscall.Dirent, but without the [256]byte name, as that may run past the end of the buffer.
+type dirent struct {
+	Ino    uint64
+	Off    int64
+	Reclen uint16
+	Type   uint8
+	Name   [1]uint8
+}
+
+func newBatchDirStream(path string, batch int) (fs.DirStream, syscall.Errno) {
+	fd, err := syscall.Open(path, syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	ds := &batchDirStream{fd: fd, buf: make([]byte, batch*maxReclen)}
+	if errno := ds.load(); errno != fs.OK {
+		ds.Close()
+		return nil, errno
+	}
+	return ds, fs.OK
+}
+
+func (ds *batchDirStream) HasNext() bool {
+	ds.Lock()
+	defer ds.Unlock()
+	return len(ds.todo) > 0 || ds.errno != fs.OK
+}
+
+func (ds *batchDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	if errno := ds.errno; errno != fs.OK {
+		ds.errno = fs.OK
+		return fuse.DirEntry{}, errno
+	}
+	de := (*dirent)(unsafe.Pointer(&ds.todo[0]))
+	name := ds.todo[unsafe.Offsetof(dirent{}.Name):de.Reclen]
+	ds.todo = ds.todo[de.Reclen:]
+	for i := range name {
+		if name[i] == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	e := fuse.DirEntry{Ino: de.Ino, Mode: uint32(de.Type) << 12, Name: string(name)}
+	ds.errno = ds.load()
+	return e, fs.OK
+}
+
+func (ds *batchDirStream) Close() {
+	ds.Lock()
+	defer ds.Unlock()
+	if ds.fd != -1 {
+		syscall.Close(ds.fd)
+		ds.fd = -1
+	}
+}
+
+// load fills the buffer when all entries have been handed out.
+func (ds *batchDirStream) load() syscall.Errno {
+	if len(ds.todo) > 0 {
+		return fs.OK
+	}
+	n, err := syscall.Getdents(ds.fd, ds.buf)
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	ds.todo = ds.buf[:n]
+	return fs.OK
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// testDir returns a temporary directory holding n empty files.
+func testDir(tb testing.TB, n int) string {
+	dir := tb.TempDir()
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)), nil, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBatchDirStream(t *testing.T) {
+	dir := testDir(t, 100)
+	ds, errno := newBatchDirStream(dir, 1)
+	if errno != fs.OK {
+		t.Fatal(errno)
+	}
+	defer ds.Close()
+
+	seen := map[string]bool{}
+	for ds.HasNext() {
+		e, errno := ds.Next()
+		if errno != fs.OK {
+			t.Fatal(errno)
+		}
+		seen[e.Name] = true
+	}
+	for i := 0; i < 100; i++ {
+		if !seen[strconv.Itoa(i)] {
+			t.Errorf("entry %d not seen", i)
+		}
+	}
+}
+
+func TestBatchDirStreamError(t *testing.T) {
+	dir := testDir(t, 100)
+	ds, errno := newBatchDirStream(dir, 1)
+	if errno != fs.OK {
+		t.Fatal(errno)
+	}
+	defer ds.Close()
+
+	// reading the next batch fails, as the descriptor is no longer a directory
+	fd, err := syscall.Open(filepath.Join(dir, "0"), syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bds := ds.(*batchDirStream)
+	syscall.Close(bds.fd)
+	bds.fd = fd
+
+	for ds.HasNext() {
+		e, errno := ds.Next()
+		if errno == fs.OK {
+			if e.Name == "" {
+				t.Fatalf("got an empty entry without an error")
+			}
+			continue
+		}
+		if e.Name != "" {
+			t.Errorf("got entry %q with error %s, want either", e.Name, errnoString(errno))
+		}
+		if errno != syscall.ENOTDIR {
+			t.Errorf("got error %s, want ENOTDIR", errnoString(errno))
+		}
+		if ds.HasNext() {
+			t.Errorf("entries after the error")
+		}
+		return
+	}
+	t.Errorf("no error")
+}
+
+func BenchmarkReaddirBatch(b *testing.B) {
+	dir := testDir(b, 10000)
+	for _, batch := range []int{0, 16, 128, 1024} {
+		b.Run(strconv.Itoa(batch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var (
+					ds    fs.DirStream
+					errno syscall.Errno
+				)
+				if batch == 0 {
+					ds, errno = fs.NewLoopbackDirStream(dir)
+				} else {
+					ds, errno = newBatchDirStream(dir, batch)
+				}
+				if errno != fs.OK {
+					b.Fatal(errno)
+				}
+				for ds.HasNext() {
+					if _, errno := ds.Next(); errno != fs.OK {
+						b.Fatal(errno)
+					}
+				}
+				ds.Close()
+			}
+		})
+	}
+}
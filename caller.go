@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// procRoot is where the proc filesystem is mounted.
+var procRoot = "/proc"
+
+// callerContext returns a context carrying a synthetic caller, as if the request came from pid running as uid/gid. This
+// allows the decision logic to be exercised without a FUSE request, combined with pointing procRoot at a fixture
+// the caller's groups, terminal and cgroup can be faked as well:
+//
+//	ctx := callerContext(context.Background(), 42, 1000, 1000)
+//	errno := n.deny(ctx, "unlink", "file")
+func callerContext(ctx context.Context, pid, uid, gid uint32) context.Context {
+	return fuse.NewContext(ctx, &fuse.Caller{Owner: fuse.Owner{Uid: uid, Gid: gid}, Pid: pid})
+}
+
+// AllowUID holds the rules for callers that are always allowed to mutate.
+var AllowUID []uidRule
+
+// AllowCgroup holds the patterns of the cgroups from which mutations are allowed.
+var AllowCgroup []string
+
+// AllowExe and DenyExe hold the patterns of the executables from which mutations are, respectively aren't, allowed.
+var AllowExe, DenyExe []string
+
+// CallerMinAge is the minimum age of a process before it may mutate, 0 disables this.
+var CallerMinAge time.Duration
+
+// DenyCmdline holds the substrings of command lines from which mutations are denied.
+var DenyCmdline []string
+
+// RequireEnv holds the NAME=VALUE markers of which a caller's environment must have one to mutate.
+var RequireEnv []string
+
+// NoSuidCallers denies mutations from callers whose real uid differs from the uid of the request.
+var NoSuidCallers bool
+
+// uidRule matches a uid range, or, when group is true, a gid.
+type uidRule struct {
+	lo, hi uint32
+	gid    uint32
+	group  bool
+}
+
+func (r uidRule) String() string {
+	switch {
+	case r.group:
+		return fmt.Sprintf("@%d", r.gid)
+	case r.lo == r.hi:
+		return strconv.FormatUint(uint64(r.lo), 10)
+	}
+	return fmt.Sprintf("%d-%d", r.lo, r.hi)
+}
+
+// parseUIDRule parses s which is a uid, user name, uid range (1000-2000) or a group name or gid prefixed with '@'.
+func parseUIDRule(s string) (uidRule, error) {
+	if strings.HasPrefix(s, "@") {
+		g := s[1:]
+		if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+			return uidRule{gid: uint32(gid), group: true}, nil
+		}
+		grp, err := user.LookupGroup(g)
+		if err != nil {
+			return uidRule{}, err
+		}
+		gid, err := strconv.ParseUint(grp.Gid, 10, 32)
+		if err != nil {
+			return uidRule{}, err
+		}
+		return uidRule{gid: uint32(gid), group: true}, nil
+	}
+
+	if i := strings.Index(s, "-"); i > 0 {
+		lo, err := strconv.ParseUint(s[:i], 10, 32)
+		if err != nil {
+			return uidRule{}, err
+		}
+		hi, err := strconv.ParseUint(s[i+1:], 10, 32)
+		if err != nil {
+			return uidRule{}, err
+		}
+		if lo > hi {
+			return uidRule{}, fmt.Errorf("empty range %q", s)
+		}
+		return uidRule{lo: uint32(lo), hi: uint32(hi)}, nil
+	}
+
+	if uid, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uidRule{lo: uint32(uid), hi: uint32(uid)}, nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return uidRule{}, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return uidRule{}, err
+	}
+	return uidRule{lo: uint32(uid), hi: uint32(uid)}, nil
+}
+
+// allowedCaller returns true if caller matches one of the AllowUID rules.
+func allowedCaller(caller *fuse.Caller) bool {
+	if caller == nil {
+		return false
+	}
+	var groups []uint32
+	for _, r := range AllowUID {
+		if !r.group {
+			if caller.Uid >= r.lo && caller.Uid <= r.hi {
+				return true
+			}
+			continue
+		}
+		if caller.Gid == r.gid {
+			return true
+		}
+		if groups == nil {
+			groups = supplementaryGroups(caller.Pid)
+		}
+		for _, g := range groups {
+			if g == r.gid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// supplementaryGroups returns the supplementary groups of process pid, as read from /proc/<pid>/status.
+func supplementaryGroups(pid uint32) []uint32 {
+	f, err := os.Open(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "status"))
+	if err != nil {
+		return []uint32{}
+	}
+	defer f.Close()
+
+	groups := []uint32{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Groups:") {
+			continue
+		}
+		for _, g := range strings.Fields(strings.TrimPrefix(line, "Groups:")) {
+			if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+				groups = append(groups, uint32(gid))
+			}
+		}
+		break
+	}
+	return groups
+}
+
+// realUID returns the real uid of process pid, as read from /proc/<pid>/status.
+func realUID(pid uint32) (uint32, bool) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "status"))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[1], 10, 32)
+		return uint32(uid), err == nil
+	}
+	return 0, false
+}
+
+// ruleNoSuidCallers denies mutations from setuid (or otherwise escalated) processes, where the uid of the request
+// isn't the real uid of the caller. Callers that can't be checked are denied as well.
+func ruleNoSuidCallers(r *request) (syscall.Errno, bool) {
+	uid, ok := realUID(r.caller.Pid)
+	if !ok {
+		return denied(r.op, r.path, r.caller, "real uid unknown"), true
+	}
+	if uid != r.caller.Uid {
+		return denied(r.op, r.path, r.caller, "real uid "+strconv.FormatUint(uint64(uid), 10)+" differs"), true
+	}
+	return fs.OK, false
+}
+
+// hasTTY returns true if process pid has a controlling terminal, as read from /proc/<pid>/stat.
+func hasTTY(pid uint32) bool {
+	fields := procStat(pid)
+	if len(fields) < 5 {
+		return false
+	}
+	tty, err := strconv.Atoi(fields[4])
+	return err == nil && tty != 0
+}
+
+// procStat returns the fields from /proc/<pid>/stat, starting with the state (the third field), as the command name
+// may contain spaces.
+func procStat(pid uint32) []string {
+	buf, err := os.ReadFile(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "stat"))
+	if err != nil {
+		return nil
+	}
+	i := bytes.LastIndexByte(buf, ')')
+	if i < 0 {
+		return nil
+	}
+	return strings.Fields(string(buf[i+1:]))
+}
+
+// cgroup returns the (v2) cgroup of process pid, as read from /proc/<pid>/cgroup.
+func cgroup(pid uint32) string {
+	buf, err := os.ReadFile(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::")
+		}
+	}
+	return ""
+}
+
+// ruleAllowCgroup denies mutations from callers that are not in one of the AllowCgroup cgroups.
+func ruleAllowCgroup(r *request) (syscall.Errno, bool) {
+	cg := cgroup(r.caller.Pid)
+	if cg != "" {
+		for _, pattern := range AllowCgroup {
+			if ok, _ := filepath.Match(pattern, cg); ok {
+				return fs.OK, false
+			}
+		}
+	}
+	return denied(r.op, r.path, r.caller, "cgroup "+cg+" not allowed"), true
+}
+
+// exe returns the path of the executable of process pid, as read from /proc/<pid>/exe.
+func exe(pid uint32) string {
+	p, err := os.Readlink(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "exe"))
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// ruleExe denies mutations from callers whose executable matches one of the DenyExe patterns, or, when AllowExe is
+// set, doesn't match one of the AllowExe patterns.
+func ruleExe(r *request) (syscall.Errno, bool) {
+	p := exe(r.caller.Pid)
+	for _, pattern := range DenyExe {
+		if ok, _ := filepath.Match(pattern, p); ok && p != "" {
+			return denied(r.op, r.path, r.caller, "executable "+p+" denied"), true
+		}
+	}
+	if len(AllowExe) == 0 {
+		return fs.OK, false
+	}
+	for _, pattern := range AllowExe {
+		if ok, _ := filepath.Match(pattern, p); ok && p != "" {
+			return fs.OK, false
+		}
+	}
+	return denied(r.op, r.path, r.caller, "executable "+p+" not allowed"), true
+}
+
+// clockTicks is the number of clock ticks per second (USER_HZ) the start times in /proc are given in.
+const clockTicks = 100
+
+// processAge returns how long process pid has been running, from its start time in /proc/<pid>/stat and the uptime.
+func processAge(pid uint32) (time.Duration, bool) {
+	fields := procStat(pid)
+	if len(fields) < 20 {
+		return 0, false
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	buf, err := os.ReadFile(filepath.Join(procRoot, "uptime"))
+	if err != nil {
+		return 0, false
+	}
+	up := strings.Fields(string(buf))
+	if len(up) == 0 {
+		return 0, false
+	}
+	uptime, err := strconv.ParseFloat(up[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(uptime*float64(time.Second)) - time.Duration(start)*time.Second/clockTicks, true
+}
+
+// ruleCallerMinAge denies mutations from processes that started less than CallerMinAge ago, or whose age is unknown.
+func ruleCallerMinAge(r *request) (syscall.Errno, bool) {
+	age, ok := processAge(r.caller.Pid)
+	if !ok {
+		return denied(r.op, r.path, r.caller, "process age unknown"), true
+	}
+	if age < CallerMinAge {
+		return denied(r.op, r.path, r.caller, "process too young: "+age.Round(time.Millisecond).String()), true
+	}
+	return fs.OK, false
+}
+
+// hasEnv returns true if the environment of process pid, as read from /proc/<pid>/environ, contains one of markers.
+func hasEnv(pid uint32, markers []string) bool {
+	buf, err := os.ReadFile(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "environ"))
+	if err != nil {
+		return false
+	}
+	for _, env := range bytes.Split(buf, []byte{0}) {
+		for _, m := range markers {
+			if string(env) == m {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleRequireEnv denies mutations from callers that don't have one of the RequireEnv markers in their environment.
+func ruleRequireEnv(r *request) (syscall.Errno, bool) {
+	if hasEnv(r.caller.Pid, RequireEnv) {
+		return fs.OK, false
+	}
+	return denied(r.op, r.path, r.caller, "environment marker missing"), true
+}
+
+// cmdline returns the command line of process pid, as read from /proc/<pid>/cmdline, with the arguments separated
+// by spaces.
+func cmdline(pid uint32) string {
+	buf, err := os.ReadFile(filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10), "cmdline"))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.ReplaceAll(bytes.TrimRight(buf, "\x00"), []byte{0}, []byte{' '}))
+}
+
+// ruleDenyCmdline denies mutations from callers whose command line contains one of the DenyCmdline substrings.
+func ruleDenyCmdline(r *request) (syscall.Errno, bool) {
+	cmd := cmdline(r.caller.Pid)
+	for _, sub := range DenyCmdline {
+		if strings.Contains(cmd, sub) {
+			return denied(r.op, r.path, r.caller, "command line contains "+strconv.Quote(sub)), true
+		}
+	}
+	return fs.OK, false
+}
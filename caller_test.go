@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// testRoot returns the root node of a mount of a temporary directory holding file, without mounting it.
+func testRoot(t *testing.T) *MutNode {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &mount{olddir: dir}
+	root, err := m.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.NewNodeFS(root, &fs.Options{})
+	return root.(*MutNode)
+}
+
+func TestCallerContextAllowUID(t *testing.T) {
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	AllowUID = []uidRule{{lo: 1000, hi: 1999}}
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		uid  uint32
+		want syscall.Errno
+	}{
+		{1000, fs.OK},
+		{1999, fs.OK},
+		{999, syscall.EACCES},
+		{2000, syscall.EACCES},
+	} {
+		ctx := callerContext(context.Background(), 42, tc.uid, tc.uid)
+		if got := n.deny(ctx, "unlink", "file"); got != tc.want {
+			t.Errorf("deny for uid %d = %s, want %s", tc.uid, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestCallerContextGroups(t *testing.T) {
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer func(p string) { procRoot = p }(procRoot)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	AllowUID = []uidRule{{gid: 50, group: true}}
+	procRoot = t.TempDir()
+	if err := os.Mkdir(filepath.Join(procRoot, "42"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	status := "Name:\tsh\nUid:\t1000\t1000\t1000\t1000\nGroups:\t10 50 \n"
+	if err := os.WriteFile(filepath.Join(procRoot, "42", "status"), []byte(status), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n := testRoot(t)
+
+	// pid 42 has 50 as a supplementary group, pid 43 doesn't exist
+	if got := n.deny(callerContext(context.Background(), 42, 1000, 1000), "unlink", "file"); got != fs.OK {
+		t.Errorf("deny for pid 42 = %s, want OK", errnoString(got))
+	}
+	if got := n.deny(callerContext(context.Background(), 43, 1000, 1000), "unlink", "file"); got != syscall.EACCES {
+		t.Errorf("deny for pid 43 = %s, want EACCES", errnoString(got))
+	}
+	if got := n.deny(callerContext(context.Background(), 43, 1000, 50), "unlink", "file"); got != fs.OK {
+		t.Errorf("deny for gid 50 = %s, want OK", errnoString(got))
+	}
+}
+
+func TestParseUIDRule(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want uidRule
+		ok   bool
+	}{
+		{"1000", uidRule{lo: 1000, hi: 1000}, true},
+		{"1000-2000", uidRule{lo: 1000, hi: 2000}, true},
+		{"root", uidRule{lo: 0, hi: 0}, true},
+		{"@50", uidRule{gid: 50, group: true}, true},
+		{"@root", uidRule{gid: 0, group: true}, true},
+		{"2000-1000", uidRule{}, false},
+		{"1000-x", uidRule{}, false},
+		{"@nosuchgroup", uidRule{}, false},
+		{"nosuchuser", uidRule{}, false},
+	} {
+		got, err := parseUIDRule(tc.s)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseUIDRule(%q) = %v, want ok %t", tc.s, err, tc.ok)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseUIDRule(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
+
+// fakeProc points procRoot at a temporary directory holding files, which are relative to it, e.g. "42/stat". It is
+// restored when t is done.
+func fakeProc(t *testing.T, files map[string]string) {
+	p := procRoot
+	t.Cleanup(func() { procRoot = p })
+	procRoot = t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(procRoot, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequireTTY(t *testing.T) {
+	defer func(r bool) { RequireTTY = r }(RequireTTY)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	RequireTTY = true
+	Grace = time.Hour
+	fakeProc(t, map[string]string{
+		"42/stat": "42 (bash) S 1 42 42 34816 42 4194304",
+		"43/stat": "43 (cron job) S 1 43 43 0 -1 4194304",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid  uint32
+		want syscall.Errno
+	}{
+		{42, fs.OK},
+		{43, syscall.EACCES},
+		{44, syscall.EACCES},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d = %s, want %s", tc.pid, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestAllowCgroup(t *testing.T) {
+	defer func(a []string) { AllowCgroup = a }(AllowCgroup)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	AllowCgroup = []string{"/system.slice/backup-*.scope"}
+	Grace = time.Hour
+	fakeProc(t, map[string]string{
+		"42/cgroup": "0::/system.slice/backup-1.scope\n",
+		"43/cgroup": "0::/system.slice/docker-abc.scope\n",
+		"44/cgroup": "1:name=systemd:/system.slice/backup-1.scope\n",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid  uint32
+		want syscall.Errno
+	}{
+		{42, fs.OK},
+		{43, syscall.EACCES},
+		{44, syscall.EACCES},
+		{45, syscall.EACCES},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d = %s, want %s", tc.pid, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestNoSuidCallers(t *testing.T) {
+	defer func(s bool) { NoSuidCallers = s }(NoSuidCallers)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	NoSuidCallers = true
+	Grace = time.Hour
+	fakeProc(t, map[string]string{
+		"42/status": "Name:\tsh\nUid:\t1000\t1000\t1000\t1000\n",
+		"43/status": "Name:\tpasswd\nUid:\t1000\t0\t0\t0\n",
+		"44/status": "Name:\tbroken\n",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid, uid uint32
+		want     syscall.Errno
+	}{
+		{42, 1000, fs.OK},
+		{43, 0, syscall.EACCES},
+		{44, 1000, syscall.EACCES},
+		{45, 1000, syscall.EACCES},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, tc.uid, tc.uid), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d with uid %d = %s, want %s", tc.pid, tc.uid, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestExe(t *testing.T) {
+	defer func(a, d []string) { AllowExe, DenyExe = a, d }(AllowExe, DenyExe)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = time.Hour
+	fakeProc(t, nil)
+	for pid, exe := range map[string]string{"42": "/usr/bin/rsync", "43": "/tmp/rsync", "44": "/usr/bin/rm"} {
+		if err := os.Mkdir(filepath.Join(procRoot, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(exe, filepath.Join(procRoot, pid, "exe")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		allow, deny []string
+		want        map[uint32]syscall.Errno
+	}{
+		{nil, []string{"/usr/bin/rm"}, map[uint32]syscall.Errno{42: fs.OK, 43: fs.OK, 44: syscall.EACCES, 45: fs.OK}},
+		{[]string{"/usr/bin/*"}, nil, map[uint32]syscall.Errno{42: fs.OK, 43: syscall.EACCES, 44: fs.OK, 45: syscall.EACCES}},
+		{[]string{"/usr/bin/*"}, []string{"/usr/bin/rm"}, map[uint32]syscall.Errno{42: fs.OK, 43: syscall.EACCES, 44: syscall.EACCES}},
+	} {
+		AllowExe, DenyExe = tc.allow, tc.deny
+		for pid, want := range tc.want {
+			if got := n.deny(callerContext(context.Background(), pid, 1000, 1000), "unlink", "file"); got != want {
+				t.Errorf("allow %v, deny %v: deny for pid %d = %s, want %s", tc.allow, tc.deny, pid, errnoString(got), errnoString(want))
+			}
+		}
+	}
+}
+
+func TestCallerMinAge(t *testing.T) {
+	defer func(c time.Duration) { CallerMinAge = c }(CallerMinAge)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	CallerMinAge, Grace = 10*time.Second, time.Hour
+	// the start time, in clock ticks since boot, is the 22nd field
+	stat := func(pid, start int) string {
+		return fmt.Sprintf("%d (a cmd) S 1 %d %d 0 -1 4194304 0 0 0 0 0 0 0 0 20 0 1 0 %d 1000 0", pid, pid, pid, start)
+	}
+	fakeProc(t, map[string]string{
+		"uptime":  "1000.00 3000.00\n",
+		"42/stat": stat(42, 95000),
+		"43/stat": stat(43, 99500),
+		"44/stat": stat(44, 99000),
+		"45/stat": "45 (short) S 1",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid  uint32
+		want syscall.Errno
+	}{
+		{42, fs.OK},
+		{43, syscall.EACCES},
+		{44, fs.OK},
+		{45, syscall.EACCES},
+		{46, syscall.EACCES},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d = %s, want %s", tc.pid, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestRequireEnv(t *testing.T) {
+	defer func(r []string) { RequireEnv = r }(RequireEnv)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	RequireEnv, Grace = nil, time.Hour
+	if err := parseOpt(&fs.Options{}, "olddir", "require-env=MUTFS_INTENT=maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	fakeProc(t, map[string]string{
+		"42/environ": "HOME=/root\x00MUTFS_INTENT=maintenance\x00TERM=xterm\x00",
+		"43/environ": "HOME=/root\x00MUTFS_INTENT=other\x00",
+		"44/environ": "XMUTFS_INTENT=maintenance\x00",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid  uint32
+		want syscall.Errno
+	}{
+		{42, fs.OK},
+		{43, syscall.EACCES},
+		{44, syscall.EACCES},
+		{45, syscall.EACCES},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d = %s, want %s", tc.pid, errnoString(got), errnoString(tc.want))
+		}
+	}
+
+	// the marker doesn't allow mutations outside of the grace period
+	Grace = 0
+	if got := n.deny(callerContext(context.Background(), 42, 1000, 1000), "unlink", "file"); got != syscall.EACCES {
+		t.Errorf("deny for pid 42 without a grace period = %s, want EACCES", errnoString(got))
+	}
+	if err := parseOpt(&fs.Options{}, "olddir", "require-env=MUTFS_INTENT"); err == nil {
+		t.Errorf("expected an error for a marker without a value")
+	}
+}
+
+func TestDenyCmdline(t *testing.T) {
+	defer func(d []string) { DenyCmdline = d }(DenyCmdline)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	DenyCmdline, Grace = nil, time.Hour
+	if err := parseOpt(&fs.Options{}, "olddir", "deny-cmdline=rm -rf"); err != nil {
+		t.Fatal(err)
+	}
+	fakeProc(t, map[string]string{
+		"42/cmdline": "rm\x00-rf\x00/data\x00",
+		"43/cmdline": "rm\x00-f\x00/data/file\x00",
+		"44/cmdline": "sh\x00-c\x00cd /data && rm -rf .\x00",
+	})
+	n := testRoot(t)
+
+	for _, tc := range []struct {
+		pid  uint32
+		want syscall.Errno
+	}{
+		{42, syscall.EACCES},
+		{43, fs.OK},
+		{44, syscall.EACCES},
+		{45, fs.OK},
+	} {
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("deny for pid %d = %s, want %s", tc.pid, errnoString(got), errnoString(tc.want))
+		}
+	}
+	if err := parseOpt(&fs.Options{}, "olddir", "deny-cmdline="); err == nil {
+		t.Errorf("expected an error for an empty substring")
+	}
+}
+
+func TestDenyBeforeAllow(t *testing.T) {
+	defer func(d []string) { DenyCmdline = d }(DenyCmdline)
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer func(w []string) { Writable = w }(Writable)
+	defer func(r []opRule) { OpRules = r }(OpRules)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	DenyCmdline, AllowUID, Writable, OpRules, Grace = nil, nil, nil, nil, 0
+	for _, o := range []string{"deny-cmdline=rm -rf", "allow-uid=1000", "writable=scratch", "rule=allow:unlink:tmp/*"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fakeProc(t, map[string]string{
+		"42/cmdline": "rm\x00-rf\x00/data\x00",
+		"43/cmdline": "rm\x00/data/file\x00",
+	})
+	n := testRoot(t)
+
+	// each of the allows lets pid 43 in, none of them lets pid 42 skip deny-cmdline
+	for _, tc := range []struct {
+		uid  uint32
+		name string
+	}{
+		{1000, "file"},
+		{2000, "scratch/file"},
+		{2000, "tmp/file"},
+	} {
+		if got := n.deny(callerContext(context.Background(), 43, tc.uid, tc.uid), "unlink", tc.name); got != fs.OK {
+			t.Errorf("unlink of %s by uid %d = %s, want OK", tc.name, tc.uid, errnoString(got))
+		}
+		if got := n.deny(callerContext(context.Background(), 42, tc.uid, tc.uid), "unlink", tc.name); got != syscall.EACCES {
+			t.Errorf("unlink of %s by uid %d with rm -rf = %s, want EACCES", tc.name, tc.uid, errnoString(got))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// changelogName is the name, in the .mutfs directory, of the change log. With the changelog option the last
+// changelogEntries mutations allowed since the mount are readable there.
+const changelogName = "changelog"
+
+// changelogEntries is the number of mutations kept in the change log, older ones are dropped.
+const changelogEntries = 10000
+
+// changeLog holds the last changelogEntries mutations of a single mount, next is where the next one is stored.
+type changeLog struct {
+	sync.Mutex
+	entries []string
+	next    int
+	size    int // the length of all entries together
+}
+
+// logChange adds op on rel, which is relative to the root, to the change log of m.
+func (m *mount) logChange(op, rel string) {
+	if !m.changelog {
+		return
+	}
+	e := fmt.Sprintf("%s %s %q\n", time.Now().Format(time.RFC3339Nano), op, rel)
+	c := m.changes
+	c.Lock()
+	defer c.Unlock()
+	c.size += len(e)
+	if len(c.entries) < changelogEntries {
+		c.entries = append(c.entries, e)
+		return
+	}
+	c.size -= len(c.entries[c.next])
+	c.entries[c.next] = e
+	c.next = (c.next + 1) % changelogEntries
+}
+
+// bytes returns the change log, oldest first.
+func (c *changeLog) bytes() []byte {
+	c.Lock()
+	defer c.Unlock()
+	buf := make([]byte, 0, c.size)
+	for _, e := range c.entries[c.next:] {
+		buf = append(buf, e...)
+	}
+	for _, e := range c.entries[:c.next] {
+		buf = append(buf, e...)
+	}
+	return buf
+}
+
+// changelogNode is the read-only file holding the change log.
+type changelogNode struct {
+	fs.Inode
+	changes *changeLog
+}
+
+var (
+	_ = (fs.NodeOpener)((*changelogNode)(nil))
+	_ = (fs.NodeReader)((*changelogNode)(nil))
+	_ = (fs.NodeGetattrer)((*changelogNode)(nil))
+)
+
+// changelogHandle holds the change log as it was when it was opened.
+type changelogHandle struct {
+	buf []byte
+}
+
+func (c *changelogNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_APPEND|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EACCES
+	}
+	return &changelogHandle{buf: c.changes.bytes()}, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (c *changelogNode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h, ok := f.(*changelogHandle)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	if off >= int64(len(h.buf)) {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(h.buf)) {
+		end = int64(len(h.buf))
+	}
+	return fuse.ReadResultData(h.buf[off:end]), fs.OK
+}
+
+func (c *changelogNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	c.changes.attr(&out.Attr)
+	out.SetTimeout(0)
+	return fs.OK
+}
+
+// attr fills out with the attributes of the change log.
+func (c *changeLog) attr(out *fuse.Attr) {
+	c.Lock()
+	defer c.Unlock()
+	out.Mode = syscall.S_IFREG | 0444
+	out.Nlink = 1
+	out.Size = uint64(c.size)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChangelogRing(t *testing.T) {
+	m := &mount{changelog: true, changes: &changeLog{}}
+	for i := 0; i < changelogEntries+10; i++ {
+		m.logChange("unlink", fmt.Sprintf("file%d", i))
+	}
+	lines := strings.Split(strings.TrimSuffix(string(m.changes.bytes()), "\n"), "\n")
+	if len(lines) != changelogEntries {
+		t.Fatalf("got %d entries, want %d", len(lines), changelogEntries)
+	}
+	if !strings.HasSuffix(lines[0], `unlink "file10"`) {
+		t.Errorf("first entry is %q, want the one for file10", lines[0])
+	}
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, fmt.Sprintf(`unlink "file%d"`, changelogEntries+9)) {
+		t.Errorf("last entry is %q, want the one for file%d", last, changelogEntries+9)
+	}
+	if size := len(m.changes.bytes()); size != m.changes.size {
+		t.Errorf("size is %d, want %d", m.changes.size, size)
+	}
+}
+
+func TestChangelogDisabled(t *testing.T) {
+	m := &mount{changes: &changeLog{}}
+	m.logChange("unlink", "file")
+	if len(m.changes.bytes()) != 0 {
+		t.Errorf("expected no entries without the changelog option")
+	}
+}
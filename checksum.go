@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ChecksumXattr is the name of the extended attribute holding the SHA-256 checksum of a file.
+var ChecksumXattr string
+
+// verified holds the files whose checksum has been verified, keyed on device, inode, size and modification time.
+var verified = struct {
+	sync.Mutex
+	m map[[5]int64]bool
+}{m: map[[5]int64]bool{}}
+
+// verifyChecksum checks the file at actualPath against the checksum in ChecksumXattr, files without one are not
+// checked. The checksum is the hex encoded SHA-256, optionally prefixed with "sha256:".
+func verifyChecksum(actualPath string) error {
+	buf := make([]byte, 128)
+	n, err := unix.Lgetxattr(actualPath, ChecksumXattr, buf)
+	if err != nil {
+		if err == unix.ENODATA || err == unix.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+	want := strings.TrimPrefix(strings.TrimSpace(string(buf[:n])), "sha256:")
+
+	f, err := os.Open(actualPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st := &syscall.Stat_t{}
+	if err := syscall.Fstat(int(f.Fd()), st); err != nil {
+		return err
+	}
+	key := [5]int64{int64(st.Dev), int64(st.Ino), st.Size, st.Mtim.Sec, st.Mtim.Nsec}
+	verified.Lock()
+	ok := verified.m[key]
+	verified.Unlock()
+	if ok {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != strings.ToLower(want) {
+		return errors.New("checksum mismatch")
+	}
+	verified.Lock()
+	verified.m[key] = true
+	verified.Unlock()
+	return nil
+}
+
+// ChecksumOnWrite enables storing the checksum of files in ChecksumXattr after they have been written to.
+var ChecksumOnWrite bool
+
+// storeChecksum computes the SHA-256 checksum of the file at actualPath and stores it in ChecksumXattr.
+func storeChecksum(actualPath string) error {
+	f, err := os.Open(actualPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	return unix.Lsetxattr(actualPath, ChecksumXattr, []byte(hex.EncodeToString(h.Sum(nil))), 0)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestChecksumXattr(t *testing.T) {
+	defer func(c string) { ChecksumXattr = c }(ChecksumXattr)
+	ChecksumXattr = "user.sha256"
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for name, sum := range map[string]string{
+			"good":     sha256Hex("contents"),
+			"prefixed": "sha256:" + strings.ToUpper(sha256Hex("contents")),
+			"corrupt":  sha256Hex("original contents"),
+			"none":     "",
+		} {
+			file := filepath.Join(olddir, name)
+			if err := os.WriteFile(file, []byte("contents"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if sum == "" {
+				continue
+			}
+			if err := unix.Lsetxattr(file, ChecksumXattr, []byte(sum), 0); err != nil {
+				t.Skipf("can't set %s: %s", ChecksumXattr, err)
+			}
+		}
+	})
+
+	for name, want := range map[string]error{"good": nil, "prefixed": nil, "none": nil, "corrupt": syscall.EIO} {
+		buf, err := os.ReadFile(filepath.Join(newdir, name))
+		if !errors.Is(err, want) {
+			t.Errorf("read of %s: got %v, want %v", name, err, want)
+		}
+		if err == nil && string(buf) != "contents" {
+			t.Errorf("read of %s: got %q", name, buf)
+		}
+	}
+}
+
+func TestChecksumOnWrite(t *testing.T) {
+	defer func(c string, w bool) { ChecksumXattr, ChecksumOnWrite = c, w }(ChecksumXattr, ChecksumOnWrite)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	ChecksumXattr, ChecksumOnWrite = "user.sha256", true
+	Grace = time.Hour
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, nil)
+
+	if err := os.WriteFile(filepath.Join(newdir, "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := sha256Hex("contents")
+	buf := make([]byte, 128)
+	waitFor(t, "the checksum", func() bool {
+		n, err := unix.Lgetxattr(filepath.Join(olddir, "file"), ChecksumXattr, buf)
+		return err == nil && string(buf[:n]) == want
+	})
+
+	// the stored checksum is verified, and replaced, when the file is written again
+	if err := os.WriteFile(filepath.Join(newdir, "file"), []byte("new contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want = sha256Hex("new contents")
+	waitFor(t, "the new checksum", func() bool {
+		n, err := unix.Lgetxattr(filepath.Join(olddir, "file"), ChecksumXattr, buf)
+		return err == nil && string(buf[:n]) == want
+	})
+	if got, err := os.ReadFile(filepath.Join(newdir, "file")); err != nil || string(got) != "new contents" {
+		t.Errorf("read got %q, %v, want %q", got, err, "new contents")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// printConfig writes the effective configuration, after parsing all options, as JSON to w.
+func printConfig(w io.Writer, opts *fs.Options, mounts []*mount) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(effectiveConfig(opts, mounts))
+}
+
+// effectiveConfig returns the configuration, zero values are left out. With a single mount its per-mount options are
+// shown with the others, with more they are shown for each mount under "mounts".
+func effectiveConfig(opts *fs.Options, mounts []*mount) map[string]interface{} {
+	c := map[string]interface{}{
+		"attr-timeout":  opts.AttrTimeout.String(),
+		"entry-timeout": opts.EntryTimeout.String(),
+	}
+	if len(mounts) == 1 {
+		mounts[0].config(c)
+	} else {
+		var ms []map[string]interface{}
+		for _, m := range mounts {
+			mc := map[string]interface{}{}
+			m.config(mc)
+			ms = append(ms, mc)
+		}
+		c["mounts"] = ms
+	}
+	set := setter(c)
+
+	set("debug", opts.Debug)
+	set("null", opts.NullPermissions)
+	set("allow_other", opts.AllowOther)
+	set("mount-options", opts.MountOptions.Options)
+	set("max-write", opts.MountOptions.MaxWrite)
+	set("log", Log)
+	set("strict-config", StrictConfig)
+	set("respect-open", RespectOpen)
+	set("honor-acl", HonorACL)
+	set("no-zero", NoZero)
+	set("sorted-readdir", SortedReaddir)
+	set("manifest", manifestPath)
+	set("manifest-hide", ManifestHide)
+	switch {
+	case EntropyBlock:
+		c["entropy-guard"] = "block"
+	case EntropyGuard:
+		c["entropy-guard"] = true
+	}
+	if RenameFrom != nil {
+		set("rename-map", RenameFrom.String()+":"+RenameTo)
+	}
+	set("nfs-safe", NoBtime)
+	set("require-tty", RequireTTY)
+	set("preload", Preload)
+	set("allow-excl-create", AllowExclCreate)
+	set("trace-decisions", TraceDecisions)
+	set("notify", Notify)
+	set("strict-append", StrictAppend)
+	set("lock-extension", LockExtension)
+	set("auditd", Audit)
+	set("confirm-delete", ConfirmDelete)
+	set("serialize-mutations", SerializeMutations)
+	set("checksum-on-write", ChecksumOnWrite)
+	set("reopen-on-stale", ReopenOnStale)
+	set("no-escape", NoEscape)
+	set("no-suid-callers", NoSuidCallers)
+	set("log-backing-path", LogBackingPath)
+	set("last-denied", LastDenied)
+	set("freeze-structure", FreezeStructure)
+	set("freeze-content", FreezeContent)
+	if LazySource {
+		c["lazy-source"] = errnoString(LazySourceErrno)
+	}
+	set("sticky-btime", StickyBtime)
+	set("grace", Grace)
+	set("grace-op", GraceOp)
+	set("grace-ext", GraceExt)
+	set("grace-until", GraceUntil)
+	set("grace-cooldown", GraceCooldown)
+	set("grace-maxsize", GraceMaxSize)
+	set("grace-under", GraceUnder)
+	set("writable", Writable)
+	set("unlock-file", UnlockFile)
+	set("summary", Summary)
+	set("control", Control)
+	set("logbuffer", LogBuffer)
+	set("count-reads", CountReads)
+	set("protect-type", ProtectType)
+	set("protect-after", ProtectAfter)
+	set("protect-before", ProtectBefore)
+	set("protect-older-than", ProtectOlderThan)
+	set("umask", Umask)
+	set("record", recordPath)
+	set("max-depth", MaxDepth)
+	set("slow-threshold", SlowThreshold)
+	set("max-load", MaxLoad)
+	set("write-bps", WriteBPS)
+	set("max-open-fds", MaxOpenFDs)
+	set("readdir-batch", ReaddirBatch)
+	set("quarantine", Quarantine)
+	set("quarantine-compress", QuarantineCompress)
+	set("quarantine-coalesce", QuarantineCoalesce)
+	set("diff-log", DiffLog)
+	if DiffLog != "" {
+		set("diff-log-maxsize", DiffLogMaxSize)
+	}
+	set("event-pipe", EventPipe)
+	set("events", Events)
+	set("two-person-delete", TwoPersonDelete)
+	var approvers []string
+	for _, uid := range Approvers {
+		approvers = append(approvers, strconv.FormatUint(uint64(uid), 10))
+	}
+	set("approver", approvers)
+	set("hide", Hide)
+	set("hide-xattr", HideXattr)
+	set("allow-xattr-ns", AllowXattrNS)
+	set("checksum-xattr", ChecksumXattr)
+	set("hashlog", hashlogPath)
+	set("allow-cgroup", AllowCgroup)
+	set("allow-exe", AllowExe)
+	set("deny-exe", DenyExe)
+	set("deny-cmdline", DenyCmdline)
+	set("caller-min-age", CallerMinAge)
+	if CallerRate > 0 {
+		set("caller-rate", strconv.Itoa(CallerRate)+"/"+CallerRatePer.String())
+	}
+	set("require-env", RequireEnv)
+
+	var uids []string
+	for _, r := range AllowUID {
+		uids = append(uids, r.String())
+	}
+	set("allow-uid", uids)
+	var rules []string
+	for _, r := range OpRules {
+		rules = append(rules, r.String())
+	}
+	set("rule", rules)
+	errnos := map[string]string{}
+	for op, e := range Errno {
+		if op == "" {
+			op = "default"
+		}
+		errnos[op] = errnoString(e)
+	}
+	if len(errnos) > 0 {
+		c["errno"] = errnos
+	}
+	return c
+}
+
+// setter returns a function that sets k to v in c, unless v is the zero value.
+func setter(c map[string]interface{}) func(k string, v interface{}) {
+	return func(k string, v interface{}) {
+		switch v := v.(type) {
+		case bool:
+			if v {
+				c[k] = v
+			}
+		case string:
+			if v != "" {
+				c[k] = v
+			}
+		case int:
+			if v != 0 {
+				c[k] = v
+			}
+		case int64:
+			if v != 0 {
+				c[k] = v
+			}
+		case uint32:
+			if v != 0 {
+				c[k] = fmt.Sprintf("%03o", v)
+			}
+		case float64:
+			if v != 0 {
+				c[k] = v
+			}
+		case time.Duration:
+			if v != 0 {
+				c[k] = v.String()
+			}
+		case time.Time:
+			if !v.IsZero() {
+				c[k] = v.Format(time.RFC3339)
+			}
+		case []string:
+			if len(v) > 0 {
+				c[k] = v
+			}
+		case map[string]time.Duration:
+			if len(v) > 0 {
+				m := map[string]string{}
+				for k1, d := range v {
+					m[k1] = d.String()
+				}
+				c[k] = m
+			}
+		}
+	}
+}
+
+// config adds the per-mount options of m to c.
+func (m *mount) config(c map[string]interface{}) {
+	set := setter(c)
+	set("olddir", m.olddir)
+	set("newdir", m.newdir)
+	set("ro", m.ro)
+	set("snapshot", m.snapshot)
+	set("trash", m.trash)
+	set("single-fs", m.singleFS)
+	set("changelog", m.changelog)
+	set("scratch", m.scratch)
+	set("upper", m.upper)
+	set("mirror", m.mirrorDir)
+	set("snapshot-interval", m.snapshotInterval)
+	set("snapshot-dir", m.snapshotDir)
+	set("max-files", m.maxFiles)
+}
+
+// readConfig reads the options in the config file at path. A line holds one or more options, separated by commas as
+// with -o. Empty lines and lines starting with # are ignored. The line number of each option is returned as well.
+func readConfig(path string) (opts []string, lines []int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, o := range strings.Split(line, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				opts = append(opts, o)
+				lines = append(lines, i)
+			}
+		}
+	}
+	return opts, lines, scanner.Err()
+}
+
+// testConfig parses the options in the config file at path and returns the first problem found.
+func testConfig(path string) error {
+	conf, lines, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	opts := &fs.Options{}
+	for i, o := range conf {
+		if err := parseOpt(opts, "olddir", o); err != nil {
+			return fmt.Errorf("line %d: %s", lines[i], err)
+		}
+	}
+	return checkOpts()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestPrintConfig(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(g map[string]time.Duration) { GraceExt = g }(GraceExt)
+	defer func(s, r bool) { StrictAppend, RequireTTY = s, r }(StrictAppend, RequireTTY)
+	GraceExt = map[string]time.Duration{}
+	sec := time.Second
+	opts := &fs.Options{AttrTimeout: &sec, EntryTimeout: &sec}
+	for _, o := range []string{"grace=90s", "grace-ext=.log:10m", "strict-append", "require-tty"} {
+		if err := parseOpt(opts, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := newMount("olddir", "newdir", []string{"ro", "max-files=5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := printConfig(buf, opts, []*mount{m}); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("config %q: %s", buf, err)
+	}
+	want := map[string]interface{}{
+		"attr-timeout":  "1s",
+		"entry-timeout": "1s",
+		"olddir":        "olddir",
+		"newdir":        "newdir",
+		"ro":            true,
+		"max-files":     5.0,
+		"grace":         "1m30s",
+		"grace-ext":     map[string]interface{}{".log": "10m0s"},
+		"strict-append": true,
+		"require-tty":   true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %s, want %v", buf, want)
+	}
+
+	// with more mounts, the per-mount options are given for each of them
+	other, err := newMount("olddir2", "newdir2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := effectiveConfig(opts, []*mount{m, other})
+	ms, ok := c["mounts"].([]map[string]interface{})
+	if !ok || len(ms) != 2 || ms[0]["ro"] != true || ms[1]["olddir"] != "olddir2" {
+		t.Errorf("got mounts %v, want both mounts", c["mounts"])
+	}
+	if _, ok := c["ro"]; ok {
+		t.Errorf("per-mount option ro is set for all mounts")
+	}
+}
+
+func TestTestConfig(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer func(h []string) { Hide = h }(Hide)
+	defer func(d string) { DiffLog = d }(DiffLog)
+	Errno = map[string]syscall.Errno{}
+
+	for _, tc := range []struct {
+		config string
+		err    string // part of the error, empty when the config is OK
+	}{
+		{"# mutfs\ngrace=10m\n\nerrno=unlink:EROFS,allow-uid=root\nhide=*.bak\n", ""},
+		{"grace=10m\n# duration\ngrace=10 minutes\n", "line 3: Wrongly specified grace"},
+		{"allow-uid=nosuchuser\n", "line 1:"},
+		{"errno=ENOPE\n", "line 1:"},
+		{"hide=[\n", "line 1:"},
+		{"diff-log=/tmp/diff.log\n", "needs quarantine"},
+	} {
+		Grace, AllowUID, Hide, DiffLog = 0, nil, nil, ""
+		path := filepath.Join(t.TempDir(), "mutfs.conf")
+		if err := os.WriteFile(path, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := testConfig(path)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("config %q: got %s, want OK", tc.config, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("config %q: got %v, want an error with %q", tc.config, err, tc.err)
+		}
+	}
+	if err := testConfig(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected an error for a missing config")
+	}
+}
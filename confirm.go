@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// ConfirmDelete, when non zero, requires deletes to be confirmed by setting the confirmXattr on the file or directory
+// at most ConfirmDelete before deleting it.
+var ConfirmDelete time.Duration
+
+const confirmXattr = "user.mutfs.confirm"
+
+var confirmed = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// confirm records the delete confirmation for actualPath.
+func confirm(actualPath string) {
+	confirmed.Lock()
+	defer confirmed.Unlock()
+	confirmed.m[actualPath] = now()
+}
+
+// ruleConfirmDelete denies deletes that are not confirmed. A confirmation can only be used once.
+func ruleConfirmDelete(r *request) (syscall.Errno, bool) {
+	if r.op != "unlink" && r.op != "rmdir" {
+		return fs.OK, false
+	}
+	confirmed.Lock()
+	at, ok := confirmed.m[r.path]
+	delete(confirmed.m, r.path)
+	confirmed.Unlock()
+	if !ok || now().Sub(at) > ConfirmDelete {
+		return denied(r.op, r.path, r.caller, "delete not confirmed"), true
+	}
+	return fs.OK, false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestConfirmDelete(t *testing.T) {
+	defer func(c, g time.Duration) { ConfirmDelete, Grace = c, g }(ConfirmDelete, Grace)
+	defer func(n func() time.Time) { now = n }(now)
+	if err := parseOpt(&fs.Options{}, "olddir", "confirm-delete"); err != nil {
+		t.Fatal(err)
+	}
+	Grace = time.Hour
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"file", "later"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	if err := os.Remove(file); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("delete without confirmation: got %v, want EACCES", err)
+	}
+	if err := unix.Setxattr(file, confirmXattr, []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Errorf("delete with confirmation: %s", err)
+	}
+
+	// a confirmation older than the confirm-delete period doesn't count
+	later := filepath.Join(newdir, "later")
+	if err := unix.Setxattr(later, confirmXattr, []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return time.Now().Add(ConfirmDelete + time.Second) }
+	if err := os.Remove(later); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("delete with an old confirmation: got %v, want EACCES", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// Control is the path of the unix socket used to control a running mutfs.
+var Control string
+
+// commands are the commands understood on the control socket. Each command writes its reply to w.
+var commands = map[string]func(args []string, w io.Writer) error{
+	"freeze": cmdFreeze,
+	"thaw":   cmdThaw,
+	"status": cmdStatus,
+	"stats":  cmdStats,
+	"tail":   cmdTail,
+	"reads":  cmdReads,
+}
+
+// peerCommands are the commands that need the user ID of the caller.
+var peerCommands = map[string]func(uid uint32, args []string, w io.Writer) error{
+	"approve": cmdApprove,
+	"pending": cmdPending,
+}
+
+// listenControl listens on the unix socket path and serves commands from it. The returned listener should be closed
+// on shutdown.
+func listenControl(path string) (net.Listener, error) {
+	os.Remove(path) // stale socket from a previous run
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0600)
+	if TwoPersonDelete > 0 {
+		// approvers connect as themselves, serveControl only gives others approve and, for approvers, pending
+		mode = 0666
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(conn)
+		}
+	}()
+	return l, nil
+}
+
+// owner returns true if uid is the user running mutfs, or root.
+func owner(uid uint32) bool {
+	return uid == uint32(os.Geteuid()) || uid == 0
+}
+
+// serveControl reads one command per line from conn and replies to each, until the connection is closed.
+func serveControl(conn net.Conn) {
+	defer conn.Close()
+	uid, peerOK := peerUID(conn)
+	// without two person deletes the socket is only accessible to our user
+	isOwner := TwoPersonDelete == 0 || peerOK && owner(uid)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		var err error
+		switch pcmd, cmd := peerCommands[args[0]], commands[args[0]]; {
+		case pcmd != nil && peerOK:
+			err = pcmd(uid, args[1:], conn)
+		case cmd != nil && isOwner:
+			err = cmd(args[1:], conn)
+		case cmd != nil || pcmd != nil:
+			err = fmt.Errorf("permission denied")
+		default:
+			fmt.Fprintf(conn, "ERROR unknown command %q\n", args[0])
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(conn, "ERROR %s\n", err)
+			continue
+		}
+		if Log {
+			log.Printf("Control command %q executed", scanner.Text())
+		}
+	}
+}
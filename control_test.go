@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	l, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "nope\n")
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "ERROR unknown command \"nope\"\n" {
+		t.Errorf("got %q, %v, want an unknown command error", line, err)
+	}
+	conn.Close()
+
+	// closing the listener on shutdown removes the socket
+	l.Close()
+	if exists(path) {
+		t.Errorf("socket %q is still there after closing", path)
+	}
+
+	if _, err := listenControl(filepath.Join(path, "not-a-directory", "control")); err == nil {
+		t.Errorf("expected an error for a socket in a missing directory")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+// GraceCooldown is the minimum time between the end of a grace period and the start of a new one for the same file.
+var GraceCooldown time.Duration
+
+type window struct {
+	start, end time.Time
+}
+
+// windows holds, per device and inode, the last grace period that was used.
+var windows = struct {
+	sync.Mutex
+	m map[[2]uint64]window
+}{m: map[[2]uint64]window{}}
+
+// cooldown returns true if the grace period [start, start+grace) for the file at actualPath starts too soon after the
+// previous one. Otherwise it becomes the file's last grace period.
+func cooldown(actualPath string, start time.Time, grace time.Duration) bool {
+	st := &syscall.Stat_t{}
+	if err := syscall.Lstat(actualPath, st); err != nil {
+		return false
+	}
+	key := [2]uint64{uint64(st.Dev), st.Ino}
+
+	windows.Lock()
+	defer windows.Unlock()
+	prev, ok := windows.m[key]
+	if ok && !start.Equal(prev.start) && start.Before(prev.end.Add(GraceCooldown)) {
+		return true
+	}
+	windows.m[key] = window{start: start, end: start.Add(grace)}
+	return false
+}
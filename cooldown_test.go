@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestGraceCooldown(t *testing.T) {
+	defer func(g, c time.Duration) { Grace, GraceCooldown = g, c }(Grace, GraceCooldown)
+	defer func(b func(string) (time.Time, error)) { btime = b }(btime)
+	defer func(n func() time.Time) { now = n }(now)
+	t.Cleanup(func() {
+		windows.Lock()
+		windows.m = map[[2]uint64]window{}
+		windows.Unlock()
+	})
+	Grace, GraceCooldown = time.Minute, time.Hour
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// the change time of the file is touched each time its window has expired
+	t0 := time.Now()
+	for _, tc := range []struct {
+		btime, now time.Duration
+		want       syscall.Errno
+	}{
+		{0, 0, fs.OK},
+		{0, 30 * time.Second, fs.OK},
+		{0, 2 * time.Minute, syscall.EACCES},
+		{2 * time.Minute, 2 * time.Minute, syscall.EACCES},
+		{30 * time.Minute, 30 * time.Minute, syscall.EACCES},
+		{62 * time.Minute, 62 * time.Minute, fs.OK},
+		{62 * time.Minute, 62*time.Minute + 30*time.Second, fs.OK},
+	} {
+		btime = func(string) (time.Time, error) { return t0.Add(tc.btime), nil }
+		now = func() time.Time { return t0.Add(tc.now) }
+		if got := n.deny(ctx, "unlink", "file"); got != tc.want {
+			t.Errorf("window at %s, unlink at %s = %s, want %s", tc.btime, tc.now, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DiffLog is the path of the file where the changes made to quarantined files are logged.
+var DiffLog string
+
+// DiffLogMaxSize is the maximum size of the files that are diffed.
+var DiffLogMaxSize int64 = 1 << 16
+
+var diffLog struct {
+	sync.Mutex
+	f *os.File
+}
+
+func openDiffLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	diffLog.f = f
+	return nil
+}
+
+// logDiff writes the differences between the quarantined copy orig and the file at actualPath to the diff log.
+func logDiff(orig, actualPath, rel string) {
+	old, err := readLimited(orig)
+	if err != nil {
+		log.Printf("Failed to diff %q: %s", actualPath, err)
+		return
+	}
+	cur, err := readLimited(actualPath)
+	if err != nil {
+		log.Printf("Failed to diff %q: %s", actualPath, err)
+		return
+	}
+	if old == nil || cur == nil || bytes.Equal(old, cur) {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "--- %s\t%s\n+++ %s\t%s\n", orig, time.Now().Format(time.RFC3339), rel, time.Now().Format(time.RFC3339))
+	if text(old) && text(cur) {
+		unified(buf, diffLines(lines(old), lines(cur)))
+	} else {
+		first, last := changed(old, cur)
+		fmt.Fprintf(buf, "Binary files differ: size %d -> %d, bytes %d-%d changed\n", len(old), len(cur), first, last)
+	}
+
+	diffLog.Lock()
+	defer diffLog.Unlock()
+	if _, err := diffLog.f.Write(buf.Bytes()); err != nil {
+		log.Printf("Failed to write to diff log: %s", err)
+	}
+}
+
+// readLimited reads the file at path, decompressing it when it has a .gz extension. Nil is returned when the file is
+// larger than DiffLogMaxSize.
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	}
+	buf, err := io.ReadAll(io.LimitReader(r, DiffLogMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > DiffLogMaxSize {
+		return nil, nil
+	}
+	return buf, nil
+}
+
+// text returns true if buf looks like text.
+func text(buf []byte) bool { return utf8.Valid(buf) && bytes.IndexByte(buf, 0) == -1 }
+
+// lines splits buf into lines, each line keeps its newline.
+func lines(buf []byte) []string {
+	ls := strings.SplitAfter(string(buf), "\n")
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+// changed returns the offsets of the first and last byte that differ between a and b.
+func changed(a, b []byte) (first, last int) {
+	for first < len(a) && first < len(b) && a[first] == b[first] {
+		first++
+	}
+	i, j := len(a), len(b)
+	for i > first && j > first && a[i-1] == b[j-1] {
+		i--
+		j--
+	}
+	if j > i {
+		i = j
+	}
+	return first, i - 1
+}
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// diffLines returns the edits that turn a into b, based on their longest common subsequence.
+func diffLines(a, b []string) []edit {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
+
+// unified writes edits as unified diff hunks with 3 lines of context to w.
+func unified(w io.Writer, edits []edit) {
+	const context = 3
+
+	// apos and bpos hold the line in a and b before each edit.
+	apos := make([]int, len(edits)+1)
+	bpos := make([]int, len(edits)+1)
+	for k, e := range edits {
+		apos[k+1], bpos[k+1] = apos[k], bpos[k]
+		if e.op != '+' {
+			apos[k+1]++
+		}
+		if e.op != '-' {
+			bpos[k+1]++
+		}
+	}
+
+	for start := 0; start < len(edits); {
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			return
+		}
+		// extend the hunk until there is a run of unchanged lines that separates it from the next change
+		end := start
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].op == ' ' {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+		lo, hi := start-context, end+context
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(edits) {
+			hi = len(edits)
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(apos[lo], apos[hi]-apos[lo]), hunkRange(bpos[lo], bpos[hi]-bpos[lo]))
+		for _, e := range edits[lo:hi] {
+			fmt.Fprintf(w, "%c%s", e.op, e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				fmt.Fprint(w, "\n\\ No newline at end of file\n")
+			}
+		}
+		start = hi
+	}
+}
+
+func hunkRange(pos, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", pos)
+	}
+	return fmt.Sprintf("%d,%d", pos+1, n)
+}
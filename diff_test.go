@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// testDiffLog opens a diff log in a temporary directory and returns its path.
+func testDiffLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "diff.log")
+	if err := openDiffLog(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		diffLog.f.Close()
+		diffLog.f = nil
+	})
+	return path
+}
+
+func TestDiffLog(t *testing.T) {
+	testQuarantine(t)
+	defer func(d string) { DiffLog = d }(DiffLog)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	DiffLog = testDiffLog(t)
+	Grace = time.Hour
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("a\nb\nc\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := os.WriteFile(filepath.Join(newdir, "file"), []byte("a\nB\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	waitFor(t, "the diff", func() bool {
+		buf, _ := os.ReadFile(DiffLog)
+		got = string(buf)
+		return strings.Contains(got, "@@")
+	})
+	for _, want := range []string{"+++ file\t", "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff log %q doesn't hold %q", got, want)
+		}
+	}
+}
+
+func TestLogDiff(t *testing.T) {
+	defer func(m int64) { DiffLogMaxSize = m }(DiffLogMaxSize)
+	DiffLogMaxSize = 16
+	path := testDiffLog(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	logDiff(write("bin.orig", "ab\x00cdef"), write("bin", "ab\x00cXef"), "bin")
+	logDiff(write("big.orig", strings.Repeat("a", 17)), write("big", strings.Repeat("b", 17)), "big")
+	logDiff(write("same.orig", "same\n"), write("same", "same\n"), "same")
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf)
+	if !strings.Contains(got, "Binary files differ: size 7 -> 7, bytes 4-4 changed\n") {
+		t.Errorf("diff log %q doesn't hold the changed bytes", got)
+	}
+	for _, name := range []string{"big", "same"} {
+		if strings.Contains(got, "+++ "+name+"\t") {
+			t.Errorf("diff log %q holds %s", got, name)
+		}
+	}
+}
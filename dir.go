@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// SortedReaddir lists directories sorted by name, instead of in the order of the underlying file system.
+var SortedReaddir bool
+
+var (
+	_ = (fs.NodeReaddirer)((*MutNode)(nil))
+	_ = (fs.NodeOpendirer)((*MutNode)(nil))
+)
+
+func (n *MutNode) Opendir(ctx context.Context) syscall.Errno {
+	if errno := n.unavailable(); errno != fs.OK {
+		return errno
+	}
+	if HonorACL {
+		if caller, _ := fuse.FromContext(ctx); !aclAllows(n.path(""), caller, aclRead) {
+			return syscall.EACCES
+		}
+	}
+	return n.LoopbackNode.Opendir(ctx)
+}
+
+func (n *MutNode) Readdir(ctx context.Context) (ds fs.DirStream, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "readdir", Path: n.rel("")}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("readdir", n.rel(""), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	switch {
+	case n.mount.upper != "":
+		ds, errno = n.upperReaddir()
+	case ReaddirBatch > 0:
+		ds, errno = newBatchDirStream(n.path(""), ReaddirBatch)
+	default:
+		ds, errno = n.LoopbackNode.Readdir(ctx)
+	}
+	if errno != fs.OK {
+		return nil, errno
+	}
+	if n.mount.snapshot {
+		ds = &filterDirStream{DirStream: ds, keep: func(e fuse.DirEntry) bool { return !n.afterSnapshot(e.Name) }}
+	}
+	if scratch := n.mount.scratch; scratch != "" && n.IsRoot() {
+		ds = &extraDirStream{DirStream: &filterDirStream{DirStream: ds, keep: func(e fuse.DirEntry) bool { return e.Name != scratch }}, name: scratch}
+	}
+	if n.mount.hasMeta() && n.IsRoot() {
+		ds = &extraDirStream{DirStream: &filterDirStream{DirStream: ds, keep: func(e fuse.DirEntry) bool { return e.Name != metaName }}, name: metaName}
+	}
+	if RenameFrom != nil {
+		_, byBacking := renames(n.path(""))
+		ds = &renameDirStream{DirStream: ds, byBacking: byBacking}
+	}
+	if len(Hide) > 0 {
+		ds = &filterDirStream{DirStream: ds, keep: func(e fuse.DirEntry) bool { return !hidden(n.rel(e.Name)) }}
+	}
+	if ManifestHide {
+		ds = &filterDirStream{DirStream: ds, keep: func(e fuse.DirEntry) bool { return inManifest(n.rel(e.Name)) }}
+	}
+	if SortedReaddir {
+		return sortedDirStream(ds)
+	}
+	return ds, fs.OK
+}
+
+// typeOrder is the order of the file types in a sorted directory listing, other types come after these.
+var typeOrder = map[uint32]int{syscall.S_IFDIR: 0, syscall.S_IFREG: 1, syscall.S_IFLNK: 2}
+
+// typeRank returns the position of the type of mode in a sorted directory listing.
+func typeRank(mode uint32) int {
+	if r, ok := typeOrder[mode&syscall.S_IFMT]; ok {
+		return r
+	}
+	return len(typeOrder)
+}
+
+// sortedDirStream reads all entries from ds and returns them sorted by type, directories first, and then by name.
+func sortedDirStream(ds fs.DirStream) (fs.DirStream, syscall.Errno) {
+	defer ds.Close()
+	entries := []fuse.DirEntry{}
+	for ds.HasNext() {
+		e, errno := ds.Next()
+		if errno != fs.OK {
+			return nil, errno
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		ri, rj := typeRank(entries[i].Mode), typeRank(entries[j].Mode)
+		if ri != rj {
+			return ri < rj
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return fs.NewListDirStream(entries), fs.OK
+}
+
+// filterDirStream is a DirStream that only returns the entries for which keep returns true.
+type filterDirStream struct {
+	fs.DirStream
+	keep func(fuse.DirEntry) bool
+
+	next  *fuse.DirEntry
+	errno syscall.Errno
+}
+
+func (ds *filterDirStream) HasNext() bool {
+	for ds.next == nil && ds.errno == fs.OK && ds.DirStream.HasNext() {
+		e, errno := ds.DirStream.Next()
+		if errno != fs.OK {
+			ds.errno = errno
+			return true
+		}
+		if e.Name == "." || e.Name == ".." || ds.keep(e) {
+			ds.next = &e
+		}
+	}
+	return ds.next != nil || ds.errno != fs.OK
+}
+
+func (ds *filterDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	if ds.errno != fs.OK {
+		return fuse.DirEntry{}, ds.errno
+	}
+	e := *ds.next
+	ds.next = nil
+	return e, fs.OK
+}
+
+// extraDirStream adds the directory name to the entries of a directory, for directories that are synthesized,
+// like the scratch directory.
+type extraDirStream struct {
+	fs.DirStream
+	name string
+	done bool
+}
+
+func (ds *extraDirStream) HasNext() bool { return !ds.done || ds.DirStream.HasNext() }
+
+func (ds *extraDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	if !ds.done {
+		ds.done = true
+		return fuse.DirEntry{Name: ds.name, Mode: syscall.S_IFDIR}, fs.OK
+	}
+	return ds.DirStream.Next()
+}
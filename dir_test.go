@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestSortedDirStream(t *testing.T) {
+	entries := []fuse.DirEntry{
+		{Name: "b", Mode: syscall.S_IFREG},
+		{Name: "fifo", Mode: syscall.S_IFIFO},
+		{Name: "link", Mode: syscall.S_IFLNK},
+		{Name: "z", Mode: syscall.S_IFDIR},
+		{Name: "a", Mode: syscall.S_IFREG},
+		{Name: "c", Mode: syscall.S_IFDIR},
+	}
+	ds, errno := sortedDirStream(fs.NewListDirStream(entries))
+	if errno != fs.OK {
+		t.Fatal(errno)
+	}
+	want := []string{"c", "z", "a", "b", "link", "fifo"}
+	for i := 0; ds.HasNext(); i++ {
+		e, _ := ds.Next()
+		if i >= len(want) || e.Name != want[i] {
+			t.Fatalf("entry %d is %q, want the order %v", i, e.Name, want)
+		}
+	}
+}
+
+func TestSortedReaddir(t *testing.T) {
+	defer func(s bool) { SortedReaddir = s }(SortedReaddir)
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"m", "b", "y", "a", "k"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range []string{"x", "c"} {
+			if err := os.Mkdir(filepath.Join(olddir, name), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	list := func() []string {
+		f, err := os.Open(newdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		names, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+	want := []string{"c", "x", "a", "b", "k", "m", "y"}
+
+	SortedReaddir = false
+	unsorted := list()
+	if len(unsorted) != len(want) {
+		t.Fatalf("got %v, want the entries %v", unsorted, want)
+	}
+
+	SortedReaddir = true
+	sorted := list()
+	for i := range want {
+		if sorted[i] != want[i] {
+			t.Fatalf("with sorted-readdir got %v, want %v", sorted, want)
+		}
+	}
+}
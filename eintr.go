@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"syscall"
+)
+
+// maxRetries is the number of times a backing operation is retried when it returns EINTR.
+const maxRetries = 3
+
+// ReopenOnStale retries operations that return ESTALE once, as the path is resolved again that may succeed.
+var ReopenOnStale bool
+
+// retry calls f and retries it when EINTR is returned, unless the request itself was interrupted. With
+// ReopenOnStale set, ESTALE is retried once.
+func retry(ctx context.Context, f func() syscall.Errno) syscall.Errno {
+	errno := f()
+	for i := 0; i < maxRetries && errno == syscall.EINTR && ctx.Err() == nil; i++ {
+		errno = f()
+	}
+	if errno == syscall.ESTALE && ReopenOnStale {
+		errno = f()
+	}
+	return errno
+}
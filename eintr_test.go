@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// failing returns a function that returns errno the first n times it's called and fs.OK after that, and a pointer
+// to the number of calls.
+func failing(errno syscall.Errno, n int) (func() syscall.Errno, *int) {
+	calls := 0
+	return func() syscall.Errno {
+		calls++
+		if calls <= n {
+			return errno
+		}
+		return fs.OK
+	}, &calls
+}
+
+func TestRetryEINTR(t *testing.T) {
+	f, calls := failing(syscall.EINTR, 2)
+	if errno := retry(context.Background(), f); errno != fs.OK || *calls != 3 {
+		t.Errorf("after 2 EINTRs got %s in %d calls, want OK in 3", errnoString(errno), *calls)
+	}
+
+	f, calls = failing(syscall.EINTR, maxRetries+1)
+	if errno := retry(context.Background(), f); errno != syscall.EINTR || *calls != maxRetries+1 {
+		t.Errorf("after %d EINTRs got %s in %d calls, want EINTR in %d", maxRetries+1, errnoString(errno), *calls, maxRetries+1)
+	}
+
+	// an interrupted request isn't retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f, calls = failing(syscall.EINTR, 1)
+	if errno := retry(ctx, f); errno != syscall.EINTR || *calls != 1 {
+		t.Errorf("for an interrupted request got %s in %d calls, want EINTR in 1", errnoString(errno), *calls)
+	}
+}
+
+func TestRetryESTALE(t *testing.T) {
+	defer func(r bool) { ReopenOnStale = r }(ReopenOnStale)
+
+	ReopenOnStale = false
+	f, calls := failing(syscall.ESTALE, 1)
+	if errno := retry(context.Background(), f); errno != syscall.ESTALE || *calls != 1 {
+		t.Errorf("without reopen-on-stale got %s in %d calls, want ESTALE in 1", errnoString(errno), *calls)
+	}
+
+	ReopenOnStale = true
+	f, calls = failing(syscall.ESTALE, 1)
+	if errno := retry(context.Background(), f); errno != fs.OK || *calls != 2 {
+		t.Errorf("with reopen-on-stale got %s in %d calls, want OK in 2", errnoString(errno), *calls)
+	}
+	f, calls = failing(syscall.ESTALE, 2)
+	if errno := retry(context.Background(), f); errno != syscall.ESTALE || *calls != 2 {
+		t.Errorf("when stale again got %s in %d calls, want ESTALE in 2", errnoString(errno), *calls)
+	}
+}
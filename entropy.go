@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// EntropyGuard warns when a file with low entropy content is overwritten with high entropy data, as happens when
+// it's encrypted. With EntropyBlock further writes to the file are denied.
+var (
+	EntropyGuard bool
+	EntropyBlock bool
+)
+
+const (
+	entropySample = 4096 // bytes of the file sampled when it's opened
+	entropyMinLen = 256  // writes smaller than this aren't looked at
+	entropyLow    = 6.0  // bits per byte below which content isn't encrypted or compressed
+	entropyHigh   = 7.5  // bits per byte above which data looks encrypted
+)
+
+// entropy returns the Shannon entropy of buf in bits per byte.
+func entropy(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	var count [256]int
+	for _, b := range buf {
+		count[b]++
+	}
+	e := 0.0
+	for _, c := range count {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(buf))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+// sampleEntropy returns the entropy of the start of the file at path, or -1 when it's too small to tell.
+func sampleEntropy(path string) float64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+	buf := make([]byte, entropySample)
+	n, _ := io.ReadFull(f, buf)
+	if n < entropyMinLen {
+		return -1
+	}
+	return entropy(buf[:n])
+}
+
+var suspects = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// suspect returns true if writes to path have been blocked.
+func suspect(path string) bool {
+	suspects.Lock()
+	defer suspects.Unlock()
+	return suspects.m[path]
+}
+
+// encrypting returns true, after logging a warning, when data written to the file at path, whose content had entropy
+// before, looks encrypted. When EntropyBlock is set further writes to path are denied.
+func encrypting(path string, before float64, data []byte, caller *fuse.Caller) bool {
+	if before < 0 || before >= entropyLow || len(data) < entropyMinLen {
+		return false
+	}
+	after := entropy(data)
+	if after < entropyHigh {
+		return false
+	}
+	log.Printf("Entropy of %q goes from %.2f to %.2f bits per byte, from pid %d, from %d/%d: the file may be encrypted", path, before, after, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
+	if EntropyBlock {
+		suspects.Lock()
+		suspects.m[path] = true
+		suspects.Unlock()
+	}
+	return true
+}
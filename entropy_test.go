@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestEntropyGuard(t *testing.T) {
+	defer func(g, b bool) { EntropyGuard, EntropyBlock = g, b }(EntropyGuard, EntropyBlock)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer log.SetOutput(log.Writer())
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	suspects.Lock()
+	suspects.m = map[string]bool{}
+	suspects.Unlock()
+	Grace = time.Hour
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 100))
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"warn", "block"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), text, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	random := make([]byte, entropySample)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		opt  string
+		name string
+		want syscall.Errno
+	}{
+		{"entropy-guard", "warn", fs.OK},
+		{"entropy-guard=block", "block", syscall.EACCES},
+	} {
+		if err := parseOpt(&fs.Options{}, "olddir", tc.opt); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		f, err := os.OpenFile(filepath.Join(newdir, tc.name), os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = f.Write(random)
+		f.Close()
+		if tc.want == fs.OK && err != nil || tc.want != fs.OK && !errors.Is(err, tc.want) {
+			t.Errorf("%s: write of random data: got %v, want %s", tc.opt, err, errnoString(tc.want))
+		}
+		if !strings.Contains(buf.String(), "the file may be encrypted") {
+			t.Errorf("%s: got log %q, want a warning", tc.opt, buf)
+		}
+	}
+
+	if got, err := os.ReadFile(filepath.Join(olddir, "block")); err != nil || !bytes.Equal(got, text) {
+		t.Errorf("blocked file was written to")
+	}
+	if _, err := os.OpenFile(filepath.Join(newdir, "block"), os.O_WRONLY, 0); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("open of a blocked file: got %v, want EACCES", err)
+	}
+	// text over text is fine
+	if err := os.WriteFile(filepath.Join(newdir, "warn"), text, 0644); err != nil {
+		t.Errorf("write of text: %s", err)
+	}
+}
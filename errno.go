@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Errno holds the errno returned per operation when it is denied. The empty key holds the default, which is EACCES
+// when not set.
+var Errno = map[string]syscall.Errno{}
+
+// ops are the operations for which an errno can be configured.
+var ops = map[string]bool{
+	"open":        true,
+	"create":      true,
+	"unlink":      true,
+	"rmdir":       true,
+	"rename":      true,
+	"setattr":     true,
+	"setxattr":    true,
+	"removexattr": true,
+}
+
+var errnoNames = map[string]syscall.Errno{
+	"EACCES":  syscall.EACCES,
+	"EPERM":   syscall.EPERM,
+	"EROFS":   syscall.EROFS,
+	"ENOENT":  syscall.ENOENT,
+	"EEXIST":  syscall.EEXIST,
+	"EBUSY":   syscall.EBUSY,
+	"EAGAIN":  syscall.EAGAIN,
+	"EINVAL":  syscall.EINVAL,
+	"EIO":     syscall.EIO,
+	"ENOSPC":  syscall.ENOSPC,
+	"EDQUOT":  syscall.EDQUOT,
+	"ENOTSUP": syscall.ENOTSUP,
+}
+
+// errnoFor returns the errno to return when op is denied.
+func errnoFor(op string) syscall.Errno {
+	if e, ok := Errno[op]; ok {
+		return e
+	}
+	if e, ok := Errno[""]; ok {
+		return e
+	}
+	return syscall.EACCES
+}
+
+// parseErrno parses s, which is of the form [op:]ERRNO, and stores it in Errno. Without an op the default is set.
+// Multiple items are separated by a '+', as a comma already separates the options: unlink:EPERM+open:EROFS. Nothing
+// is stored when one of the items is wrong.
+func parseErrno(s string) error {
+	errnos := map[string]syscall.Errno{}
+	for _, item := range strings.Split(s, "+") {
+		op, name := "", item
+		if i := strings.Index(item, ":"); i > 0 {
+			op, name = item[:i], item[i+1:]
+			if !ops[op] {
+				return fmt.Errorf("unknown operation %q", op)
+			}
+		}
+		e, ok := errnoNames[strings.ToUpper(name)]
+		if !ok {
+			return fmt.Errorf("unknown errno %q", name)
+		}
+		errnos[op] = e
+	}
+	for op, e := range errnos {
+		Errno[op] = e
+	}
+	return nil
+}
+
+// strayErrno returns true if o looks like an op:ERRNO item that got separated from its errno= option, as happens with
+// errno=unlink:EPERM,open:EROFS.
+func strayErrno(o string) bool {
+	i := strings.Index(o, ":")
+	if i <= 0 {
+		return false
+	}
+	_, ok := errnoNames[strings.ToUpper(o[i+1:])]
+	return ops[o[:i]] && ok
+}
+
+// errnoString returns the name of errno, or "OK" when it is zero.
+func errnoString(errno syscall.Errno) string {
+	if errno == 0 {
+		return "OK"
+	}
+	for name, e := range errnoNames {
+		if e == errno {
+			return name
+		}
+	}
+	return errno.Error()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestErrnoPerOp(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+
+	for op := range ops {
+		Errno = map[string]syscall.Errno{}
+		if err := parseErrno(op + ":EPERM"); err != nil {
+			t.Fatalf("parseErrno(%q): %s", op+":EPERM", err)
+		}
+		for other := range ops {
+			want := syscall.EACCES
+			if other == op {
+				want = syscall.EPERM
+			}
+			if got := errnoFor(other); got != want {
+				t.Errorf("after %s:EPERM, errnoFor(%q) = %s, want %s", op, other, errnoString(got), errnoString(want))
+			}
+		}
+	}
+}
+
+func TestParseErrno(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+	Errno = map[string]syscall.Errno{}
+
+	if err := parseErrno("EROFS+unlink:EPERM+open:eacces"); err != nil {
+		t.Fatal(err)
+	}
+	for op := range ops {
+		want := syscall.EROFS
+		switch op {
+		case "unlink":
+			want = syscall.EPERM
+		case "open":
+			want = syscall.EACCES
+		}
+		if got := errnoFor(op); got != want {
+			t.Errorf("errnoFor(%q) = %s, want %s", op, errnoString(got), errnoString(want))
+		}
+	}
+}
+
+func TestParseErrnoWrong(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+
+	for _, s := range []string{
+		"ENOPE",
+		"unlink:ENOPE",
+		"link:EPERM",
+		"unlink:",
+		"unlink:EPERM+open:ENOPE",
+		"unlink:EPERM+",
+	} {
+		Errno = map[string]syscall.Errno{}
+		if err := parseErrno(s); err == nil {
+			t.Errorf("parseErrno(%q): expected error", s)
+		}
+		if len(Errno) != 0 {
+			t.Errorf("parseErrno(%q): stored %v, expected nothing", s, Errno)
+		}
+	}
+}
+
+func TestParseOptStrayErrno(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+	Errno = map[string]syscall.Errno{}
+
+	// pflag splits -o errno=unlink:EPERM,open:EROFS on the comma
+	opts := &fs.Options{}
+	if err := parseOpt(opts, "olddir", "errno=unlink:EPERM"); err != nil {
+		t.Fatal(err)
+	}
+	err := parseOpt(opts, "olddir", "open:EROFS")
+	if err == nil || errors.Is(err, errUnknownOption) {
+		t.Errorf("parseOpt(%q) = %v, expected an error about errno", "open:EROFS", err)
+	}
+	if err := parseOpt(opts, "olddir", "x-systemd.automount"); !errors.Is(err, errUnknownOption) {
+		t.Errorf("parseOpt(%q) = %v, expected %v", "x-systemd.automount", err, errUnknownOption)
+	}
+}
+
+func TestErrnoMount(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	Errno = map[string]syscall.Errno{}
+	if err := parseErrno("unlink:EPERM+rename:EROFS"); err != nil {
+		t.Fatal(err)
+	}
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	for op, tc := range map[string]struct {
+		f    func() error
+		want syscall.Errno
+	}{
+		"unlink": {func() error { return os.Remove(file) }, syscall.EPERM},
+		"rename": {func() error { return os.Rename(file, file+"2") }, syscall.EROFS},
+		"chmod":  {func() error { return os.Chmod(file, 0600) }, syscall.EACCES},
+	} {
+		if err := tc.f(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %s", op, err, errnoString(tc.want))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// escapes returns true if name is a symlink that points outside of the source root.
+func (n *MutNode) escapes(name string) bool {
+	p := n.path(name)
+	fi, err := os.Lstat(p)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+
+	root, err := filepath.EvalSymlinks(n.LoopbackNode.RootData.Path)
+	if err != nil {
+		root = n.LoopbackNode.RootData.Path
+	}
+	root, _ = filepath.Abs(root)
+
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		// dangling, check the target lexically
+		link, err := os.Readlink(p)
+		if err != nil {
+			return true
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(p), link)
+		}
+		target = link
+	}
+	target, _ = filepath.Abs(target)
+
+	return target != root && !strings.HasPrefix(target, root+string(filepath.Separator))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestEscapes(t *testing.T) {
+	n := testRoot(t)
+	root := n.path("")
+	outside := t.TempDir()
+	for name, target := range map[string]string{
+		"inside":    "file",
+		"absolute":  filepath.Join(root, "file"),
+		"outside":   outside,
+		"dotdot":    "../" + filepath.Base(outside),
+		"dangling":  "/nonexistent/file",
+		"dangling2": "missing",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]bool{
+		"file":      false,
+		"inside":    false,
+		"absolute":  false,
+		"outside":   true,
+		"dotdot":    true,
+		"dangling":  true,
+		"dangling2": false,
+		"missing":   false,
+	} {
+		if got := n.escapes(name); got != want {
+			t.Errorf("escapes(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestNoEscape(t *testing.T) {
+	defer func(e bool) { NoEscape = e }(NoEscape)
+	NoEscape = true
+	outside := t.TempDir()
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("file", filepath.Join(olddir, "inside")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(olddir, "outside")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := os.Stat(filepath.Join(newdir, "inside")); err != nil {
+		t.Errorf("symlink in the tree: %s", err)
+	}
+	if _, err := os.Lstat(filepath.Join(newdir, "outside")); !os.IsNotExist(err) {
+		t.Errorf("escaping symlink: got %v, want it not to exist", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// EventPipe is the named pipe denials are published on.
+var EventPipe string
+
+// eventQueue is the size of the queue of events waiting to be written to the pipe.
+const eventQueue = 256
+
+var events chan event
+
+// event is a denial, described using fanotify's names.
+type event struct {
+	Time     time.Time `json:"time"`
+	Mask     []string  `json:"mask"`
+	Pid      uint32    `json:"pid"`
+	Uid      uint32    `json:"uid"`
+	Gid      uint32    `json:"gid"`
+	Path     string    `json:"path"`
+	Backing  string    `json:"backing_path,omitempty"`
+	Op       string    `json:"op"`
+	Response string    `json:"response"`
+}
+
+// eventMask maps our operations to the closest fanotify event.
+var eventMask = map[string]string{
+	"open":        "FAN_OPEN_PERM",
+	"create":      "FAN_CREATE",
+	"mkdir":       "FAN_CREATE",
+	"mknod":       "FAN_CREATE",
+	"unlink":      "FAN_DELETE",
+	"rmdir":       "FAN_DELETE",
+	"rename":      "FAN_MOVE",
+	"setattr":     "FAN_ATTRIB",
+	"setxattr":    "FAN_ATTRIB",
+	"removexattr": "FAN_ATTRIB",
+}
+
+// openEventPipe creates, when needed, the named pipe at path and starts writing events to it. The pipe is opened
+// non-blocking, so events are dropped when no one reads them.
+func openEventPipe(path string) error {
+	if err := syscall.Mkfifo(path, 0600); err != nil && err != syscall.EEXIST {
+		return err
+	}
+	// opening read-write doesn't block when there is no reader
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	events = make(chan event, eventQueue)
+	go func() {
+		enc := json.NewEncoder(f)
+		for e := range events {
+			if err := enc.Encode(e); err != nil && Log {
+				log.Printf("Dropped event for %q: %s", e.Path, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// newEvent returns the event for op on actualPath, response is FAN_ALLOW or FAN_DENY.
+func newEvent(op, actualPath string, caller *fuse.Caller, response string) event {
+	mask := []string{"FAN_ACCESS_PERM"}
+	if m, ok := eventMask[op]; ok {
+		mask = []string{m}
+	}
+	e := event{Time: time.Now(), Mask: mask, Pid: caller.Pid, Uid: caller.Uid, Gid: caller.Gid, Path: actualPath, Op: op, Response: response}
+	if LogBackingPath {
+		e.Backing = backing(actualPath)
+	}
+	return e
+}
+
+// publish queues the denial of op on actualPath for the event pipe and NATS, if a queue is full the event is dropped.
+func publish(op, actualPath string, caller *fuse.Caller) {
+	e := newEvent(op, actualPath, caller, "FAN_DENY")
+	if Events != "" {
+		publishNATS(e)
+	}
+	if EventPipe == "" {
+		return
+	}
+	select {
+	case events <- e:
+	default:
+		if Log {
+			log.Printf("Event queue full, dropped event for %q", actualPath)
+		}
+	}
+}
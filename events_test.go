@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestEventPipe(t *testing.T) {
+	defer func(e string) { EventPipe = e }(EventPipe)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	EventPipe = filepath.Join(t.TempDir(), "events")
+	if err := openEventPipe(EventPipe); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(EventPipe, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := testRoot(t)
+
+	ctx := callerContext(context.Background(), 42, 1000, 1001)
+	n.deny(ctx, "unlink", "file")
+	n.deny(ctx, "setattr", "file")
+
+	if err := f.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(f)
+	for _, want := range []event{
+		{Mask: []string{"FAN_DELETE"}, Pid: 42, Uid: 1000, Gid: 1001, Path: n.path("file"), Op: "unlink", Response: "FAN_DENY"},
+		{Mask: []string{"FAN_ATTRIB"}, Pid: 42, Uid: 1000, Gid: 1001, Path: n.path("file"), Op: "setattr", Response: "FAN_DENY"},
+	} {
+		if !scanner.Scan() {
+			t.Fatalf("no event for %s: %v", want.Op, scanner.Err())
+		}
+		got := event{}
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("event %q: %s", scanner.Text(), err)
+		}
+		if got.Time.IsZero() {
+			t.Errorf("event %q has no time", scanner.Text())
+		}
+		got.Time = time.Time{}
+		if len(got.Mask) != 1 || got.Mask[0] != want.Mask[0] || got.Pid != want.Pid || got.Uid != want.Uid ||
+			got.Gid != want.Gid || got.Path != want.Path || got.Op != want.Op || got.Response != want.Response {
+			t.Errorf("got event %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestPublishFull(t *testing.T) {
+	defer func(e string) { EventPipe = e }(EventPipe)
+	defer func(e chan event) { events = e }(events)
+	EventPipe = "events"
+	events = make(chan event, 1)
+
+	// no one takes the events from the queue, publish must not block
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 10; i++ {
+			publish("unlink", "file", &fuse.Caller{Pid: 42})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocks on a full queue")
+	}
+	if len(events) != 1 {
+		t.Errorf("got %d queued events, want 1", len(events))
+	}
+}
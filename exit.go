@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// Exit codes, so supervisors can tell why mutfs stopped.
+const (
+	exitFailure = 1 // generic failure
+	exitUsage   = 2 // wrong invocation
+	exitOption  = 3 // wrongly specified option
+	exitDir     = 4 // olddir or newdir are not usable
+	exitMount   = 5 // mounting failed
+)
+
+// exit is os.Exit, tests replace it to see the code fatal exits with.
+var exit = os.Exit
+
+// fatal logs and exits with code.
+func fatal(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	exit(code)
+}
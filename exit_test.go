@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestFatal(t *testing.T) {
+	defer func(e func(int)) { exit = e }(exit)
+	defer log.SetOutput(log.Writer())
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+
+	for _, code := range []int{exitFailure, exitUsage, exitOption, exitDir, exitMount} {
+		got := -1
+		exit = func(c int) { got = c }
+		buf.Reset()
+		fatal(code, "failed with %d", code)
+		if got != code {
+			t.Errorf("fatal(%d) exited with %d", code, got)
+		}
+		if !strings.Contains(buf.String(), "failed with") {
+			t.Errorf("fatal(%d) logged %q, want the message", code, buf.String())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestFreeze(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(c string) { Control = c }(Control)
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer cmdThaw(nil, io.Discard)
+	Grace = time.Hour
+	Control = "control"
+	n := testRoot(t)
+	if _, err := btime(n.path("file")); err != nil {
+		t.Skipf("no creation times: %s", err)
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	if errno := n.deny(ctx, "unlink", "file"); errno != fs.OK {
+		t.Fatalf("in the grace period got %s, want OK", errnoString(errno))
+	}
+	cmdFreeze(nil, io.Discard)
+	if errno := n.deny(ctx, "unlink", "file"); errno != syscall.EACCES {
+		t.Errorf("after a freeze got %s, want EACCES", errnoString(errno))
+	}
+
+	// a freeze overrules allow-uid
+	AllowUID = []uidRule{{lo: 1000, hi: 1000}}
+	if errno := n.deny(ctx, "unlink", "file"); errno != syscall.EACCES {
+		t.Errorf("after a freeze for an allowed uid got %s, want EACCES", errnoString(errno))
+	}
+
+	AllowUID = nil
+	cmdThaw(nil, io.Discard)
+	if errno := n.deny(ctx, "unlink", "file"); errno != fs.OK {
+		t.Errorf("after a thaw got %s, want OK", errnoString(errno))
+	}
+}
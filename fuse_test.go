@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// testMount mounts a temporary olddir on a temporary newdir as m with opts, and unmounts it when tb is done. The test
+// is skipped when FUSE isn't available. Setup is called after prepare, which can fill olddir.
+func testMount(tb testing.TB, m *mount, opts *fs.Options, prepare func(olddir string)) (olddir, newdir string) {
+	tb.Helper()
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		tb.Skip("no /dev/fuse")
+	}
+	tmp := tb.TempDir()
+	olddir, newdir = filepath.Join(tmp, "old"), filepath.Join(tmp, "new")
+	for _, d := range []string{olddir, newdir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if prepare != nil {
+		prepare(olddir)
+	}
+	m.olddir, m.newdir = olddir, newdir
+	root, err := m.setup()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	opts.MountOptions.Name, opts.MountOptions.FsName = "mutfs", olddir
+	opts.MountOptions.DirectMount = true
+	server, err := fs.Mount(newdir, root, opts)
+	if err != nil {
+		tb.Skipf("can't mount: %s", err)
+	}
+	tb.Cleanup(func() { server.Unmount() })
+	if err := server.WaitMount(); err != nil {
+		tb.Fatal(err)
+	}
+	return olddir, newdir
+}
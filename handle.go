@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// loopbackHandle is the set of interfaces implemented by the file handles returned from the loopback file system.
+type loopbackHandle interface {
+	fs.FileHandle
+	fs.FileReleaser
+	fs.FileGetattrer
+	fs.FileReader
+	fs.FileWriter
+	fs.FileGetlker
+	fs.FileSetlker
+	fs.FileSetlkwer
+	fs.FileLseeker
+	fs.FileFlusher
+	fs.FileFsyncer
+	fs.FileSetattrer
+	fs.FileAllocater
+}
+
+// handle wraps a file handle, so we can keep track of it.
+type handle struct {
+	loopbackHandle
+	mount  *mount
+	path   string // path in the underlying file system
+	rel    string // path relative to the root
+	write  bool   // opened for writing
+	append bool   // only allow writes at the end of the file
+	orig   string // path of the quarantined copy, if any
+
+	entropy float64 // entropy of the file when opened, -1 if unknown
+}
+
+// newHandle returns fh, a file of mount m, wrapped in a handle. If fh isn't a loopback file handle it is returned as is.
+func newHandle(m *mount, fh fs.FileHandle, path, rel string, write bool) fs.FileHandle {
+	lh, ok := fh.(loopbackHandle)
+	if !ok {
+		return fh
+	}
+	if write {
+		Stats.openWriter()
+	}
+	return &handle{loopbackHandle: lh, mount: m, path: path, rel: rel, write: write, entropy: -1}
+}
+
+func (h *handle) Read(ctx context.Context, buf []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	if CountReads {
+		countRead(h.rel)
+	}
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "read", Path: h.rel, Off: off, Len: len(buf)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("read", h.rel, time.Now())
+	}
+	res, errno = h.loopbackHandle.Read(ctx, buf, off)
+	if errno == syscall.ESTALE && ReopenOnStale {
+		return h.reread(buf, off)
+	}
+	return res, errno
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if SlowThreshold > 0 {
+		defer slow("write", h.rel, time.Now())
+	}
+	if h.append {
+		out := &fuse.AttrOut{}
+		if errno := h.loopbackHandle.Getattr(ctx, out); errno != fs.OK {
+			return 0, errno
+		}
+		if off < int64(out.Size) {
+			caller, _ := fuse.FromContext(ctx)
+			return 0, denied("open", h.path, caller, "write before the end of file")
+		}
+	}
+	if EntropyGuard {
+		caller, _ := fuse.FromContext(ctx)
+		if encrypting(h.path, h.entropy, data, caller) {
+			h.entropy = -1
+		}
+		if suspect(h.path) {
+			return 0, denied("write", h.path, caller, "suspected encryption")
+		}
+	}
+	throttle(len(data))
+	var n uint32
+	errno := serialize(func() (errno syscall.Errno) {
+		n, errno = h.loopbackHandle.Write(ctx, data, off)
+		return errno
+	})
+	if errno == syscall.ESTALE && ReopenOnStale {
+		return h.rewrite(data, off)
+	}
+	return n, errno
+}
+
+// reread reads from a freshly opened file, for when our file descriptor went stale.
+func (h *handle) reread(buf []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	fd, err := syscall.Open(h.path, syscall.O_RDONLY, 0)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	defer syscall.Close(fd)
+	n, err := syscall.Pread(fd, buf, off)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(buf[:n]), fs.OK
+}
+
+// rewrite writes to a freshly opened file, for when our file descriptor went stale.
+func (h *handle) rewrite(data []byte, off int64) (uint32, syscall.Errno) {
+	fd, err := syscall.Open(h.path, syscall.O_WRONLY, 0)
+	if err != nil {
+		return 0, fs.ToErrno(err)
+	}
+	defer syscall.Close(fd)
+	n, err := syscall.Pwrite(fd, data, off)
+	if err != nil {
+		return 0, fs.ToErrno(err)
+	}
+	return uint32(n), fs.OK
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	errno := h.loopbackHandle.Release(ctx)
+	if !h.write {
+		return errno
+	}
+	Stats.closeWriter()
+	if errno == fs.OK {
+		h.mount.mirror(mirrorOp{op: "copy", rel: h.rel})
+		if DiffLog != "" && h.orig != "" {
+			logDiff(h.orig, h.path, h.rel)
+		}
+		if ChecksumOnWrite {
+			if err := storeChecksum(h.path); err != nil {
+				log.Printf("Failed to store checksum of %q: %s", h.path, err)
+			}
+		}
+	}
+	return errno
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// staleHandle is a file handle whose file descriptor went stale.
+type staleHandle struct{ loopbackHandle }
+
+func (staleHandle) Read(context.Context, []byte, int64) (fuse.ReadResult, syscall.Errno) {
+	return nil, syscall.ESTALE
+}
+
+func (staleHandle) Write(context.Context, []byte, int64) (uint32, syscall.Errno) {
+	return 0, syscall.ESTALE
+}
+
+func TestReopenOnStale(t *testing.T) {
+	defer func(r bool) { ReopenOnStale = r }(ReopenOnStale)
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := &handle{loopbackHandle: staleHandle{}, path: path, rel: "file"}
+	ctx := context.Background()
+	buf := make([]byte, 16)
+
+	ReopenOnStale = false
+	if _, errno := h.Read(ctx, buf, 0); errno != syscall.ESTALE {
+		t.Errorf("read without reopen-on-stale got %s, want ESTALE", errnoString(errno))
+	}
+
+	ReopenOnStale = true
+	res, errno := h.Read(ctx, buf, 1)
+	if errno != fs.OK {
+		t.Fatalf("read with reopen-on-stale got %s", errnoString(errno))
+	}
+	if data, _ := res.Bytes(buf); string(data) != "ello" {
+		t.Errorf("read with reopen-on-stale got %q, want %q", data, "ello")
+	}
+	if n, errno := h.Write(ctx, []byte("J"), 0); errno != fs.OK || n != 1 {
+		t.Fatalf("write with reopen-on-stale got %d, %s", n, errnoString(errno))
+	}
+	if data, _ := os.ReadFile(path); string(data) != "Jello" {
+		t.Errorf("after the write got %q, want %q", data, "Jello")
+	}
+}
+
+func TestStrictAppend(t *testing.T) {
+	defer func(s bool) { StrictAppend = s }(StrictAppend)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	StrictAppend = true
+	Grace = 0
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "log"), []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	path := filepath.Join(newdir, "log")
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("write-only append: %s", err)
+	}
+	if _, err := f.WriteString("two\n"); err != nil {
+		t.Errorf("append: %s", err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 0); err == nil {
+		t.Errorf("write at the start of an append-only file: expected an error")
+	}
+	f.Close()
+
+	for _, flags := range []int{os.O_RDWR | os.O_APPEND, os.O_WRONLY | os.O_APPEND | os.O_TRUNC, os.O_WRONLY} {
+		if f, err := os.OpenFile(path, flags, 0); !errors.Is(err, syscall.EACCES) {
+			if err == nil {
+				f.Close()
+			}
+			t.Errorf("open with flags %#o: got %v, want EACCES", flags, err)
+		}
+	}
+	if buf, _ := os.ReadFile(path); string(buf) != "one\ntwo\n" {
+		t.Errorf("got %q, want %q", buf, "one\ntwo\n")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// HashLog, when not nil, records all mutations allowed because of the grace period.
+var HashLog *hashlog
+
+// hashlogPath is the path of the hash log, HashLog is opened on it.
+var hashlogPath string
+
+// hashlog is an append only log where each entry includes the hash of the previous one, this makes it possible to
+// detect tampering with the log.
+type hashlog struct {
+	sync.Mutex
+	f    *os.File
+	prev string
+}
+
+// hashEntry is a single entry in the hash log, it is stored as a line of JSON.
+type hashEntry struct {
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	Path string    `json:"path"`
+	Pid  uint32    `json:"pid"`
+	Uid  uint32    `json:"uid"`
+	Gid  uint32    `json:"gid"`
+	Prev string    `json:"prev"`
+	Hash string    `json:"hash"`
+}
+
+// sum returns the hash of e, which is the hash over e's JSON encoding with an empty Hash field.
+func (e hashEntry) sum() string {
+	e.Hash = ""
+	buf, _ := json.Marshal(e)
+	h := sha256.Sum256(buf)
+	return hex.EncodeToString(h[:])
+}
+
+// openHashlog opens the hash log at path for appending, the chain continues from the last entry in the file.
+func openHashlog(path string) (*hashlog, error) {
+	prev, err := lastHash(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &hashlog{f: f, prev: prev}, nil
+}
+
+// Append adds an entry for op on path to the log.
+func (h *hashlog) Append(op, path string, caller *fuse.Caller) error {
+	e := hashEntry{Time: time.Now().UTC(), Op: op, Path: path}
+	if caller != nil {
+		e.Pid, e.Uid, e.Gid = caller.Pid, caller.Owner.Uid, caller.Owner.Gid
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	e.Prev = h.prev
+	e.Hash = e.sum()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := h.f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	h.prev = e.Hash
+	return nil
+}
+
+// lastHash returns the hash of the last entry of the log in path. A non-existent log returns the empty string.
+func lastHash(path string) (string, error) {
+	prev := ""
+	err := readHashlog(path, func(e hashEntry) error { prev = e.Hash; return nil })
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return prev, err
+}
+
+// verifyHashlog checks the integrity of the hash chain in path.
+func verifyHashlog(path string) error {
+	prev := ""
+	i := 0
+	return readHashlog(path, func(e hashEntry) error {
+		i++
+		if e.Prev != prev {
+			return fmt.Errorf("entry %d: chain broken, previous hash %q, expected %q", i, e.Prev, prev)
+		}
+		if sum := e.sum(); e.Hash != sum {
+			return fmt.Errorf("entry %d: hash mismatch, got %q, expected %q", i, e.Hash, sum)
+		}
+		prev = e.Hash
+		return nil
+	})
+}
+
+func readHashlog(path string, f func(hashEntry) error) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	scanner := bufio.NewScanner(fp)
+	i := 0
+	for scanner.Scan() {
+		i++
+		e := hashEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("entry %d: %s", i, err)
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
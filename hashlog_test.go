@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestHashlog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashlog")
+	caller := &fuse.Caller{Owner: fuse.Owner{Uid: 1000, Gid: 1000}, Pid: 42}
+
+	h, err := openHashlog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"a", "b"} {
+		if err := h.Append("unlink", p, caller); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.f.Close()
+
+	// the chain continues after reopening
+	h, err = openHashlog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Append("rename", "c", caller); err != nil {
+		t.Fatal(err)
+	}
+	h.f.Close()
+	if err := verifyHashlog(path); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tampered := range map[string][]byte{
+		"altered": bytes.Replace(buf, []byte(`"path":"b"`), []byte(`"path":"x"`), 1),
+		"removed": bytes.Join(append(bytes.SplitN(buf, []byte("\n"), 3)[:1], bytes.SplitN(buf, []byte("\n"), 3)[2]), []byte("\n")),
+	} {
+		if bytes.Equal(tampered, buf) {
+			t.Fatalf("%s: log not tampered with", name)
+		}
+		if err := os.WriteFile(path, tampered, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyHashlog(path); err == nil {
+			t.Errorf("%s entry: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// Hide holds the shell patterns of the entries that are hidden.
+var Hide []string
+
+var _ = (fs.NodeReadlinker)((*MutNode)(nil))
+
+// hidden returns true if rel, which is relative to the root, matches one of the Hide patterns. A pattern is matched
+// against the base name and the full relative path.
+func hidden(rel string) bool {
+	base := filepath.Base(rel)
+	for _, h := range Hide {
+		if ok, _ := filepath.Match(h, base); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(h, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *MutNode) Readlink(ctx context.Context) (target []byte, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "readlink", Path: n.rel("")}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("readlink", n.rel(""), time.Now())
+	}
+	if len(Hide) > 0 && hidden(n.rel("")) {
+		return nil, syscall.ENOENT
+	}
+	if n.mount.upper != "" && n.inUpper("") {
+		target, err := os.Readlink(n.upper(""))
+		if err != nil {
+			return nil, fs.ToErrno(err)
+		}
+		return []byte(target), fs.OK
+	}
+	return n.LoopbackNode.Readlink(ctx)
+}
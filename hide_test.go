@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestHideSymlink(t *testing.T) {
+	defer func(h []string) { Hide = h }(Hide)
+	n := testRoot(t)
+	for _, name := range []string{"link", "secret-link"} {
+		if err := os.Symlink("file", n.path(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// look up both links before hiding, as a node may have been looked up before the hide patterns apply to it
+	links := map[string]fs.NodeReadlinker{}
+	for _, name := range []string{"link", "secret-link"} {
+		inode, errno := n.Lookup(ctx, name, &fuse.EntryOut{})
+		if errno != fs.OK {
+			t.Fatalf("lookup %q: %s", name, errnoString(errno))
+		}
+		n.AddChild(name, inode, false)
+		links[name] = inode.Operations().(fs.NodeReadlinker)
+	}
+	Hide = []string{"secret*"}
+
+	if target, errno := links["link"].Readlink(ctx); errno != fs.OK || string(target) != "file" {
+		t.Errorf("readlink of link = %q, %s, want %q", target, errnoString(errno), "file")
+	}
+	if _, errno := links["secret-link"].Readlink(ctx); errno != syscall.ENOENT {
+		t.Errorf("readlink of secret-link = %s, want ENOENT", errnoString(errno))
+	}
+	if _, errno := n.Lookup(ctx, "secret-link", &fuse.EntryOut{}); errno != syscall.ENOENT {
+		t.Errorf("lookup of secret-link = %s, want ENOENT", errnoString(errno))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// LastDenied makes the most recent denial of a file readable in its lastDeniedXattr extended attribute.
+var LastDenied bool
+
+const (
+	lastDeniedXattr = "user.mutfs.last_denied"
+	lastDeniedMax   = 4096 // maximum number of files whose last denial is kept
+)
+
+var lastDenied = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+// rememberDenial records the denial of op on actualPath.
+func rememberDenial(op, actualPath string, caller *fuse.Caller) {
+	v := fmt.Sprintf("%s %s pid %d %d/%d", time.Now().Format(time.RFC3339Nano), op, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
+	lastDenied.Lock()
+	defer lastDenied.Unlock()
+	if _, ok := lastDenied.m[actualPath]; !ok && len(lastDenied.m) >= lastDeniedMax {
+		for p := range lastDenied.m { // forget a random one
+			delete(lastDenied.m, p)
+			break
+		}
+	}
+	lastDenied.m[actualPath] = v
+}
+
+// getLastDenied copies the last denial of actualPath to dest, like getxattr(2).
+func getLastDenied(actualPath string, dest []byte) (uint32, syscall.Errno) {
+	lastDenied.Lock()
+	v, ok := lastDenied.m[actualPath]
+	lastDenied.Unlock()
+	if !ok {
+		return 0, syscall.ENODATA
+	}
+	if len(dest) == 0 {
+		return uint32(len(v)), fs.OK
+	}
+	if len(dest) < len(v) {
+		return uint32(len(v)), syscall.ERANGE
+	}
+	return uint32(copy(dest, v)), fs.OK
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestLastDenied(t *testing.T) {
+	defer func(l bool) { LastDenied = l }(LastDenied)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	LastDenied, Grace = true, 0
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"file", "other"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	file := filepath.Join(newdir, "file")
+	buf := make([]byte, 256)
+
+	if _, err := unix.Getxattr(file, lastDeniedXattr, buf); err != unix.ENODATA {
+		t.Errorf("getxattr before a denial: got %v, want ENODATA", err)
+	}
+	if err := os.Remove(file); !errors.Is(err, syscall.EACCES) {
+		t.Fatalf("remove: got %v, want EACCES", err)
+	}
+	n, err := unix.Getxattr(file, lastDeniedXattr, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pid is the one of the thread that did the unlink
+	fields := strings.Fields(string(buf[:n]))
+	if len(fields) != 5 || fields[1] != "unlink" || fields[2] != "pid" || fields[4] != strconv.Itoa(os.Getuid())+"/"+strconv.Itoa(os.Getgid()) {
+		t.Errorf("got last denial %q, want the unlink by us", buf[:n])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		t.Errorf("got last denial %q: %s", buf[:n], err)
+	}
+	if _, err := unix.Getxattr(filepath.Join(newdir, "other"), lastDeniedXattr, buf); err != unix.ENODATA {
+		t.Errorf("getxattr of another file: got %v, want ENODATA", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MaxOpenFDs is the maximum number of file descriptors kept open for files opened read-only, 0 means no limit.
+var MaxOpenFDs int
+
+// lazyHandle is a file handle for a read-only file, the file descriptor can be closed when it's idle and it is
+// reopened when needed.
+type lazyHandle struct {
+	mu    sync.Mutex
+	path  string // path in the underlying file system
+	rel   string // path relative to the root
+	flags int    // extra open flags, e.g. O_NOFOLLOW
+	fd    int    // -1 when closed
+	elem  *list.Element
+}
+
+var _ = (fs.FileReader)((*lazyHandle)(nil))
+var _ = (fs.FileReleaser)((*lazyHandle)(nil))
+var _ = (fs.FileGetattrer)((*lazyHandle)(nil))
+
+// fds keeps the lazy handles with an open file descriptor, the most recently used first.
+var fds = struct {
+	sync.Mutex
+	lru *list.List
+}{lru: list.New()}
+
+// newLazyHandle opens the file at actualPath read-only, with the extra open flags.
+func newLazyHandle(actualPath, rel string, flags uint32) (*lazyHandle, syscall.Errno) {
+	h := &lazyHandle{path: actualPath, rel: rel, flags: int(flags), fd: -1}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if errno := h.open(); errno != fs.OK {
+		return nil, errno
+	}
+	return h, fs.OK
+}
+
+// open opens the file, when needed closing the file descriptors of the least recently used idle handles first.
+// h.mu must be held.
+func (h *lazyHandle) open() syscall.Errno {
+	fds.Lock()
+	for e := fds.lru.Back(); e != nil && fds.lru.Len() >= MaxOpenFDs; {
+		prev := e.Prev()
+		if victim := e.Value.(*lazyHandle); victim.mu.TryLock() {
+			syscall.Close(victim.fd)
+			victim.fd = -1
+			victim.elem = nil
+			fds.lru.Remove(e)
+			victim.mu.Unlock()
+		}
+		e = prev
+	}
+	fds.Unlock()
+
+	fd, err := syscall.Open(h.path, syscall.O_RDONLY|h.flags, 0)
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	h.fd = fd
+	fds.Lock()
+	h.elem = fds.lru.PushFront(h)
+	fds.Unlock()
+	return fs.OK
+}
+
+// use makes sure the file is open and marks it as recently used. h.mu must be held.
+func (h *lazyHandle) use() syscall.Errno {
+	if h.fd < 0 {
+		return h.open()
+	}
+	fds.Lock()
+	fds.lru.MoveToFront(h.elem)
+	fds.Unlock()
+	return fs.OK
+}
+
+func (h *lazyHandle) Read(ctx context.Context, buf []byte, off int64) (res fuse.ReadResult, errno syscall.Errno) {
+	if CountReads {
+		countRead(h.rel)
+	}
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "read", Path: h.rel, Off: off, Len: len(buf)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("read", h.rel, time.Now())
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if errno := h.use(); errno != fs.OK {
+		return nil, errno
+	}
+	n, err := syscall.Pread(h.fd, buf, off)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(buf[:n]), fs.OK
+}
+
+func (h *lazyHandle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if errno := h.use(); errno != fs.OK {
+		return errno
+	}
+	st := syscall.Stat_t{}
+	if err := syscall.Fstat(h.fd, &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	return fs.OK
+}
+
+func (h *lazyHandle) Release(ctx context.Context) syscall.Errno {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.fd < 0 {
+		return fs.OK
+	}
+	fds.Lock()
+	fds.lru.Remove(h.elem)
+	fds.Unlock()
+	err := syscall.Close(h.fd)
+	h.fd = -1
+	return fs.ToErrno(err)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestMaxOpenFDs(t *testing.T) {
+	defer func(m int) { MaxOpenFDs = m }(MaxOpenFDs)
+	MaxOpenFDs = 4
+	dir := t.TempDir()
+	const files = 50
+	for i := 0; i < files; i++ {
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)), []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// allow fewer descriptors than there are files, so keeping all of them open fails with EMFILE
+	open, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("can't count open file descriptors")
+	}
+	lim := &syscall.Rlimit{}
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, lim); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: lim.Cur, Max: lim.Max})
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &syscall.Rlimit{Cur: uint64(len(open) + 2*MaxOpenFDs), Max: lim.Max}); err != nil {
+		t.Fatal(err)
+	}
+
+	hs := make([]*lazyHandle, files)
+	for i := range hs {
+		h, errno := newLazyHandle(filepath.Join(dir, strconv.Itoa(i)), strconv.Itoa(i), 0)
+		if errno != fs.OK {
+			t.Fatalf("open of file %d: %s", i, errnoString(errno))
+		}
+		defer h.Release(context.Background())
+		hs[i] = h
+	}
+	// read them all twice, the second time all their descriptors have been closed in between
+	for round := 0; round < 2; round++ {
+		for i, h := range hs {
+			buf := make([]byte, 8)
+			res, errno := h.Read(context.Background(), buf, 0)
+			if errno != fs.OK {
+				t.Fatalf("read of file %d: %s", i, errnoString(errno))
+			}
+			data, _ := res.Bytes(buf)
+			if string(data) != strconv.Itoa(i) {
+				t.Errorf("read of file %d got %q", i, data)
+			}
+		}
+	}
+	fds.Lock()
+	n := fds.lru.Len()
+	fds.Unlock()
+	if n > MaxOpenFDs {
+		t.Errorf("got %d open file descriptors, want at most %d", n, MaxOpenFDs)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// LazySource allows mounting before the source directory exists, until it does operations fail with LazySourceErrno.
+var LazySource bool
+
+// LazySourceErrno is returned while the source directory doesn't exist.
+var LazySourceErrno = syscall.EAGAIN
+
+// unavailable returns LazySourceErrno when the source directory doesn't exist (yet).
+func (n *MutNode) unavailable() syscall.Errno {
+	if !LazySource || atomic.LoadInt32(&n.mount.sourceSeen) == 1 {
+		return 0
+	}
+	fi, err := os.Stat(n.RootData.Path)
+	if err != nil || !fi.IsDir() {
+		return LazySourceErrno
+	}
+	atomic.StoreInt32(&n.mount.sourceSeen, 1)
+	return 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestLazySource(t *testing.T) {
+	defer func(l bool, e syscall.Errno) { LazySource, LazySourceErrno = l, e }(LazySource, LazySourceErrno)
+	if err := parseOpt(&fs.Options{}, "olddir", "lazy-source=EIO"); err != nil {
+		t.Fatal(err)
+	}
+	// the source disappears before mounting, as a network file system that isn't up yet
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.Remove(olddir); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	if _, err := os.Stat(file); !errors.Is(err, syscall.EIO) {
+		t.Errorf("stat before the source exists: got %v, want EIO", err)
+	}
+	if _, err := os.ReadDir(newdir); !errors.Is(err, syscall.EIO) {
+		t.Errorf("readdir before the source exists: got %v, want EIO", err)
+	}
+
+	if err := os.Mkdir(olddir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(file); err != nil || string(buf) != "contents" {
+		t.Errorf("read once the source exists: got %q, %v, want %q", buf, err, "contents")
+	}
+	if des, err := os.ReadDir(newdir); err != nil || len(des) != 1 {
+		t.Errorf("readdir once the source exists: got %v, %v, want file", des, err)
+	}
+}
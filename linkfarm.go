@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// startSnapshots makes a hard link snapshot of the source of m in its snapshot directory every snapshot interval, until
+// ctx is canceled. The snapshot directory must be on the same file system as the source.
+func (m *mount) startSnapshots(ctx context.Context) {
+	root := m.root.Path
+	go func() {
+		tick := time.NewTicker(m.snapshotInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-tick.C:
+				dst := filepath.Join(m.snapshotDir, t.UTC().Format("20060102T150405"))
+				start := time.Now()
+				n, err := linkTree(ctx, root, dst, m.snapshotDir)
+				if err != nil {
+					log.Printf("Snapshot of %q to %q stopped after %d files: %s", root, dst, n, err)
+					continue
+				}
+				if Log {
+					log.Printf("Snapshot of %q to %q done, %d files in %s", root, dst, n, time.Since(start))
+				}
+			}
+		}
+	}()
+}
+
+// linkTree recreates the tree under root in dst, with hard links to the files and copies of the symlinks. The number
+// of files linked is returned. The snapshot directory snapdir itself is skipped when it's under root.
+func linkTree(ctx context.Context, root, dst, snapdir string) (int, error) {
+	snapdir, _ = filepath.Abs(snapdir)
+	n := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		if abs, _ := filepath.Abs(path); abs == snapdir || strings.HasPrefix(abs, snapdir+string(filepath.Separator)) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			n++
+			return os.Link(path, target)
+		}
+		return nil // devices, sockets and pipes
+	})
+	return n, err
+}
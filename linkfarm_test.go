@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestLinkTree(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", "dir/file"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("dir/file", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshots are kept under the root, they must not be snapshotted themselves
+	snapdir := filepath.Join(root, "snapshots")
+	if err := os.Mkdir(snapdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mount{root: &fs.LoopbackRoot{Path: root}, snapshotInterval: 10 * time.Millisecond, snapshotDir: snapdir}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.startSnapshots(ctx)
+	var dst string
+	waitFor(t, "a snapshot", func() bool {
+		entries, _ := os.ReadDir(snapdir)
+		if len(entries) == 0 {
+			return false
+		}
+		dst = filepath.Join(snapdir, entries[0].Name())
+		return exists(filepath.Join(dst, "link"))
+	})
+	cancel()
+
+	for _, name := range []string{"file", "dir/file"} {
+		src, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s isn't in the snapshot: %s", name, err)
+			continue
+		}
+		if !os.SameFile(src, snap) {
+			t.Errorf("%s in the snapshot isn't a hard link to the source", name)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "dir/file" {
+		t.Errorf("link in the snapshot points to %q, %v, want %q", link, err, "dir/file")
+	}
+	if exists(filepath.Join(dst, "snapshots")) {
+		t.Errorf("the snapshot directory is in the snapshot")
+	}
+
+	n, err := linkTree(context.Background(), root, filepath.Join(t.TempDir(), "snap"), snapdir)
+	if err != nil || n != 2 {
+		t.Errorf("linkTree = %d, %v, want 2 files", n, err)
+	}
+}
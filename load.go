@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MaxLoad is the 1 minute load average above which files can't be opened for writing or created, 0 disables this.
+var MaxLoad float64
+
+// loadTTL is how long a load average reading is used.
+const loadTTL = time.Second
+
+var load = struct {
+	sync.Mutex
+	at  time.Time
+	avg float64
+}{}
+
+// loadavg returns the 1 minute load average, this is read at most once per loadTTL.
+func loadavg() (float64, error) {
+	load.Lock()
+	defer load.Unlock()
+	if time.Since(load.at) < loadTTL {
+		return load.avg, nil
+	}
+	buf, err := os.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(buf))
+	if len(fields) == 0 {
+		return 0, syscall.EINVAL
+	}
+	avg, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	load.at, load.avg = time.Now(), avg
+	return avg, nil
+}
+
+// overloaded returns EAGAIN when the load average is above MaxLoad.
+func overloaded(op, actualPath string, caller *fuse.Caller) syscall.Errno {
+	if MaxLoad == 0 {
+		return 0
+	}
+	avg, err := loadavg()
+	if err != nil || avg <= MaxLoad {
+		return 0
+	}
+	denied(op, actualPath, caller, "load average "+strconv.FormatFloat(avg, 'f', 2, 64)+" too high")
+	return syscall.EAGAIN
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestOverloaded(t *testing.T) {
+	defer func(m float64) { MaxLoad = m }(MaxLoad)
+	t.Cleanup(func() { load.at = time.Time{} })
+	MaxLoad = 4
+	caller := &fuse.Caller{Pid: 42}
+
+	for _, tc := range []struct {
+		loadavg string
+		want    syscall.Errno
+	}{
+		{"0.50 0.40 0.30 1/100 4242\n", 0},
+		{"4.00 0.40 0.30 1/100 4242\n", 0},
+		{"4.01 0.40 0.30 1/100 4242\n", syscall.EAGAIN},
+		{"12.00 11.00 10.00 9/100 4242\n", syscall.EAGAIN},
+		{"garbage\n", 0},
+	} {
+		fakeProc(t, map[string]string{"loadavg": tc.loadavg})
+		load.at = time.Time{}
+		if got := overloaded("open", "file", caller); got != tc.want {
+			t.Errorf("with load %q got %s, want %s", tc.loadavg, errnoString(got), errnoString(tc.want))
+		}
+	}
+
+	// the load average is cached, a changed one is only seen after loadTTL
+	fakeProc(t, map[string]string{"loadavg": "0.10 0.10 0.10 1/100 4242\n"})
+	load.at, load.avg = time.Now(), 8
+	if got := overloaded("open", "file", caller); got != syscall.EAGAIN {
+		t.Errorf("with a cached load of 8 got %s, want EAGAIN", errnoString(got))
+	}
+	load.at = time.Now().Add(-loadTTL)
+	if got := overloaded("open", "file", caller); got != 0 {
+		t.Errorf("after loadTTL got %s, want OK", errnoString(got))
+	}
+}
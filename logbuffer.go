@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// LogBuffer is the number of decisions kept in memory for the tail control command, 0 disables this.
+var LogBuffer int
+
+// ring holds the last LogBuffer decisions, next is where the next one is stored.
+var ring = struct {
+	sync.Mutex
+	entries []string
+	next    int
+}{}
+
+// remember stores a decision in the ring buffer.
+func remember(decision, op, actualPath string, caller *fuse.Caller, reason string) {
+	if LogBuffer == 0 {
+		return
+	}
+	if LogBackingPath {
+		reason += ", backing path " + strconv.Quote(backing(actualPath))
+	}
+	e := fmt.Sprintf("%s %s %s %q: %s, from pid %d, from %d/%d", time.Now().Format(time.RFC3339Nano), decision, op, actualPath, reason, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
+	ring.Lock()
+	defer ring.Unlock()
+	if len(ring.entries) < LogBuffer {
+		ring.entries = append(ring.entries, e)
+		return
+	}
+	ring.entries[ring.next] = e
+	ring.next = (ring.next + 1) % LogBuffer
+}
+
+// tail returns the last n decisions, oldest first.
+func tail(n int) []string {
+	ring.Lock()
+	defer ring.Unlock()
+	xs := append(append([]string{}, ring.entries[ring.next:]...), ring.entries[:ring.next]...)
+	if n < len(xs) {
+		xs = xs[len(xs)-n:]
+	}
+	return xs
+}
+
+// cmdTail writes the last decisions, one per line, followed by OK. An optional argument limits the number returned.
+func cmdTail(args []string, w io.Writer) error {
+	n := LogBuffer
+	if len(args) > 0 {
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+		n = i
+	}
+	xs := tail(n)
+	for _, x := range xs {
+		if _, err := fmt.Fprintln(w, x); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "OK %d\n", len(xs))
+	return err
+}
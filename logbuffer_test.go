@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogBuffer(t *testing.T) {
+	defer func(l int) { LogBuffer = l }(LogBuffer)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	resetRing := func() {
+		ring.Lock()
+		ring.entries, ring.next = nil, 0
+		ring.Unlock()
+	}
+	resetRing()
+	t.Cleanup(resetRing)
+	LogBuffer, Grace = 3, 0
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		n.deny(ctx, "unlink", name)
+	}
+	xs := tail(LogBuffer)
+	if len(xs) != 3 {
+		t.Fatalf("got %d decisions, want 3: %q", len(xs), xs)
+	}
+	for i, name := range []string{"c", "d", "e"} {
+		if !strings.Contains(xs[i], " unlink "+`"`+n.path(name)+`"`) || !strings.Contains(xs[i], "from pid 42") {
+			t.Errorf("decision %d is %q, want the unlink of %s", i, xs[i], name)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := cmdTail([]string{"2"}, buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 || lines[0] != xs[1] || lines[1] != xs[2] || lines[2] != "OK 2" {
+		t.Errorf("tail 2 got %q, want the last 2 decisions", buf)
+	}
+	if err := cmdTail([]string{"-1"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected an error for a negative count")
+	}
+}
@@ -8,11 +8,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -26,11 +30,24 @@ import (
 // allowed.
 type MutNode struct {
 	fs.LoopbackNode
+	mount *mount
 }
 
 var (
-	Log   bool
-	Grace time.Duration
+	Log             bool
+	Grace           time.Duration
+	GraceOp         = map[string]time.Duration{}
+	GraceExt        = map[string]time.Duration{}
+	GraceUntil      time.Time
+	GraceMaxSize    int64
+	NoEscape        bool
+	RequireTTY      bool
+	AllowExclCreate bool
+	TraceDecisions  bool
+	Umask           uint32
+	StrictAppend    bool
+	LockExtension   bool
+	StrictConfig    bool
 )
 
 var (
@@ -41,78 +58,282 @@ var (
 	_ = (fs.NodeSetattrer)((*MutNode)(nil))
 	_ = (fs.NodeRmdirer)((*MutNode)(nil))
 	_ = (fs.NodeRemovexattrer)((*MutNode)(nil))
+	_ = (fs.NodeLookuper)((*MutNode)(nil))
+	_ = (fs.NodeGetattrer)((*MutNode)(nil))
+	_ = (fs.NodeCreater)((*MutNode)(nil))
+	_ = (fs.NodeMkdirer)((*MutNode)(nil))
+	_ = (fs.NodeMknoder)((*MutNode)(nil))
 )
 
-func (n *MutNode) deny(ctx context.Context, name string) syscall.Errno {
-	actualPath := filepath.Join(n.LoopbackNode.RootData.Path, filepath.Join(n.LoopbackNode.Path(n.LoopbackNode.Root()), name))
+// path returns the full path of name in the underlying file system. If name is empty the path of n is returned.
+func (n *MutNode) path(name string) string {
+	if n.mount.upper != "" && n.inUpper(name) {
+		return n.upper(name)
+	}
+	return n.lower(name)
+}
+
+// rel returns the path of name relative to the root. If name is empty the path of n is returned.
+func (n *MutNode) rel(name string) string {
+	return filepath.Join(n.LoopbackNode.Path(n.LoopbackNode.Root()), name)
+}
+
+// deny returns fs.OK if the operation op on name is allowed, otherwise the errno configured for op is returned.
+func (n *MutNode) deny(ctx context.Context, op, name string) syscall.Errno {
+	return n.denyFlags(ctx, op, name, 0)
+}
+
+// denyFlags is deny for operations that have flags: the open flags, or the valid attributes for setattr.
+func (n *MutNode) denyFlags(ctx context.Context, op, name string, flags uint32) syscall.Errno {
 	caller, _ := fuse.FromContext(ctx)
-	if bt, err := btime(actualPath); err == nil { // on success
-		if since := time.Since(bt); since < Grace {
-			if !Log {
-				return fs.OK
-			}
-			log.Printf("Access granted to %q because of grace: %s, from pid %d and %d%d", actualPath, Grace-since, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
-			return fs.OK
-		}
+	errno := frozenStructure(op, n.path(name), caller)
+	if errno == fs.OK {
+		errno = n.decide(ctx, op, name, flags)
+	}
+	Stats.record(op, errno)
+	return errno
+}
+
+// denyCreate returns fs.OK if name may be created with op. Creates aren't subject to the rules, only to
+// freeze-structure, max-depth, the manifest, max-files and caller-rate.
+func (n *MutNode) denyCreate(ctx context.Context, op, name string) syscall.Errno {
+	caller, _ := fuse.FromContext(ctx)
+	errno := frozenStructure(op, n.path(name), caller)
+	if errno == fs.OK {
+		errno = tooDeep(op, n.path(name), n.rel(name), caller)
+	}
+	if errno == fs.OK {
+		errno = unlisted(op, n.path(name), n.rel(name), caller)
+	}
+	if errno == fs.OK {
+		errno = n.mount.quota(op, n.path(name), caller)
+	}
+	if errno == fs.OK {
+		errno = rateLimited(op, n.path(name), caller)
+	}
+	Stats.record(op, errno)
+	return errno
+}
+
+// graceFor returns the grace period for op on path, this is Grace unless a specific one has been set for the extension
+// of path or for op, in that order.
+func graceFor(op, path string) time.Duration {
+	if g, ok := GraceExt[filepath.Ext(path)]; ok {
+		return g
+	}
+	if g, ok := GraceOp[op]; ok {
+		return g
 	}
+	return Grace
+}
 
-	if !Log {
-		return syscall.EACCES
+// graceSize returns true if the file at actualPath is small enough to be subject to the grace period.
+func graceSize(actualPath string) bool {
+	if GraceMaxSize == 0 {
+		return true
+	}
+	fi, err := os.Lstat(actualPath)
+	if err != nil {
+		return false
 	}
-	log.Printf("Write access denied to %q from pid %d, from %d/%d", actualPath, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
-	return syscall.EACCES
+	return fi.Size() < GraceMaxSize
 }
 
-func (n *MutNode) Unlink(ctx context.Context, name string) syscall.Errno {
-	errno := n.deny(ctx, name)
+func (n *MutNode) Unlink(ctx context.Context, name string) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "unlink", Path: n.rel(name)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("unlink", n.rel(name), time.Now())
+	}
+	if RenameFrom != nil && n.renamed(name) {
+		return syscall.EROFS
+	}
+	errno = n.deny(ctx, "unlink", name)
 	if errno != fs.OK {
 		return errno
 	}
-	return n.LoopbackNode.Unlink(ctx, name)
+	if StickyBtime {
+		rememberBtime(n.path(name))
+	}
+	errno = mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperRemove(name, false)
+		}
+		if n.mount.trash != "" {
+			return n.trash(name)
+		}
+		return n.LoopbackNode.Unlink(ctx, name)
+	})
+	if errno == fs.OK {
+		n.mount.created(-1)
+		n.mount.mirror(mirrorOp{op: "unlink", rel: n.rel(name)})
+	}
+	return errno
 }
 
-func (n *MutNode) Rmdir(ctx context.Context, name string) syscall.Errno {
-	errno := n.deny(ctx, name)
+func (n *MutNode) Rmdir(ctx context.Context, name string) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "rmdir", Path: n.rel(name)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("rmdir", n.rel(name), time.Now())
+	}
+	errno = n.deny(ctx, "rmdir", name)
 	if errno != fs.OK {
 		return errno
 	}
-	return n.LoopbackNode.Rmdir(ctx, name)
+	errno = mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperRemove(name, true)
+		}
+		return n.LoopbackNode.Rmdir(ctx, name)
+	})
+	if errno == fs.OK {
+		n.mount.created(-1)
+		n.mount.mirror(mirrorOp{op: "rmdir", rel: n.rel(name)})
+	}
+	return errno
 }
 
-func (n *MutNode) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	errno := n.deny(ctx, "")
+func (n *MutNode) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "removexattr", Path: n.rel(""), Attr: attr}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("removexattr", n.rel(""), time.Now())
+	}
+	if !allowedXattr(attr) {
+		caller, _ := fuse.FromContext(ctx)
+		errno = denied("removexattr", n.path(""), caller, "attribute "+strconv.Quote(attr)+" not allowed")
+		Stats.record("removexattr", errno)
+		return errno
+	}
+	errno = n.deny(ctx, "removexattr", "")
 	if errno != fs.OK {
 		return errno
 	}
-	return n.LoopbackNode.Removexattr(ctx, attr)
+	return mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperRemovexattr(attr)
+		}
+		return n.LoopbackNode.Removexattr(ctx, attr)
+	})
 }
 
-func (n *MutNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	errno := n.deny(ctx, "")
+func (n *MutNode) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() {
+			Recorder.record(ctx, recEntry{Op: "setxattr", Path: n.rel(""), Attr: attr, Flags: flags}, errno)
+		}()
+	}
+	if SlowThreshold > 0 {
+		defer slow("setxattr", n.rel(""), time.Now())
+	}
+	if ConfirmDelete > 0 && attr == confirmXattr {
+		confirm(n.path(""))
+		return fs.OK
+	}
+	if !allowedXattr(attr) {
+		caller, _ := fuse.FromContext(ctx)
+		errno = denied("setxattr", n.path(""), caller, "attribute "+strconv.Quote(attr)+" not allowed")
+		Stats.record("setxattr", errno)
+		return errno
+	}
+	errno = n.deny(ctx, "setxattr", "")
 	if errno != fs.OK {
 		return errno
 	}
-	return n.LoopbackNode.Setxattr(ctx, attr, data, flags)
+	return mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperSetxattr(attr, data, flags)
+		}
+		return n.LoopbackNode.Setxattr(ctx, attr, data, flags)
+	})
 }
 
-func (n *MutNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
-	errno := n.deny(ctx, "")
+func (n *MutNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, setattrEntry(n.rel(""), in), errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("setattr", n.rel(""), time.Now())
+	}
+	if sz, ok := in.GetSize(); NoZero && ok && sz == 0 {
+		caller, _ := fuse.FromContext(ctx)
+		if errno = zeroes("setattr", n.path(""), caller); errno != fs.OK {
+			Stats.record("setattr", errno)
+			return errno
+		}
+	}
+	errno = n.denyFlags(ctx, "setattr", "", in.Valid)
 	if errno != fs.OK {
 		return errno
 	}
-	return n.LoopbackNode.Setattr(ctx, f, in, out)
+	return mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperSetattr(ctx, f, in, out)
+		}
+		return n.LoopbackNode.Setattr(ctx, f, in, out)
+	})
 }
 
-func (n *MutNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
-	errno := n.deny(ctx, "")
+func (n *MutNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() {
+			Recorder.record(ctx, recEntry{Op: "rename", Path: n.rel(name), New: filepath.Join(newParent.EmbeddedInode().Path(nil), newName), Flags: flags}, errno)
+		}()
+	}
+	if SlowThreshold > 0 {
+		defer slow("rename", n.rel(name), time.Now())
+	}
+	if RenameFrom != nil {
+		if p, ok := newParent.(*MutNode); n.renamed(name) || ok && p.renamed(newName) {
+			return syscall.EROFS
+		}
+	}
+	if LockExtension && filepath.Ext(name) != filepath.Ext(newName) {
+		caller, _ := fuse.FromContext(ctx)
+		errno = denied("rename", n.path(name), caller, "extension changes to "+strconv.Quote(filepath.Ext(newName)))
+		Stats.record("rename", errno)
+		return errno
+	}
+	if newRel := filepath.Join(newParent.EmbeddedInode().Path(nil), newName); !inManifest(newRel) {
+		caller, _ := fuse.FromContext(ctx)
+		errno = unlisted("rename", n.path(name), newRel, caller)
+		Stats.record("rename", errno)
+		return errno
+	}
+	errno = n.deny(ctx, "rename", "")
 	if errno != fs.OK {
 		return errno
 	}
 
-	return n.LoopbackNode.Rename(ctx, name, newParent, newName, flags)
+	errno = mutate(ctx, func() syscall.Errno {
+		if n.mount.upper != "" {
+			return n.upperRename(name, newParent, newName)
+		}
+		return n.LoopbackNode.Rename(ctx, name, newParent, newName, flags)
+	})
+	if errno == fs.OK {
+		n.mount.mirror(mirrorOp{op: "rename", rel: n.rel(name), newRel: filepath.Join(newParent.EmbeddedInode().Path(nil), newName)})
+	}
+	if errno == fs.OK && RenameFrom != nil {
+		forgetRenames(n.path(""))
+		if p, ok := newParent.(*MutNode); ok {
+			forgetRenames(p.path(""))
+		}
+	}
+	return errno
 }
 
-func (n *MutNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+func (n *MutNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fflags uint32, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "open", Path: n.rel(""), Flags: flags}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("open", n.rel(""), time.Now())
+	}
 	if flags&syscall.O_CREAT != 0 {
 		fs1, flags1, errno1 := n.LoopbackNode.Open(ctx, flags)
 		if errno1 == syscall.ENOENT {
@@ -129,55 +350,1044 @@ func (n *MutNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32
 	case flags&syscall.O_TRUNC != 0:
 		fallthrough
 	case flags&syscall.O_RDWR != 0:
-		errno := n.deny(ctx, "")
+		if NoZero && flags&syscall.O_TRUNC != 0 {
+			caller, _ := fuse.FromContext(ctx)
+			if errno = zeroes("open", n.path(""), caller); errno != fs.OK {
+				Stats.record("open", errno)
+				return nil, 0, errno
+			}
+		}
+		errno = n.denyFlags(ctx, "open", "", flags)
+		if errno != fs.OK {
+			return nil, 0, errno
+		}
+		caller, _ := fuse.FromContext(ctx)
+		if errno = overloaded("open", n.path(""), caller); errno != fs.OK {
+			return nil, 0, errno
+		}
+		before := -1.0
+		if EntropyGuard {
+			if suspect(n.path("")) {
+				errno = denied("open", n.path(""), caller, "suspected encryption")
+				Stats.record("open", errno)
+				return nil, 0, errno
+			}
+			before = sampleEntropy(n.path(""))
+		}
+		orig := ""
+		if Quarantine != "" {
+			var err error
+			if orig, err = quarantine(n.path(""), n.rel("")); err != nil {
+				log.Printf("Failed to quarantine %q: %s", n.path(""), err)
+				return nil, 0, syscall.EIO
+			}
+		}
+		errno = mutate(ctx, func() (errno syscall.Errno) {
+			if n.mount.upper != "" {
+				fh, errno = n.upperOpen(flags)
+				return errno
+			}
+			fh, fflags, errno = n.LoopbackNode.Open(ctx, flags)
+			return errno
+		})
 		if errno != fs.OK {
 			return nil, 0, errno
 		}
-		return n.LoopbackNode.Open(ctx, flags)
+		fh = newHandle(n.mount, fh, n.path(""), n.rel(""), true)
+		if h, ok := fh.(*handle); ok {
+			h.append = StrictAppend && flags&syscall.O_APPEND != 0
+			h.orig = orig
+			h.entropy = before
+		}
+		return fh, fflags, errno
 	}
 
 	// I don't know what 0x8000 is, syscall.O_* doesn't have such a value...
 	flags = flags &^ 0x8000
 
-	if flags == syscall.O_RDONLY {
-		return n.LoopbackNode.Open(ctx, flags)
+	// O_NOFOLLOW and O_DIRECTORY don't make an open writable, they are passed on as is. Directories are opened
+	// through Opendir, an O_DIRECTORY open of a file fails with ENOTDIR in the underlying file system.
+	if flags&^(syscall.O_NOFOLLOW|syscall.O_DIRECTORY) == syscall.O_RDONLY {
+		if HonorACL {
+			if caller, _ := fuse.FromContext(ctx); !aclAllows(n.path(""), caller, aclRead) {
+				return nil, 0, syscall.EACCES
+			}
+		}
+		if ChecksumXattr != "" {
+			if err := verifyChecksum(n.path("")); err != nil {
+				log.Printf("Checksum verification of %q failed: %s", n.path(""), err)
+				return nil, 0, syscall.EIO
+			}
+		}
+		if MaxOpenFDs > 0 {
+			h, errno := newLazyHandle(n.path(""), n.rel(""), flags)
+			if errno != fs.OK {
+				return nil, 0, errno
+			}
+			return h, 0, fs.OK
+		}
+		errno = retry(ctx, func() (errno syscall.Errno) {
+			if n.mount.upper != "" {
+				fh, errno = n.upperOpen(flags)
+				return errno
+			}
+			fh, fflags, errno = n.LoopbackNode.Open(ctx, flags)
+			return errno
+		})
+		if errno != fs.OK {
+			return nil, 0, errno
+		}
+		if Recorder != nil || ReopenOnStale || SlowThreshold > 0 || CountReads {
+			fh = newHandle(n.mount, fh, n.path(""), n.rel(""), false)
+		}
+		return fh, fflags, errno
+	}
+
+	return nil, 0, errnoFor("open")
+}
+
+func (n *MutNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (inode *fs.Inode, fh fs.FileHandle, fflags uint32, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() {
+			Recorder.record(ctx, recEntry{Op: "create", Path: n.rel(name), Flags: flags, Mode: mode}, errno)
+		}()
 	}
+	if SlowThreshold > 0 {
+		defer slow("create", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, nil, 0, errno
+	}
+	if AllowExclCreate && flags&syscall.O_EXCL == 0 {
+		caller, _ := fuse.FromContext(ctx)
+		errno = denied("create", n.path(name), caller, "not an exclusive create")
+		Stats.record("create", errno)
+		return nil, nil, 0, errno
+	}
+	caller, _ := fuse.FromContext(ctx)
+	if errno = overloaded("create", n.path(name), caller); errno != fs.OK {
+		Stats.record("create", errno)
+		return nil, nil, 0, errno
+	}
+	if errno = n.denyCreate(ctx, "create", name); errno != fs.OK {
+		return nil, nil, 0, errno
+	}
+	errno = serialize(func() (errno syscall.Errno) {
+		if n.mount.upper != "" {
+			inode, fh, errno = n.upperCreate(ctx, name, flags, mode&^Umask, out)
+			return errno
+		}
+		inode, fh, fflags, errno = n.LoopbackNode.Create(ctx, name, flags, mode&^Umask, out)
+		return errno
+	})
+	if errno != fs.OK {
+		return nil, nil, 0, errno
+	}
+	n.mount.created(1)
+	n.mount.logChange("create", n.rel(name))
+	if StickyBtime {
+		inheritBtime(n.path(name))
+	}
+	Stats.window(n.path(name), now().Add(graceFor("open", name)))
+	return inode, newHandle(n.mount, fh, n.path(name), n.rel(name), true), fflags, errno
+}
 
-	return nil, 0, syscall.EACCES
+func (n *MutNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "mkdir", Path: n.rel(name), Mode: mode}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("mkdir", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	if errno = n.denyCreate(ctx, "mkdir", name); errno != fs.OK {
+		return nil, errno
+	}
+	errno = serialize(func() (errno syscall.Errno) {
+		if n.mount.upper != "" {
+			inode, errno = n.upperMkdir(ctx, name, mode&^Umask, out)
+			return errno
+		}
+		inode, errno = n.LoopbackNode.Mkdir(ctx, name, mode&^Umask, out)
+		return errno
+	})
+	if errno == fs.OK {
+		n.mount.created(1)
+		n.mount.mirror(mirrorOp{op: "mkdir", rel: n.rel(name)})
+	}
+	return inode, errno
 }
 
-func New(rootData *fs.LoopbackRoot, _ *fs.Inode, _ string, _ *syscall.Stat_t) fs.InodeEmbedder {
-	return &MutNode{LoopbackNode: fs.LoopbackNode{RootData: rootData}}
+func (n *MutNode) Mknod(ctx context.Context, name string, mode, rdev uint32, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "mknod", Path: n.rel(name), Mode: mode}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("mknod", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	if errno = n.denyCreate(ctx, "mknod", name); errno != fs.OK {
+		return nil, errno
+	}
+	errno = serialize(func() (errno syscall.Errno) {
+		if n.mount.upper != "" {
+			inode, errno = n.upperMknod(ctx, name, mode&^Umask, rdev, out)
+			return errno
+		}
+		inode, errno = n.LoopbackNode.Mknod(ctx, name, mode&^Umask, rdev, out)
+		return errno
+	})
+	if errno == fs.OK {
+		n.mount.created(1)
+	}
+	return inode, errno
 }
 
-var flagOpts *[]string
+var (
+	_ = (fs.NodeSymlinker)((*MutNode)(nil))
+	_ = (fs.NodeLinker)((*MutNode)(nil))
+)
 
-func main() {
-	flagOpts = flag.StringSliceP("opt", "o", nil, "options [debug,null,allow_other,ro,log,grace=<duration>")
-	flag.Parse()
-	if flag.NArg() < 2 {
-		fmt.Printf("usage: %s oldir newdir\n", path.Base(os.Args[0]))
-		fmt.Printf("\noptions:\n")
-		flag.PrintDefaults()
-		os.Exit(2)
+func (n *MutNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "symlink", Path: n.rel(name), New: target}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("symlink", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	if errno = n.denyCreate(ctx, "symlink", name); errno != fs.OK {
+		return nil, errno
 	}
+	errno = serialize(func() (errno syscall.Errno) {
+		if n.mount.upper != "" {
+			inode, errno = n.upperSymlink(ctx, target, name, out)
+			return errno
+		}
+		inode, errno = n.LoopbackNode.Symlink(ctx, target, name, out)
+		return errno
+	})
+	if errno == fs.OK {
+		n.mount.created(1)
+		n.mount.logChange("symlink", n.rel(name))
+	}
+	return inode, errno
+}
 
-	olddir := flag.Arg(0)
-	for _, d := range []string{olddir, flag.Arg(1)} {
+func (n *MutNode) Link(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "link", Path: n.rel(name)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("link", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	if errno = n.denyCreate(ctx, "link", name); errno != fs.OK {
+		return nil, errno
+	}
+	errno = serialize(func() (errno syscall.Errno) {
+		if n.mount.upper != "" {
+			inode, errno = n.upperLink(ctx, target, name, out)
+			return errno
+		}
+		inode, errno = n.LoopbackNode.Link(ctx, target, name, out)
+		return errno
+	})
+	if errno == fs.OK {
+		n.mount.created(1)
+		n.mount.logChange("link", n.rel(name))
+	}
+	return inode, errno
+}
+
+// Lookup hides symlinks that resolve outside of the source root when NoEscape is set. Readlink is left alone, so the
+// target can still be read, but the symlink itself can't be used to reach files outside the tree. Entries created
+// after a snapshot are hidden. Entries in a writable subtree are not cached.
+func (n *MutNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (inode *fs.Inode, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "lookup", Path: n.rel(name)}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("lookup", n.rel(name), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		return nil, errno
+	}
+	if NoEscape && n.escapes(name) {
+		if Log {
+			caller, _ := fuse.FromContext(ctx)
+			log.Printf("Lookup of escaping symlink %q denied from pid %d, from %d/%d", n.path(name), caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
+		}
+		return nil, syscall.ENOENT
+	}
+	if n.mount.snapshot && n.afterSnapshot(name) {
+		return nil, syscall.ENOENT
+	}
+	if len(Hide) > 0 && hidden(n.rel(name)) {
+		return nil, syscall.ENOENT
+	}
+	if ManifestHide && !inManifest(n.rel(name)) {
+		return nil, syscall.ENOENT
+	}
+	if n.mount.scratch != "" && n.IsRoot() && name == n.mount.scratch {
+		return n.lookupScratch(ctx, out)
+	}
+	if n.mount.hasMeta() && n.IsRoot() && name == metaName {
+		return n.lookupMeta(ctx, out), fs.OK
+	}
+	if RenameFrom != nil {
+		if inode, errno, ok := n.lookupRenamed(ctx, name, out); ok {
+			return inode, errno
+		}
+	}
+	errno = retry(ctx, func() (errno syscall.Errno) {
+		if n.mount.upper != "" && n.inUpper(name) {
+			inode, errno = n.upperLookup(ctx, name, out)
+			return errno
+		}
+		inode, errno = n.LoopbackNode.Lookup(ctx, name, out)
+		return errno
+	})
+	if errno == fs.OK && writable(n.rel(name)) {
+		out.SetEntryTimeout(0)
+		out.SetAttrTimeout(0)
+	}
+	return inode, errno
+}
+
+func (n *MutNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) (errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "getattr", Path: n.rel("")}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("getattr", n.rel(""), time.Now())
+	}
+	if errno = n.unavailable(); errno != fs.OK {
+		if n.IsRoot() {
+			// the mount itself needs the root, so it's an empty directory until the source is there
+			out.Mode = syscall.S_IFDIR | 0755
+			out.Nlink = 2
+			out.SetTimeout(0)
+			return fs.OK
+		}
+		return errno
+	}
+	errno = retry(ctx, func() syscall.Errno {
+		if n.mount.upper != "" && f == nil && n.inUpper("") {
+			return n.upperGetattr(out)
+		}
+		return n.LoopbackNode.Getattr(ctx, f, out)
+	})
+	if errno == fs.OK && writable(n.rel("")) {
+		out.SetTimeout(0)
+	}
+	return errno
+}
+
+// checkDirs checks that olddir and newdir are distinct directories. With LazySource olddir may not exist yet.
+func checkDirs(olddir, newdir string) error {
+	fis := make([]os.FileInfo, 2)
+	for i, d := range []string{olddir, newdir} {
 		fi, err := os.Stat(d)
+		if err != nil && i == 0 && LazySource && os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
-			log.Fatalf("Can't stat %q: %s", d, err)
+			return fmt.Errorf("Can't stat %q: %s", d, err)
 		}
 		if !fi.IsDir() {
-			log.Fatalf("%q isn't a directory", d)
+			return fmt.Errorf("%q isn't a directory", d)
 		}
+		fis[i] = fi
+	}
+	if fis[0] != nil && os.SameFile(fis[0], fis[1]) {
+		return fmt.Errorf("%q and %q are the same directory", olddir, newdir)
 	}
+	return nil
+}
 
-	rootData := &fs.LoopbackRoot{
-		NewNode: New,
-		Path:    olddir,
+// minIOSize is the smallest max-read and max-write, a page.
+const minIOSize = 4096
+
+// errUnknownOption is returned by parseOpt for options it doesn't know, these may be meant for mount(8).
+var errUnknownOption = errors.New("unknown option")
+
+// parseOpt parses the option o and applies it to opts or the global configuration. The per-mount options are applied
+// to defaultMount.
+func parseOpt(opts *fs.Options, olddir, o string) error {
+	if err := defaultMount.parseOpt(o); !errors.Is(err, errUnknownOption) {
+		return err
 	}
-	mutnode := New(rootData, nil, "", nil)
+	switch {
+	case o == "debug":
+		opts.Debug = true
+	case o == "null":
+		opts.NullPermissions = true
+	case o == "allow_other":
+		opts.AllowOther = true
+		opts.MountOptions.Options = append(opts.MountOptions.Options, "default_permissions")
+	case o == "log":
+		Log = true
+	case o == "nfs-safe":
+		NoBtime = true
+		zero := time.Duration(0)
+		opts.AttrTimeout = &zero
+		opts.EntryTimeout = &zero
+	case o == "require-tty":
+		RequireTTY = true
+	case o == "preload":
+		Preload = true
+	case o == "allow-excl-create":
+		AllowExclCreate = true
+	case o == "trace-decisions":
+		TraceDecisions = true
+	case o == "notify":
+		Notify = true
+	case o == "strict-append":
+		StrictAppend = true
+	case o == "auditd":
+		Audit = true
+	case o == "confirm-delete":
+		ConfirmDelete = time.Minute
+	case strings.HasPrefix(o, "confirm-delete="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "confirm-delete="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified confirm-delete: %s", o)
+		}
+		ConfirmDelete = d
+	case o == "two-person-delete":
+		TwoPersonDelete = 5 * time.Minute
+	case strings.HasPrefix(o, "two-person-delete="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "two-person-delete="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified two-person-delete: %s", o)
+		}
+		TwoPersonDelete = d
+	case strings.HasPrefix(o, "approver="):
+		uid, err := strconv.ParseUint(strings.TrimPrefix(o, "approver="), 10, 32)
+		if err != nil {
+			return fmt.Errorf("Wrongly specified approver: %s", o)
+		}
+		Approvers = append(Approvers, uint32(uid))
+	case o == "serialize-mutations":
+		SerializeMutations = true
+	case o == "checksum-on-write":
+		ChecksumOnWrite = true
+	case o == "lock-extension":
+		LockExtension = true
+	case o == "reopen-on-stale":
+		ReopenOnStale = true
+	case o == "sticky-btime":
+		StickyBtime = true
+	case o == "lazy-source":
+		LazySource = true
+	case strings.HasPrefix(o, "lazy-source="):
+		e, ok := errnoNames[strings.ToUpper(strings.TrimPrefix(o, "lazy-source="))]
+		if !ok {
+			return fmt.Errorf("Wrongly specified lazy-source: %s", o)
+		}
+		LazySource, LazySourceErrno = true, e
+	case o == "entropy-guard":
+		EntropyGuard = true
+	case o == "entropy-guard=block":
+		EntropyGuard, EntropyBlock = true, true
+	case strings.HasPrefix(o, "manifest="):
+		m, err := readManifest(strings.TrimPrefix(o, "manifest="))
+		if err != nil {
+			return fmt.Errorf("Failed to read manifest: %s: %s", o, err)
+		}
+		Manifest, manifestPath = m, strings.TrimPrefix(o, "manifest=")
+	case o == "manifest-hide":
+		ManifestHide = true
+	case o == "sorted-readdir":
+		SortedReaddir = true
+	case o == "no-zero":
+		NoZero = true
+	case o == "honor-acl":
+		HonorACL = true
+	case o == "respect-open":
+		RespectOpen = true
+	case o == "strict-config":
+		StrictConfig = true
+	case o == "count-reads":
+		CountReads = true
+	case o == "freeze-content":
+		FreezeContent = true
+	case o == "freeze-structure":
+		FreezeStructure = true
+	case o == "last-denied":
+		LastDenied = true
+	case o == "log-backing-path":
+		LogBackingPath = true
+	case o == "no-suid-callers":
+		NoSuidCallers = true
+	case o == "no-escape":
+		NoEscape = true
+	case strings.HasPrefix(o, "grace="):
+		xs := strings.Split(o, "=")
+		if len(xs) != 2 {
+			return fmt.Errorf("Wrongly specified grace: %s", o)
+		}
+		d, err := time.ParseDuration(xs[1])
+		if err != nil {
+			return fmt.Errorf("Wrongly specified grace: %s: %s", o, err)
+		}
+		Grace = d
+	case strings.HasPrefix(o, "grace-cooldown="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "grace-cooldown="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified grace-cooldown: %s", o)
+		}
+		GraceCooldown = d
+	case strings.HasPrefix(o, "grace-ext="):
+		xs := strings.SplitN(strings.TrimPrefix(o, "grace-ext="), ":", 2)
+		if len(xs) != 2 || !strings.HasPrefix(xs[0], ".") {
+			return fmt.Errorf("Wrongly specified grace-ext: %s", o)
+		}
+		d, err := time.ParseDuration(xs[1])
+		if err != nil {
+			return fmt.Errorf("Wrongly specified grace-ext: %s: %s", o, err)
+		}
+		GraceExt[xs[0]] = d
+	case strings.HasPrefix(o, "grace-until="):
+		t, err := time.Parse(time.RFC3339, strings.TrimPrefix(o, "grace-until="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified grace-until: %s: %s", o, err)
+		}
+		GraceUntil = t
+	case strings.HasPrefix(o, "grace-under="):
+		w := filepath.Clean(strings.TrimPrefix(o, "grace-under="))
+		if filepath.IsAbs(w) || strings.HasPrefix(w, "..") {
+			return fmt.Errorf("Wrongly specified grace-under, must be relative to %q: %s", olddir, o)
+		}
+		GraceUnder = append(GraceUnder, w)
+	case strings.HasPrefix(o, "max-read="):
+		size, err := strconv.Atoi(strings.TrimPrefix(o, "max-read="))
+		if err != nil || size < minIOSize || size > fuse.MAX_KERNEL_WRITE {
+			return fmt.Errorf("Wrongly specified max-read, must be between %d and %d: %s", minIOSize, fuse.MAX_KERNEL_WRITE, o)
+		}
+		opts.MountOptions.Options = append(opts.MountOptions.Options, "max_read="+strconv.Itoa(size))
+	case strings.HasPrefix(o, "max-write="):
+		size, err := strconv.Atoi(strings.TrimPrefix(o, "max-write="))
+		if err != nil || size < minIOSize || size > fuse.MAX_KERNEL_WRITE {
+			return fmt.Errorf("Wrongly specified max-write, must be between %d and %d: %s", minIOSize, fuse.MAX_KERNEL_WRITE, o)
+		}
+		opts.MountOptions.MaxWrite = size
+	case strings.HasPrefix(o, "grace-maxsize="):
+		size, err := strconv.ParseInt(strings.TrimPrefix(o, "grace-maxsize="), 10, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("Wrongly specified grace-maxsize: %s", o)
+		}
+		GraceMaxSize = size
+	case strings.HasPrefix(o, "grace-"):
+		xs := strings.SplitN(strings.TrimPrefix(o, "grace-"), "=", 2)
+		op := xs[0]
+		if op == "write" {
+			op = "open"
+		}
+		if len(xs) != 2 || !ops[op] {
+			return fmt.Errorf("Wrongly specified grace: %s", o)
+		}
+		d, err := time.ParseDuration(xs[1])
+		if err != nil {
+			return fmt.Errorf("Wrongly specified grace: %s: %s", o, err)
+		}
+		GraceOp[op] = d
+	case strings.HasPrefix(o, "writable="):
+		w := filepath.Clean(strings.TrimPrefix(o, "writable="))
+		if filepath.IsAbs(w) || strings.HasPrefix(w, "..") {
+			return fmt.Errorf("Wrongly specified writable, must be relative to %q: %s", olddir, o)
+		}
+		Writable = append(Writable, w)
+	case strings.HasPrefix(o, "rule="):
+		r, err := parseOpRule(strings.TrimPrefix(o, "rule="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified rule: %s: %s", o, err)
+		}
+		OpRules = append(OpRules, r)
+	case strings.HasPrefix(o, "events="):
+		if _, _, err := parseNATS(strings.TrimPrefix(o, "events=")); err != nil {
+			return fmt.Errorf("Wrongly specified events: %s: %s", o, err)
+		}
+		Events = strings.TrimPrefix(o, "events=")
+	case strings.HasPrefix(o, "event-pipe="):
+		EventPipe = strings.TrimPrefix(o, "event-pipe=")
+	case strings.HasPrefix(o, "unlock-file="):
+		UnlockFile = strings.TrimPrefix(o, "unlock-file=")
+		if !filepath.IsAbs(UnlockFile) {
+			return fmt.Errorf("Wrongly specified unlock-file, must be an absolute path: %s", o)
+		}
+		if err := checkUnlockFile(UnlockFile); err != nil {
+			return fmt.Errorf("Wrongly specified unlock-file: %s: %s", o, err)
+		}
+	case strings.HasPrefix(o, "summary="):
+		Summary = strings.TrimPrefix(o, "summary=")
+	case strings.HasPrefix(o, "control="):
+		Control = strings.TrimPrefix(o, "control=")
+	case strings.HasPrefix(o, "protect-after="):
+		t, err := time.Parse(time.RFC3339, strings.TrimPrefix(o, "protect-after="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified protect-after: %s: %s", o, err)
+		}
+		ProtectAfter = t
+	case strings.HasPrefix(o, "protect-before="):
+		t, err := time.Parse(time.RFC3339, strings.TrimPrefix(o, "protect-before="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified protect-before: %s: %s", o, err)
+		}
+		ProtectBefore = t
+	case strings.HasPrefix(o, "protect-older-than="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "protect-older-than="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified protect-older-than: %s", o)
+		}
+		ProtectOlderThan = d
+	case strings.HasPrefix(o, "protect-type="):
+		typ := strings.TrimPrefix(o, "protect-type=")
+		if !strings.Contains(typ, "/") {
+			return fmt.Errorf("Wrongly specified protect-type: %s", o)
+		}
+		ProtectType = append(ProtectType, typ)
+	case strings.HasPrefix(o, "umask="):
+		mask, err := strconv.ParseUint(strings.TrimPrefix(o, "umask="), 8, 32)
+		if err != nil || mask > 0777 {
+			return fmt.Errorf("Wrongly specified umask: %s", o)
+		}
+		Umask = uint32(mask)
+	case strings.HasPrefix(o, "record="):
+		recordPath = strings.TrimPrefix(o, "record=")
+	case strings.HasPrefix(o, "max-open-fds="):
+		n, err := strconv.Atoi(strings.TrimPrefix(o, "max-open-fds="))
+		if err != nil || n < 1 {
+			return fmt.Errorf("Wrongly specified max-open-fds: %s", o)
+		}
+		MaxOpenFDs = n
+	case strings.HasPrefix(o, "logbuffer="):
+		n, err := strconv.Atoi(strings.TrimPrefix(o, "logbuffer="))
+		if err != nil || n < 0 {
+			return fmt.Errorf("Wrongly specified logbuffer: %s", o)
+		}
+		LogBuffer = n
+	case strings.HasPrefix(o, "write-bps="):
+		n, err := strconv.ParseInt(strings.TrimPrefix(o, "write-bps="), 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Wrongly specified write-bps: %s", o)
+		}
+		WriteBPS = n
+	case strings.HasPrefix(o, "slow-threshold="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "slow-threshold="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified slow-threshold: %s", o)
+		}
+		SlowThreshold = d
+	case strings.HasPrefix(o, "max-depth="):
+		n, err := strconv.Atoi(strings.TrimPrefix(o, "max-depth="))
+		if err != nil || n < 0 {
+			return fmt.Errorf("Wrongly specified max-depth: %s", o)
+		}
+		MaxDepth = n
+	case strings.HasPrefix(o, "max-load="):
+		l, err := strconv.ParseFloat(strings.TrimPrefix(o, "max-load="), 64)
+		if err != nil || l <= 0 {
+			return fmt.Errorf("Wrongly specified max-load: %s", o)
+		}
+		MaxLoad = l
+	case strings.HasPrefix(o, "diff-log="):
+		DiffLog = strings.TrimPrefix(o, "diff-log=")
+	case strings.HasPrefix(o, "diff-log-maxsize="):
+		size, err := strconv.ParseInt(strings.TrimPrefix(o, "diff-log-maxsize="), 10, 64)
+		if err != nil || size < 1 {
+			return fmt.Errorf("Wrongly specified diff-log-maxsize: %s", o)
+		}
+		DiffLogMaxSize = size
+	case strings.HasPrefix(o, "readdir-batch="):
+		n, err := strconv.Atoi(strings.TrimPrefix(o, "readdir-batch="))
+		if err != nil || n < 1 {
+			return fmt.Errorf("Wrongly specified readdir-batch: %s", o)
+		}
+		ReaddirBatch = n
+	case strings.HasPrefix(o, "quarantine-coalesce="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "quarantine-coalesce="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified quarantine-coalesce: %s", o)
+		}
+		QuarantineCoalesce = d
+	case strings.HasPrefix(o, "quarantine-compress="):
+		QuarantineCompress = strings.TrimPrefix(o, "quarantine-compress=")
+		if QuarantineCompress != "gzip" {
+			return fmt.Errorf("Wrongly specified quarantine-compress, only gzip is supported: %s", o)
+		}
+	case strings.HasPrefix(o, "quarantine="):
+		Quarantine = strings.TrimPrefix(o, "quarantine=")
+		if Quarantine == "" {
+			return fmt.Errorf("Wrongly specified quarantine: %s", o)
+		}
+	case strings.HasPrefix(o, "hide="):
+		pattern := strings.TrimPrefix(o, "hide=")
+		if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("Wrongly specified hide: %s", o)
+		}
+		Hide = append(Hide, pattern)
+	case strings.HasPrefix(o, "checksum-xattr="):
+		ChecksumXattr = strings.TrimPrefix(o, "checksum-xattr=")
+		if ChecksumXattr == "" {
+			return fmt.Errorf("Wrongly specified checksum-xattr: %s", o)
+		}
+	case strings.HasPrefix(o, "hide-xattr="):
+		prefix := strings.TrimPrefix(o, "hide-xattr=")
+		if prefix == "" {
+			return fmt.Errorf("Wrongly specified hide-xattr: %s", o)
+		}
+		HideXattr = append(HideXattr, prefix)
+	case strings.HasPrefix(o, "allow-xattr-ns="):
+		prefix := strings.TrimPrefix(o, "allow-xattr-ns=")
+		if prefix == "" {
+			return fmt.Errorf("Wrongly specified allow-xattr-ns: %s", o)
+		}
+		AllowXattrNS = append(AllowXattrNS, prefix)
+	case strings.HasPrefix(o, "hashlog="):
+		hashlogPath = strings.TrimPrefix(o, "hashlog=")
+	case strings.HasPrefix(o, "allow-uid="):
+		r, err := parseUIDRule(strings.TrimPrefix(o, "allow-uid="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified allow-uid: %s: %s", o, err)
+		}
+		AllowUID = append(AllowUID, r)
+	case strings.HasPrefix(o, "allow-cgroup="):
+		pattern := strings.TrimPrefix(o, "allow-cgroup=")
+		if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("Wrongly specified allow-cgroup: %s", o)
+		}
+		AllowCgroup = append(AllowCgroup, pattern)
+	case strings.HasPrefix(o, "require-env="):
+		env := strings.TrimPrefix(o, "require-env=")
+		if i := strings.Index(env, "="); i <= 0 {
+			return fmt.Errorf("Wrongly specified require-env, must be NAME=VALUE: %s", o)
+		}
+		RequireEnv = append(RequireEnv, env)
+	case strings.HasPrefix(o, "rename-map="):
+		re, to, err := parseRenameMap(strings.TrimPrefix(o, "rename-map="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified rename-map: %s: %s", o, err)
+		}
+		RenameFrom, RenameTo = re, to
+	case strings.HasPrefix(o, "deny-cmdline="):
+		sub := strings.TrimPrefix(o, "deny-cmdline=")
+		if sub == "" {
+			return fmt.Errorf("Wrongly specified deny-cmdline: %s", o)
+		}
+		DenyCmdline = append(DenyCmdline, sub)
+	case strings.HasPrefix(o, "caller-rate="):
+		n, d, err := parseRate(strings.TrimPrefix(o, "caller-rate="))
+		if err != nil {
+			return fmt.Errorf("Wrongly specified caller-rate: %s: %s", o, err)
+		}
+		CallerRate, CallerRatePer = n, d
+	case strings.HasPrefix(o, "caller-min-age="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "caller-min-age="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified caller-min-age: %s", o)
+		}
+		CallerMinAge = d
+	case strings.HasPrefix(o, "allow-exe="), strings.HasPrefix(o, "deny-exe="):
+		xs := strings.SplitN(o, "=", 2)
+		if _, err := filepath.Match(xs[1], ""); err != nil || !strings.HasPrefix(xs[1], "/") {
+			return fmt.Errorf("Wrongly specified %s, must be an absolute path: %s", xs[0], o)
+		}
+		if xs[0] == "allow-exe" {
+			AllowExe = append(AllowExe, xs[1])
+		} else {
+			DenyExe = append(DenyExe, xs[1])
+		}
+	case strings.HasPrefix(o, "errno="):
+		if err := parseErrno(strings.TrimPrefix(o, "errno=")); err != nil {
+			return fmt.Errorf("Wrongly specified errno: %s: %s", o, err)
+		}
+	case strayErrno(o):
+		return fmt.Errorf("Wrongly specified errno, separate multiple errnos with a '+' or repeat errno=: %s", o)
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOption, o)
+	}
+	return nil
+}
+
+// checkOpts checks the options that depend on each other.
+func checkOpts() error {
+	if DiffLog != "" && Quarantine == "" {
+		return fmt.Errorf("Option diff-log needs quarantine")
+	}
+	if LogBuffer > 0 && Control == "" {
+		return fmt.Errorf("Option logbuffer needs control")
+	}
+	if CountReads && Control == "" {
+		return fmt.Errorf("Option count-reads needs control")
+	}
+	if QuarantineCoalesce > 0 && Quarantine == "" {
+		return fmt.Errorf("Option quarantine-coalesce needs quarantine")
+	}
+	if LazySource && LogBackingPath {
+		return fmt.Errorf("Option lazy-source can't be used with log-backing-path")
+	}
+	if TwoPersonDelete > 0 && Control == "" {
+		return fmt.Errorf("Option two-person-delete needs control")
+	}
+	if TwoPersonDelete > 0 && len(Approvers) < 2 {
+		return fmt.Errorf("Option two-person-delete needs at least two approvers")
+	}
+	if ManifestHide && Manifest == nil {
+		return fmt.Errorf("Option manifest-hide needs manifest")
+	}
+	if ChecksumOnWrite && ChecksumXattr == "" {
+		return fmt.Errorf("Option checksum-on-write needs checksum-xattr")
+	}
+	return defaultMount.check()
+}
+
+// lintOpts returns warnings about combinations of options that are allowed, but probably not what was meant.
+func lintOpts(mounts []*mount) []string {
+	var warnings []string
+	grace := Grace > 0 || len(GraceOp) > 0 || len(GraceExt) > 0 || !GraceUntil.IsZero()
+	changelog := false
+	for _, m := range mounts {
+		changelog = changelog || m.changelog
+	}
+	if grace && !Log && hashlogPath == "" && !Audit && recordPath == "" && Quarantine == "" && !changelog && Events == "" {
+		warnings = append(warnings, "grace period without log, hashlog, auditd, record, quarantine, changelog or events: mutations are not recorded")
+	}
+	for _, w := range Writable {
+		if w == "." {
+			warnings = append(warnings, "writable=. makes everything writable")
+		}
+	}
+	if Quarantine != "" {
+		q, _ := filepath.Abs(Quarantine)
+		for _, m := range mounts {
+			o, _ := filepath.Abs(m.olddir)
+			if q == o || strings.HasPrefix(q, o+string(filepath.Separator)) {
+				warnings = append(warnings, "quarantine directory is under "+m.olddir)
+			}
+		}
+	}
+	return warnings
+}
+
+// lint logs the warnings of lintOpts, with StrictConfig the first one is returned as an error instead.
+func lint(mounts []*mount) error {
+	for _, w := range lintOpts(mounts) {
+		if StrictConfig {
+			return fmt.Errorf("Option check failed: %s", w)
+		}
+		log.Printf("Warning: %s", w)
+	}
+	return nil
+}
+
+// openOutputs opens the files, pipes and sockets the options write to. This is separate from parsing the options, so
+// that can be done without side effects.
+func openOutputs() error {
+	if recordPath != "" {
+		r, err := openRecorder(recordPath)
+		if err != nil {
+			return fmt.Errorf("Failed to open record: %s: %s", recordPath, err)
+		}
+		Recorder = r
+	}
+	if hashlogPath != "" {
+		h, err := openHashlog(hashlogPath)
+		if err != nil {
+			return fmt.Errorf("Failed to open hash log: %s: %s", hashlogPath, err)
+		}
+		HashLog = h
+	}
+	if DiffLog != "" {
+		if err := openDiffLog(DiffLog); err != nil {
+			return fmt.Errorf("Failed to open diff log: %s: %s", DiffLog, err)
+		}
+	}
+	if Events != "" {
+		addr, subject, _ := parseNATS(Events)
+		startNATS(addr, subject)
+	}
+	if EventPipe != "" {
+		if err := openEventPipe(EventPipe); err != nil {
+			return fmt.Errorf("Failed to open event pipe: %s: %s", EventPipe, err)
+		}
+	}
+	if Audit {
+		if err := openAudit(); err != nil {
+			log.Printf("Failed to open audit socket, auditd is disabled: %s", err)
+			Audit = false
+		}
+	}
+	return nil
+}
+
+var (
+	flagOpts          *[]string
+	flagVerifyHashlog *string
+	flagReplay        *string
+	flagSourceFD      *int
+	flagMounts        *string
+	flagReadyFD       *int
+	flagUser          *string
+	flagGroup         *string
+	flagChroot        *string
+	flagPrintConfig   *bool
+	flagConfig        *string
+	flagTestConfig    *string
+)
+
+// signalReady writes a newline to, and closes, file descriptor fd. A parent waiting for us reads a byte, or EOF,
+// once we're serving.
+func signalReady(fd int) error {
+	ready := os.NewFile(uintptr(fd), "ready")
+	defer ready.Close()
+	_, err := ready.Write([]byte{'\n'})
+	return err
+}
+
+// sourceFD returns the path of the directory the open file descriptor fd refers to. The kernel resolves this path to
+// the directory itself, not to the path it was opened with.
+func sourceFD(fd int) (string, error) {
+	st := &syscall.Stat_t{}
+	if err := syscall.Fstat(fd, st); err != nil {
+		return "", fmt.Errorf("file descriptor %d: %s", fd, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return "", fmt.Errorf("file descriptor %d isn't a directory", fd)
+	}
+	return fmt.Sprintf("/proc/self/fd/%d", fd), nil
+}
+
+// optionList returns the options from the config file at config, when given, then the ones from the MUTFS_OPTS
+// environment variable and then opts, so the ones given with -o take precedence.
+func optionList(config string, opts []string) ([]string, error) {
+	var optList []string
+	if config != "" {
+		conf, _, err := readConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		optList = conf
+	}
+	if env := os.Getenv("MUTFS_OPTS"); env != "" {
+		optList = append(optList, strings.Split(env, ",")...)
+	}
+	return append(optList, opts...), nil
+}
+
+// optUsage lists the options for -o in the usage.
+var optUsage = []string{
+	"debug", "null", "allow_other", "ro", "log", "no-escape", "grace=<duration>", "errno=[<op>:]<errno>[+...]",
+	"grace-maxsize=<bytes>", "quarantine=<dir>", "nfs-safe", "hide-xattr=<prefix>", "hashlog=<path>",
+	"allow-uid=<uid|uid-uid|@group>", "require-tty", "preload", "grace-<op>=<duration>", "writable=<path>",
+	"summary=<path>", "allow-excl-create", "trace-decisions", "control=<path>", "protect-type=<mime>",
+	"umask=<octal>", "mirror=<dir>", "allow-cgroup=<pattern>", "notify", "snapshot", "record=<path>",
+	"readdir-batch=<n>", "reopen-on-stale", "max-files=<n>", "quarantine-compress=gzip", "strict-append",
+	"diff-log=<path>", "diff-log-maxsize=<bytes>", "grace-cooldown=<duration>", "hide=<pattern>",
+	"protect-after=<time>", "protect-before=<time>", "protect-older-than=<duration>", "auditd",
+	"confirm-delete[=<duration>]", "event-pipe=<path>", "max-load=<load>", "grace-ext=<.ext>:<duration>",
+	"serialize-mutations", "trash=<dir>", "grace-until=<time>", "checksum-xattr=<name>", "checksum-on-write",
+	"max-open-fds=<n>", "unlock-file=<path>", "upper=<dir>", "lock-extension",
+	"rule=<allow|deny>:<op>[+<op>...]:<pattern>", "write-bps=<bytes>", "logbuffer=<n>", "single-fs", "changelog",
+	"no-suid-callers", "sticky-btime", "max-depth=<n>", "slow-threshold=<duration>", "allow-exe=<pattern>",
+	"deny-exe=<pattern>", "quarantine-coalesce=<duration>", "grace-under=<path>", "log-backing-path", "last-denied",
+	"lazy-source[=<errno>]", "caller-min-age=<duration>", "freeze-structure", "freeze-content",
+	"require-env=<name>=<value>", "scratch=<name>", "allow-xattr-ns=<prefix>", "count-reads",
+	"deny-cmdline=<string>", "snapshot-interval=<duration>", "snapshot-dir=<dir>", "strict-config", "respect-open",
+	"rename-map=<regexp:replacement>", "caller-rate=<n/duration>", "honor-acl", "no-zero", "entropy-guard[=block]",
+	"max-read=<bytes>", "max-write=<bytes>", "sorted-readdir", "manifest=<file>", "manifest-hide",
+	"events=<nats://host:port/subject>", "two-person-delete[=<duration>]", "approver=<uid>",
+}
+
+func main() {
+	flagOpts = flag.StringSliceP("opt", "o", nil, "options ["+strings.Join(optUsage, ",")+"]")
+	flagVerifyHashlog = flag.String("verify-hashlog", "", "verify the integrity of the hash log and exit")
+	flagReplay = flag.String("replay", "", "replay the record against the mutfs mounted on the given directory and exit")
+	flagUser = flag.String("user", "", "change to this user after mounting")
+	flagGroup = flag.String("group", "", "change to this group after mounting")
+	flagChroot = flag.String("chroot", "", "chroot to this directory, which must contain olddir, after mounting")
+	flagConfig = flag.String("config", "", "read options from this file")
+	flagTestConfig = flag.String("test-config", "", "check the options in this file and exit")
+	flagPrintConfig = flag.Bool("print-config", false, "print the configuration as JSON and exit")
+	flagSourceFD = flag.Int("source-fd", -1, "use the already open directory file descriptor as olddir")
+	flagMounts = flag.String("mounts", "", "mount each olddir and newdir pair in this file")
+	flagReadyFD = flag.Int("ready-fd", -1, "write a newline to, and close, this file descriptor once mounted")
+	flag.Parse()
+	if *flagReplay != "" {
+		if flag.NArg() < 1 {
+			fatal(exitUsage, "Missing mount point to replay %q against", *flagReplay)
+		}
+		mismatch, err := replay(*flagReplay, flag.Arg(0), os.Stdout)
+		if err != nil {
+			log.Fatalf("Failed to replay %q: %s", *flagReplay, err)
+		}
+		if mismatch > 0 {
+			os.Exit(exitFailure)
+		}
+		os.Exit(0)
+	}
+	if *flagTestConfig != "" {
+		if err := testConfig(*flagTestConfig); err != nil {
+			fatal(exitOption, "Config %q is wrong: %s", *flagTestConfig, err)
+		}
+		fmt.Printf("Config %q is OK\n", *flagTestConfig)
+		os.Exit(0)
+	}
+	if *flagVerifyHashlog != "" {
+		if err := verifyHashlog(*flagVerifyHashlog); err != nil {
+			log.Fatalf("Hash log %q is corrupted: %s", *flagVerifyHashlog, err)
+		}
+		fmt.Printf("Hash log %q is OK\n", *flagVerifyHashlog)
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "selftest" {
+		failed, err := selftest(os.Stdout)
+		if err != nil {
+			fatal(exitMount, "Self test failed: %s", err)
+		}
+		if failed > 0 {
+			os.Exit(exitFailure)
+		}
+		os.Exit(0)
+	}
+	args := flag.Args()
+	if *flagSourceFD >= 0 {
+		olddir, err := sourceFD(*flagSourceFD)
+		if err != nil {
+			fatal(exitDir, "Option source-fd: %s", err)
+		}
+		args = append([]string{olddir}, args...)
+	}
+	var lines []mountLine
+	switch {
+	case *flagMounts != "":
+		l, err := readMounts(*flagMounts)
+		if err != nil {
+			fatal(exitUsage, "Failed to read mounts: %s", err)
+		}
+		lines = l
+	case len(args) >= 2:
+		lines = []mountLine{{olddir: args[0], newdir: args[1]}}
+	default:
+		fmt.Printf("usage: %s oldir newdir\n", path.Base(os.Args[0]))
+		fmt.Printf("       %s --source-fd fd newdir\n", path.Base(os.Args[0]))
+		fmt.Printf("       %s --mounts file\n", path.Base(os.Args[0]))
+		fmt.Printf("       %s selftest\n", path.Base(os.Args[0]))
+		fmt.Printf("\noptions:\n")
+		flag.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	olddir := lines[0].olddir
 
 	sec := time.Second
 	opts := &fs.Options{
@@ -185,39 +1395,143 @@ func main() {
 		EntryTimeout: &sec,
 	}
 
-	for _, o := range *flagOpts {
-		switch {
-		case o == "debug":
-			opts.Debug = true
-		case o == "null":
-			opts.NullPermissions = true
-		case o == "allow_other":
-			opts.AllowOther = true
-			opts.MountOptions.Options = append(opts.MountOptions.Options, "default_permissions")
-		case o == "ro":
-			opts.MountOptions.Options = append(opts.MountOptions.Options, "ro")
-		case o == "log":
-			Log = true
-		case strings.HasPrefix(o, "grace="):
-			xs := strings.Split(o, "=")
-			if len(xs) != 2 {
-				log.Fatalf("Wrongly specified grace: %s", o)
-			}
-			d, err := time.ParseDuration(xs[1])
-			if err != nil {
-				log.Fatalf("Wrongly specified grace: %s: %s", o, err)
-			}
-			Grace = d
-
+	optList, err := optionList(*flagConfig, *flagOpts)
+	if err != nil {
+		fatal(exitOption, "Failed to read config: %s", err)
+	}
+	for _, o := range optList {
+		if err := parseOpt(opts, olddir, o); err != nil && !errors.Is(err, errUnknownOption) {
+			fatal(exitOption, "%s", err)
+		}
+	}
+	if err := checkOpts(); err != nil {
+		fatal(exitOption, "%s", err)
+	}
+	var mounts []*mount
+	for _, l := range lines {
+		m, err := newMount(l.olddir, l.newdir, l.opts)
+		if err != nil {
+			fatal(exitOption, "Mount of %q: %s", l.olddir, err)
 		}
+		mounts = append(mounts, m)
+	}
+	if err := lint(mounts); err != nil {
+		fatal(exitOption, "%s", err)
+	}
+	if err := checkMounts(mounts); err != nil {
+		fatal(exitOption, "%s", err)
+	}
+	for _, m := range mounts {
+		if err := checkDirs(m.olddir, m.newdir); err != nil {
+			fatal(exitDir, "%s", err)
+		}
+	}
+	if *flagPrintConfig {
+		printConfig(os.Stdout, opts, mounts)
+		os.Exit(0)
+	}
+	if err := openOutputs(); err != nil {
+		fatal(exitOption, "%s", err)
+	}
+	if SerializeMutations {
+		startSerializer()
 	}
-	opts.MountOptions.Options = append(opts.MountOptions.Options, "fsname="+olddir)
 	opts.MountOptions.Name = "mutfs"
+	allMounts = mounts
 
 	log.SetFlags(log.Lmicroseconds)
-	server, err := fs.Mount(flag.Arg(1), mutnode, opts)
-	if err != nil {
-		log.Fatalf("Mount fail: %v\n", err)
+	// the control socket is there before the mounts, so failing to create it doesn't leave them behind
+	var control net.Listener
+	if Control != "" {
+		l, err := listenControl(Control)
+		if err != nil {
+			fatal(exitOption, "Control socket fail: %v", err)
+		}
+		control = l
+	}
+	var servers []*fuse.Server
+	unmount := func() {
+		for _, s := range servers {
+			s.Unmount()
+		}
+		for _, m := range mounts {
+			m.removeScratch()
+		}
+		if control != nil {
+			control.Close() // this removes the socket
+		}
+	}
+	defer func() {
+		for _, m := range mounts {
+			m.removeScratch()
+		}
+		if control != nil {
+			control.Close()
+		}
+	}()
+	for _, m := range mounts {
+		root, err := m.setup()
+		if err != nil {
+			unmount()
+			fatal(exitDir, "%s", err)
+		}
+		mopts := *opts
+		mopts.MountOptions.Options = append(append([]string{}, opts.MountOptions.Options...), "fsname="+m.olddir)
+		if m.ro {
+			mopts.MountOptions.Options = append(mopts.MountOptions.Options, "ro")
+		}
+		server, err := fs.Mount(m.newdir, root, &mopts)
+		if err != nil {
+			unmount()
+			fatal(exitMount, "Mount fail: %v", err)
+		}
+		servers = append(servers, server)
+	}
+	if *flagUser != "" || *flagGroup != "" || *flagChroot != "" {
+		if err := dropPrivileges(mounts, *flagUser, *flagGroup, *flagChroot); err != nil {
+			unmount()
+			fatal(exitFailure, "Failed to drop privileges: %s", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, m := range mounts {
+		if m.mirrorDir != "" {
+			m.startMirror()
+		}
+		if m.snapshotInterval > 0 {
+			m.startSnapshots(ctx)
+		}
+		if Preload {
+			go preload(ctx, m.root.Path)
+		}
+	}
+
+	if *flagReadyFD >= 0 {
+		if err := signalReady(*flagReadyFD); err != nil {
+			log.Printf("Failed to signal readiness: %s", err)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for range sig {
+			failed := false
+			for _, s := range servers {
+				if err := s.Unmount(); err != nil {
+					log.Printf("Unmount fail: %v", err)
+					failed = true
+				}
+			}
+			if !failed {
+				return
+			}
+		}
+	}()
+
+	for _, s := range servers {
+		s.Wait()
 	}
-	server.Wait()
+	cancel()
+	writeSummary(Summary)
 }
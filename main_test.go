@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+func TestCheckDirs(t *testing.T) {
+	defer func(l bool) { LazySource = l }(LazySource)
+	dir := t.TempDir()
+	olddir, newdir, file := filepath.Join(dir, "old"), filepath.Join(dir, "new"), filepath.Join(dir, "file")
+	for _, d := range []string{olddir, newdir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	for _, tc := range []struct {
+		olddir, newdir string
+		lazy           bool
+		ok             bool
+	}{
+		{olddir, newdir, false, true},
+		{olddir, olddir, false, false},
+		{olddir, olddir + "/.", false, false},
+		{file, newdir, false, false},
+		{olddir, file, false, false},
+		{missing, newdir, false, false},
+		{missing, newdir, true, true},
+		{olddir, missing, true, false},
+	} {
+		LazySource = tc.lazy
+		if err := checkDirs(tc.olddir, tc.newdir); (err == nil) != tc.ok {
+			t.Errorf("checkDirs(%q, %q) with lazy-source %t = %v, want ok %t", tc.olddir, tc.newdir, tc.lazy, err, tc.ok)
+		}
+	}
+}
+
+func TestParseOpt(t *testing.T) {
+	defer func(e map[string]syscall.Errno) { Errno = e }(Errno)
+	Errno = map[string]syscall.Errno{}
+
+	for _, tc := range []struct {
+		opt     string
+		ok      bool
+		unknown bool
+	}{
+		{"errno=EPERM", true, false},
+		{"errno=unlink:EROFS", true, false},
+		{"errno=ENOPE", false, false},
+		{"errno=", false, false},
+		{"max-read=4096", true, false},
+		{"max-read=131072", true, false},
+		{"max-read=4095", false, false},
+		{"max-read=", false, false},
+		{"max-files=0", false, false},
+		{"nosuid", false, true},
+		{"x-systemd.automount", false, true},
+		{"", false, true},
+	} {
+		err := parseOpt(&fs.Options{}, "olddir", tc.opt)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseOpt(%q) = %v, want ok %t", tc.opt, err, tc.ok)
+		}
+		if errors.Is(err, errUnknownOption) != tc.unknown {
+			t.Errorf("parseOpt(%q) = %v, want unknown %t", tc.opt, err, tc.unknown)
+		}
+	}
+}
+
+func TestParseOptMaxRead(t *testing.T) {
+	opts := &fs.Options{}
+	if err := parseOpt(opts, "olddir", "max-read=65536"); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.MountOptions.Options) != 1 || opts.MountOptions.Options[0] != "max_read=65536" {
+		t.Errorf("got mount options %v, want [max_read=65536]", opts.MountOptions.Options)
+	}
+	if opts.MountOptions.MaxReadAhead != 0 {
+		t.Errorf("max-read changed the read ahead to %d", opts.MountOptions.MaxReadAhead)
+	}
+	for _, o := range []string{"max-read=1024", "max-read=1048576", "max-read=x"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err == nil {
+			t.Errorf("parseOpt(%q): expected error", o)
+		}
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	for _, size := range []int{minIOSize, 32768, 131072} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			opts := &fs.Options{}
+			if err := parseOpt(opts, "olddir", "max-read="+strconv.Itoa(size)); err != nil {
+				b.Fatal(err)
+			}
+			data := make([]byte, 4<<20)
+			_, newdir := testMount(b, &mount{}, opts, func(olddir string) {
+				if err := os.WriteFile(filepath.Join(olddir, "file"), data, 0644); err != nil {
+					b.Fatal(err)
+				}
+			})
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := os.Open(filepath.Join(newdir, "file"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, f); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+func TestGraceMaxSize(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(s int64) { GraceMaxSize = s }(GraceMaxSize)
+	Grace = time.Hour
+	GraceMaxSize = 100
+	n := testRoot(t)
+	if err := os.WriteFile(n.path("large"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	if errno := n.deny(ctx, "unlink", "file"); errno != fs.OK {
+		t.Errorf("small file in the grace period: got %s, want OK", errnoString(errno))
+	}
+	if errno := n.deny(ctx, "unlink", "large"); errno != syscall.EACCES {
+		t.Errorf("large file in the grace period: got %s, want EACCES", errnoString(errno))
+	}
+}
+
+func TestGraceOp(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(g map[string]time.Duration) { GraceOp = g }(GraceOp)
+	defer func(n func() time.Time) { now = n }(now)
+	GraceOp = map[string]time.Duration{}
+	for _, o := range []string{"grace=1h", "grace-unlink=10m", "grace-write=2h"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := testRoot(t)
+	bt, err := btime(n.path("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, tc := range []struct {
+		after time.Duration
+		op    string
+		want  syscall.Errno
+	}{
+		{5 * time.Minute, "unlink", fs.OK},
+		{30 * time.Minute, "unlink", syscall.EACCES},
+		{30 * time.Minute, "open", fs.OK},
+		{30 * time.Minute, "rename", fs.OK},
+		{90 * time.Minute, "open", fs.OK},
+		{90 * time.Minute, "rename", syscall.EACCES},
+	} {
+		now = func() time.Time { return bt.Add(tc.after) }
+		if got := n.denyFlags(ctx, tc.op, "file", syscall.O_WRONLY); got != tc.want {
+			t.Errorf("%s after %s = %s, want %s", tc.op, tc.after, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestGraceExt(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(g map[string]time.Duration) { GraceExt = g }(GraceExt)
+	defer func(n func() time.Time) { now = n }(now)
+	GraceExt = map[string]time.Duration{}
+	for _, o := range []string{"grace=1m", "grace-ext=.log:10m", "grace-ext=.conf:30s"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, o := range []string{"grace-ext=log:10m", "grace-ext=.log", "grace-ext=.log:soon"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err == nil {
+			t.Errorf("parseOpt(%q): expected error", o)
+		}
+	}
+	n := testRoot(t)
+	t0 := time.Now()
+	fakeBtimes(t, n, t0, map[string]time.Duration{"app.log": 0, "app.conf": 0, "app": 0})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, tc := range []struct {
+		after time.Duration
+		name  string
+		want  syscall.Errno
+	}{
+		{20 * time.Second, "app.conf", fs.OK},
+		{45 * time.Second, "app.conf", syscall.EACCES},
+		{45 * time.Second, "app", fs.OK},
+		{45 * time.Second, "app.log", fs.OK},
+		{5 * time.Minute, "app", syscall.EACCES},
+		{5 * time.Minute, "app.log", fs.OK},
+		{11 * time.Minute, "app.log", syscall.EACCES},
+	} {
+		now = func() time.Time { return t0.Add(tc.after) }
+		if got := n.deny(ctx, "unlink", tc.name); got != tc.want {
+			t.Errorf("unlink of %s after %s = %s, want %s", tc.name, tc.after, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestGraceUntil(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(g time.Time) { GraceUntil = g }(GraceUntil)
+	defer func(n func() time.Time) { now = n }(now)
+	Grace = time.Minute
+	deadline := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	if err := parseOpt(&fs.Options{}, "olddir", "grace-until="+deadline.Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	n := testRoot(t)
+	t0 := deadline.Add(-2 * time.Hour)
+	fakeBtimes(t, n, t0, map[string]time.Duration{"old": -24 * time.Hour, "new": 119*time.Minute + 30*time.Second})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// before the deadline all files are mutable, after it only the ones in their own grace period
+	for _, tc := range []struct {
+		after time.Duration
+		name  string
+		want  syscall.Errno
+	}{
+		{0, "old", fs.OK},
+		{119 * time.Minute, "old", fs.OK},
+		{120 * time.Minute, "old", syscall.EACCES},
+		{120 * time.Minute, "new", fs.OK},
+		{121 * time.Minute, "new", syscall.EACCES},
+	} {
+		now = func() time.Time { return t0.Add(tc.after) }
+		if got := n.deny(ctx, "unlink", tc.name); got != tc.want {
+			t.Errorf("unlink of %s at %s = %s, want %s", tc.name, now().Format(time.RFC3339), errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestAllowExclCreate(t *testing.T) {
+	defer func(a bool) { AllowExclCreate = a }(AllowExclCreate)
+	AllowExclCreate = true
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name  string
+		flags int
+		want  error
+	}{
+		{"new", os.O_CREATE | os.O_EXCL | os.O_WRONLY, nil},
+		{"file", os.O_CREATE | os.O_EXCL | os.O_WRONLY, syscall.EEXIST},
+		{"other", os.O_CREATE | os.O_WRONLY, syscall.EACCES},
+	} {
+		f, err := os.OpenFile(filepath.Join(newdir, tc.name), tc.flags, 0644)
+		if err == nil {
+			f.Close()
+		}
+		if (tc.want == nil && err != nil) || (tc.want != nil && !errors.Is(err, tc.want)) {
+			t.Errorf("create %q with flags %#o: got %v, want %v", tc.name, tc.flags, err, tc.want)
+		}
+	}
+}
+
+func TestUmask(t *testing.T) {
+	defer func(u uint32) { Umask = u }(Umask)
+	defer syscall.Umask(syscall.Umask(0))
+	if err := parseOpt(&fs.Options{}, "olddir", "umask=027"); err != nil {
+		t.Fatal(err)
+	}
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, nil)
+
+	if err := os.WriteFile(filepath.Join(newdir, "file"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(newdir, "dir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"file": 0640, "dir": 0750} {
+		fi, err := os.Stat(filepath.Join(olddir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s created with mode %#o, want %#o", name, fi.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestCheckDirsSame(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	for _, newdir := range []string{dir, dir + "/", link} {
+		err := checkDirs(dir, newdir)
+		if err == nil || !strings.Contains(err.Error(), "same directory") {
+			t.Errorf("checkDirs(%q, %q) = %v, want them to be the same directory", dir, newdir, err)
+		}
+	}
+}
+
+func TestOptionList(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(r, s bool) { RequireTTY, StrictAppend = r, s }(RequireTTY, StrictAppend)
+	config := filepath.Join(t.TempDir(), "mutfs.conf")
+	if err := os.WriteFile(config, []byte("# config\ngrace=1m,require-tty\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MUTFS_OPTS", "grace=2m,strict-append")
+
+	optList, err := optionList(config, []string{"grace=3m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grace=1m", "require-tty", "grace=2m", "strict-append", "grace=3m"}
+	if strings.Join(optList, ",") != strings.Join(want, ",") {
+		t.Errorf("got options %v, want %v", optList, want)
+	}
+
+	for _, tc := range []struct {
+		flags []string
+		want  time.Duration
+	}{
+		{nil, 2 * time.Minute},
+		{[]string{"grace=3m"}, 3 * time.Minute},
+	} {
+		optList, err := optionList("", tc.flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range optList {
+			if err := parseOpt(&fs.Options{}, "olddir", o); err != nil && !errors.Is(err, errUnknownOption) {
+				t.Fatal(err)
+			}
+		}
+		if Grace != tc.want {
+			t.Errorf("with flags %v got grace %s, want %s", tc.flags, Grace, tc.want)
+		}
+	}
+
+	if _, err := optionList(filepath.Join(t.TempDir(), "missing"), nil); err == nil {
+		t.Errorf("expected an error for a missing config file")
+	}
+}
+
+func TestSourceFD(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Open(src, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	// the descriptor keeps referring to the directory after its path changed
+	moved := filepath.Join(dir, "moved")
+	if err := os.Rename(src, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	olddir, err := sourceFD(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+	m := &mount{olddir: olddir, newdir: filepath.Join(dir, "new")}
+	if err := os.Mkdir(m.newdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	root, err := m.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fs.Mount(m.newdir, root, &fs.Options{MountOptions: fuse.MountOptions{Name: "mutfs", FsName: olddir, DirectMount: true}})
+	if err != nil {
+		t.Skipf("can't mount: %s", err)
+	}
+	defer server.Unmount()
+	if err := server.WaitMount(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(m.newdir, "file")); err != nil || string(buf) != "contents" {
+		t.Errorf("got %q, %v, want the file in %q", buf, err, moved)
+	}
+
+	file, err := syscall.Open(filepath.Join(moved, "file"), syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(file)
+	if _, err := sourceFD(file); err == nil {
+		t.Errorf("expected an error for a file")
+	}
+	if _, err := sourceFD(1 << 20); err == nil {
+		t.Errorf("expected an error for a closed descriptor")
+	}
+}
+
+func TestLockExtension(t *testing.T) {
+	defer func(l bool) { LockExtension = l }(LockExtension)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	LockExtension = true
+	Grace = time.Hour
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "important.conf"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if err := os.Rename(filepath.Join(newdir, "important.conf"), filepath.Join(newdir, "important.bak")); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("rename changing the extension: got %v, want EACCES", err)
+	}
+	if err := os.Rename(filepath.Join(newdir, "important.conf"), filepath.Join(newdir, "other.conf")); err != nil {
+		t.Errorf("rename keeping the extension: %s", err)
+	}
+	if !exists(filepath.Join(newdir, "other.conf")) || exists(filepath.Join(newdir, "important.bak")) {
+		t.Errorf("got the wrong files after the renames")
+	}
+}
+
+func TestOpenFlags(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.Mkdir(filepath.Join(olddir, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("file", filepath.Join(olddir, "link")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, tc := range []struct {
+		name  string
+		flags int
+		want  error
+	}{
+		{"dir", syscall.O_RDONLY | syscall.O_DIRECTORY, nil},
+		{"file", syscall.O_RDONLY | syscall.O_DIRECTORY, syscall.ENOTDIR},
+		{"file", syscall.O_RDONLY | syscall.O_NOFOLLOW, nil},
+		{"file", syscall.O_WRONLY | syscall.O_NOFOLLOW, syscall.EACCES},
+		{"file", syscall.O_RDWR | syscall.O_NOFOLLOW, syscall.EACCES},
+		{"link", syscall.O_RDONLY | syscall.O_NOFOLLOW, syscall.ELOOP},
+		{"link", syscall.O_RDONLY, nil},
+	} {
+		fd, err := syscall.Open(filepath.Join(newdir, tc.name), tc.flags, 0)
+		if err == nil {
+			syscall.Close(fd)
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("open of %s with flags %#o: got %v, want %v", tc.name, tc.flags, err, tc.want)
+		}
+	}
+}
+
+func TestSignalReady(t *testing.T) {
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var p [2]int
+	if err := syscall.Pipe(p[:]); err != nil {
+		t.Fatal(err)
+	}
+	r := os.NewFile(uintptr(p[0]), "r")
+	defer r.Close()
+
+	if err := signalReady(p[1]); err != nil {
+		t.Fatal(err)
+	}
+	// the write end is closed, so the byte is followed by EOF
+	buf, err := io.ReadAll(r)
+	if err != nil || string(buf) != "\n" {
+		t.Errorf("read %q, %v, want a newline", buf, err)
+	}
+	if _, err := os.Stat(filepath.Join(newdir, "file")); err != nil {
+		t.Errorf("mount isn't usable once ready: %s", err)
+	}
+}
+
+func TestLint(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(l, s bool) { Log, StrictConfig = l, s }(Log, StrictConfig)
+	defer log.SetOutput(log.Writer())
+	buf := &strings.Builder{}
+	log.SetOutput(buf)
+	Grace, Log, StrictConfig = 0, false, false
+	mounts := []*mount{{olddir: "olddir", newdir: "newdir"}}
+
+	if err := parseOpt(&fs.Options{}, "olddir", "grace=5m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lint(mounts); err != nil {
+		t.Errorf("lint = %s, want only a warning", err)
+	}
+	if !strings.Contains(buf.String(), "Warning: grace period without log") {
+		t.Errorf("got log %q, want a warning about the grace period without log", buf)
+	}
+	if err := parseOpt(&fs.Options{}, "olddir", "strict-config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := lint(mounts); err == nil || !strings.Contains(err.Error(), "grace period without log") {
+		t.Errorf("lint with strict-config = %v, want an error about the grace period without log", err)
+	}
+
+	if err := parseOpt(&fs.Options{}, "olddir", "log"); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := lint(mounts); err != nil {
+		t.Errorf("lint with log = %s, want no error", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("got log %q, want no warnings", buf)
+	}
+}
+
+func TestGraceUntilRecorded(t *testing.T) {
+	defer func(c string) { Control = c }(Control)
+	defer func(g time.Time) { GraceUntil = g }(GraceUntil)
+	defer func(s *stats) { Stats = s }(Stats)
+	active.Lock()
+	active.m = map[string]time.Time{}
+	active.Unlock()
+	Control, Stats = "control", newStats()
+	GraceUntil = time.Now().Add(time.Hour).Truncate(time.Second)
+	n := testRoot(t)
+
+	if errno := n.deny(callerContext(context.Background(), 42, 1000, 1000), "unlink", "file"); errno != fs.OK {
+		t.Fatalf("unlink before grace-until = %s, want OK", errnoString(errno))
+	}
+	if path, end := soonest(); path != n.path("file") || !end.Equal(GraceUntil) {
+		t.Errorf("status got %q ending at %s, want %q ending at %s", path, end, n.path("file"), GraceUntil)
+	}
+	Stats.Lock()
+	used := Stats.remaining["75-100%"]
+	Stats.Unlock()
+	if used != 1 {
+		t.Errorf("got %d grace periods used with 75-100%% remaining, want 1", used)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Manifest holds the paths, relative to the root, that may exist, including their parent directories. When it's
+// nil there is no manifest. With ManifestHide entries not in the manifest are hidden.
+var (
+	Manifest     map[string]bool
+	ManifestHide bool
+
+	manifestPath string
+)
+
+// readManifest reads the manifest in path: one path relative to olddir per line, empty lines and lines starting
+// with # are skipped.
+func readManifest(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for p := filepath.Clean(strings.TrimPrefix(line, "/")); p != "." && p != "/"; p = filepath.Dir(p) {
+			m[p] = true
+		}
+	}
+	return m, scanner.Err()
+}
+
+// inManifest returns true if rel, which is relative to the root, is in the manifest, or if there is no manifest.
+func inManifest(rel string) bool {
+	if Manifest == nil {
+		return true
+	}
+	rel = filepath.Clean(rel)
+	return rel == "." || Manifest[rel]
+}
+
+// unlisted returns the errno for op when it creates rel, which isn't in the manifest, otherwise 0.
+func unlisted(op, actualPath, rel string, caller *fuse.Caller) syscall.Errno {
+	if inManifest(rel) {
+		return 0
+	}
+	return denied(op, actualPath, caller, "not in the manifest")
+}
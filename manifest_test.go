@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestManifest(t *testing.T) {
+	defer func(m map[string]bool, p string, h bool) { Manifest, manifestPath, ManifestHide = m, p, h }(Manifest, manifestPath, ManifestHide)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = time.Hour
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	if err := os.WriteFile(manifest, []byte("# the expected layout\nfile\n/dir/allowed\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []string{"manifest=" + manifest, "manifest-hide"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"file", "stray"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if err := os.Mkdir(filepath.Join(newdir, "dir"), 0755); err != nil {
+		t.Errorf("mkdir of dir: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(newdir, "dir", "allowed"), nil, 0644); err != nil {
+		t.Errorf("create of dir/allowed: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(newdir, "dir", "other"), nil, 0644); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("create of dir/other: got %v, want EACCES", err)
+	}
+	if err := os.Mkdir(filepath.Join(newdir, "other"), 0755); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("mkdir of other: got %v, want EACCES", err)
+	}
+	if err := os.Rename(filepath.Join(newdir, "file"), filepath.Join(newdir, "other")); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("rename to other: got %v, want EACCES", err)
+	}
+
+	// manifest-hide hides what isn't listed
+	if _, err := os.Stat(filepath.Join(newdir, "stray")); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("stat of stray: got %v, want ENOENT", err)
+	}
+	entries, err := os.ReadDir(newdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "dir" || names[1] != "file" {
+		t.Errorf("got entries %v, want [dir file]", names)
+	}
+}
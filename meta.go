@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// metaName is the name of the directory in the root that holds the files mutfs itself provides, like the change
+// log. It doesn't exist in the source: it is synthesized when one of those files is enabled, and an entry with this
+// name in the source is hidden.
+const metaName = ".mutfs"
+
+// metaNode is the read-only .mutfs directory.
+type metaNode struct {
+	fs.Inode
+	mount *mount
+}
+
+var (
+	_ = (fs.NodeLookuper)((*metaNode)(nil))
+	_ = (fs.NodeReaddirer)((*metaNode)(nil))
+	_ = (fs.NodeGetattrer)((*metaNode)(nil))
+)
+
+// hasMeta returns true if m has a .mutfs directory.
+func (m *mount) hasMeta() bool { return m.changelog || m.trash != "" }
+
+// entries returns the entries of the .mutfs directory.
+func (d *metaNode) entries() []fuse.DirEntry {
+	entries := []fuse.DirEntry{}
+	if d.mount.changelog {
+		entries = append(entries, fuse.DirEntry{Name: changelogName, Mode: syscall.S_IFREG})
+	}
+	if d.mount.trash != "" {
+		entries = append(entries, fuse.DirEntry{Name: trashName, Mode: syscall.S_IFDIR})
+	}
+	return entries
+}
+
+func (d *metaNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	out.SetEntryTimeout(0)
+	out.SetAttrTimeout(0)
+	switch {
+	case name == changelogName && d.mount.changelog:
+		d.mount.changes.attr(&out.Attr)
+		return d.NewInode(ctx, &changelogNode{changes: d.mount.changes}, fs.StableAttr{Mode: syscall.S_IFREG}), fs.OK
+	case name == trashName && d.mount.trash != "":
+		return d.lookupTrash(ctx, out)
+	}
+	return nil, syscall.ENOENT
+}
+
+func (d *metaNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewListDirStream(d.entries()), fs.OK
+}
+
+func (d *metaNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	metaAttr(&out.Attr)
+	out.SetTimeout(0)
+	return fs.OK
+}
+
+// metaAttr fills out with the attributes of the .mutfs directory.
+func metaAttr(out *fuse.Attr) {
+	out.Mode = syscall.S_IFDIR | 0555
+	out.Nlink = 2
+}
+
+// lookupMeta returns the inode of the .mutfs directory.
+func (n *MutNode) lookupMeta(ctx context.Context, out *fuse.EntryOut) *fs.Inode {
+	metaAttr(&out.Attr)
+	out.SetEntryTimeout(0)
+	out.SetAttrTimeout(0)
+	return n.NewInode(ctx, &metaNode{mount: n.mount}, fs.StableAttr{Mode: syscall.S_IFDIR})
+}
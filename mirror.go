@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// mirrorOp is a single mutation that is to be applied to the mirror. Paths are relative to the root.
+type mirrorOp struct {
+	op     string // copy, mkdir, unlink, rmdir or rename
+	rel    string
+	newRel string // only for rename
+}
+
+// mirrorRetries is the number of times a failed mutation is retried.
+const mirrorRetries = 4
+
+// mirrorBreaker stops mirroring for a while after too many failed mutations.
+var mirrorBreaker = &breaker{name: "mirror", threshold: 5, cooldown: time.Minute}
+
+// mirrorApply applies a mutation to the mirror, it can be replaced to test a failing mirror.
+var mirrorApply = mirrorOp.apply
+
+// mirrorQueue is the number of mutations that can wait to be applied to the mirror.
+const mirrorQueue = 1024
+
+// prepareMirror checks that the mirror directory of m is empty, as syncing removes everything in it that isn't in the
+// source, and creates the queue of mutations. This is done before mounting, mutations are queued from then on.
+func (m *mount) prepareMirror() error {
+	entries, err := os.ReadDir(m.mirrorDir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%q isn't empty", m.mirrorDir)
+	}
+	m.mirrorq = make(chan mirrorOp, mirrorQueue)
+	m.mirrorDirty = 1
+	return nil
+}
+
+// startMirror starts the goroutine that applies the mutations in m to its mirror directory, after prepareMirror. The
+// mirror is synced with the source first. A mutation that can't be applied, because it fails or because the circuit
+// breaker is open, marks the mirror dirty, it is then synced again once the breaker allows it.
+func (m *mount) startMirror() {
+	breaker, apply := mirrorBreaker, mirrorApply
+	go func() {
+		for attempt := 0; ; {
+			if atomic.LoadInt32(&m.mirrorDirty) == 1 {
+				if breaker.allow() {
+					atomic.StoreInt32(&m.mirrorDirty, 0)
+					err := m.syncMirror(apply)
+					breaker.done(err)
+					if err == nil {
+						attempt = 0
+						continue
+					}
+					atomic.StoreInt32(&m.mirrorDirty, 1)
+					log.Printf("Failed to sync mirror %q: %s", m.mirrorDir, err)
+				}
+				// the mutations that come in meanwhile are done by the next sync
+				select {
+				case _, ok := <-m.mirrorq:
+					if !ok {
+						return
+					}
+				case <-time.After(backoff(attempt, time.Second, breaker.cooldown)):
+					attempt++
+				}
+				continue
+			}
+
+			op, ok := <-m.mirrorq
+			if !ok {
+				return
+			}
+			if !breaker.allow() {
+				atomic.StoreInt32(&m.mirrorDirty, 1)
+				continue
+			}
+			err := apply(op, m.root.Path, m.mirrorDir)
+			for i := 0; err != nil && i < mirrorRetries; i++ {
+				time.Sleep(backoff(i, 100*time.Millisecond, 10*time.Second))
+				err = apply(op, m.root.Path, m.mirrorDir)
+			}
+			breaker.done(err)
+			if err != nil {
+				log.Printf("Failed to mirror %s of %q, syncing the mirror later: %s", op.op, op.rel, err)
+				atomic.StoreInt32(&m.mirrorDirty, 1)
+			}
+		}
+	}()
+}
+
+// mirror queues op to be applied to the mirror of m. If the queue is full the mirror is marked dirty, so it is
+// synced later.
+func (m *mount) mirror(op mirrorOp) {
+	if m.mirrorq == nil {
+		return
+	}
+	select {
+	case m.mirrorq <- op:
+	default:
+		log.Printf("Failed to mirror %s of %q, syncing the mirror later: queue full", op.op, op.rel)
+		atomic.StoreInt32(&m.mirrorDirty, 1)
+	}
+}
+
+// syncMirror makes the mirror directory of m the same as the source, files are copied with apply: directories are created, files that are
+// missing or differ in size or are older than in the source are copied, and entries that aren't in the source are
+// removed. Other types of entries aren't mirrored.
+func (m *mount) syncMirror(apply func(mirrorOp, string, string) error) error {
+	root := m.root.Path
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		dst := filepath.Join(m.mirrorDir, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, 0755)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if mi, err := os.Lstat(dst); err == nil && mi.Mode().IsRegular() && mi.Size() == fi.Size() && !mi.ModTime().Before(fi.ModTime()) {
+			return nil
+		}
+		return apply(mirrorOp{op: "copy", rel: rel}, root, m.mirrorDir)
+	})
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(m.mirrorDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(m.mirrorDir, path)
+		if _, err := os.Lstat(filepath.Join(root, rel)); !os.IsNotExist(err) {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// apply applies m to the mirror directory dir, root is the source directory.
+func (m mirrorOp) apply(root, dir string) error {
+	dst := filepath.Join(dir, m.rel)
+	switch m.op {
+	case "copy":
+		return copyFile(filepath.Join(root, m.rel), dst)
+	case "mkdir":
+		return os.MkdirAll(dst, 0755)
+	case "unlink", "rmdir":
+		err := os.Remove(dst)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	case "rename":
+		newDst := filepath.Join(dir, m.newRel)
+		if err := os.MkdirAll(filepath.Dir(newDst), 0755); err != nil {
+			return err
+		}
+		return os.Rename(dst, newDst)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// testMirror returns a mount with a source and a mirror directory, the source has the file old.
+func testMirror(t *testing.T) *mount {
+	t.Helper()
+	m := &mount{root: &fs.LoopbackRoot{Path: t.TempDir()}, mirrorDir: t.TempDir()}
+	if err := os.WriteFile(filepath.Join(m.root.Path, "old"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.prepareMirror(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// waitFor waits until f returns true.
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for i := 0; !f(); i++ {
+		if i == 500 {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestMirrorSync(t *testing.T) {
+	defer func(b *breaker) { mirrorBreaker = b }(mirrorBreaker)
+	mirrorBreaker = &breaker{name: "mirror", threshold: 5, cooldown: time.Second}
+	m := testMirror(t)
+
+	m.startMirror()
+	defer close(m.mirrorq)
+	waitFor(t, "the startup sync", func() bool { return exists(filepath.Join(m.mirrorDir, "old")) })
+
+	// a later sync removes what isn't in the source
+	if err := os.WriteFile(filepath.Join(m.mirrorDir, "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&m.mirrorDirty, 1)
+	m.mirror(mirrorOp{op: "copy", rel: "old"})
+	waitFor(t, "the sync", func() bool { return !exists(filepath.Join(m.mirrorDir, "stale")) })
+}
+
+func TestMirrorFlaky(t *testing.T) {
+	defer func(b *breaker) { mirrorBreaker = b }(mirrorBreaker)
+	defer func(f func(mirrorOp, string, string) error) { mirrorApply = f }(mirrorApply)
+	mirrorBreaker = &breaker{name: "mirror", threshold: 1, cooldown: 200 * time.Millisecond}
+	m := testMirror(t)
+
+	// the mirror fails until it is up again, which opens the breaker
+	var up int32
+	mirrorApply = func(op mirrorOp, root, dir string) error {
+		if atomic.LoadInt32(&up) == 0 {
+			return errors.New("mirror down")
+		}
+		return op.apply(root, dir)
+	}
+	m.startMirror()
+	defer close(m.mirrorq)
+	waitFor(t, "the breaker to open", func() bool { return !mirrorBreaker.allow() })
+
+	// mutations while the breaker is open aren't lost
+	if err := os.WriteFile(filepath.Join(m.root.Path, "new"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.mirror(mirrorOp{op: "copy", rel: "new"})
+	atomic.StoreInt32(&up, 1)
+	waitFor(t, "the sync after the breaker closed", func() bool {
+		return exists(filepath.Join(m.mirrorDir, "old")) && exists(filepath.Join(m.mirrorDir, "new"))
+	})
+}
+
+func TestMirrorOverlap(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		m  mount
+		ok bool
+	}{
+		{mount{olddir: filepath.Join(dir, "src"), newdir: filepath.Join(dir, "mnt"), mirrorDir: filepath.Join(dir, "mirror")}, true},
+		{mount{olddir: filepath.Join(dir, "src"), mirrorDir: filepath.Join(dir, "src", "mirror")}, false},
+		{mount{olddir: filepath.Join(dir, "src"), mirrorDir: dir}, false},
+		{mount{olddir: filepath.Join(dir, "src"), mirrorDir: filepath.Join(dir, "src")}, false},
+		{mount{newdir: filepath.Join(dir, "mnt"), mirrorDir: filepath.Join(dir, "mnt", "mirror")}, false},
+		{mount{upper: filepath.Join(dir, "upper"), mirrorDir: dir}, false},
+	} {
+		if err := tc.m.check(); (err == nil) != tc.ok {
+			t.Errorf("mirror %q: got %v, want ok %t", tc.m.mirrorDir, err, tc.ok)
+		}
+	}
+
+	// or the directories of another mount
+	a := &mount{olddir: filepath.Join(dir, "a"), mirrorDir: filepath.Join(dir, "mirror")}
+	b := &mount{olddir: filepath.Join(dir, "mirror", "b")}
+	if err := checkMounts([]*mount{a, b}); err == nil {
+		t.Errorf("expected an error for a mirror containing the olddir of another mount")
+	}
+}
+
+func TestPrepareMirror(t *testing.T) {
+	m := &mount{mirrorDir: t.TempDir()}
+	if err := m.prepareMirror(); err != nil {
+		t.Errorf("empty mirror: %s", err)
+	}
+	if m.mirrorq == nil {
+		t.Errorf("no queue for the mutations made once mounted")
+	}
+	if err := os.WriteFile(filepath.Join(m.mirrorDir, "precious"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.prepareMirror(); err == nil {
+		t.Errorf("expected an error for a mirror that isn't empty")
+	}
+}
+
+func TestMirrorMount(t *testing.T) {
+	defer func(b *breaker) { mirrorBreaker = b }(mirrorBreaker)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	mirrorBreaker = &breaker{name: "mirror", threshold: 5, cooldown: time.Second}
+	Grace = time.Hour
+	m := &mount{mirrorDir: t.TempDir()}
+	_, newdir := testMount(t, m, &fs.Options{}, nil)
+	m.startMirror()
+	defer close(m.mirrorq)
+
+	file := filepath.Join(newdir, "file")
+	if err := os.WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the create to be mirrored", func() bool {
+		buf, err := os.ReadFile(filepath.Join(m.mirrorDir, "file"))
+		return err == nil && string(buf) == "new"
+	})
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the unlink to be mirrored", func() bool { return !exists(filepath.Join(m.mirrorDir, "file")) })
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// mount is an olddir mounted on a newdir. Every node holds the mount it belongs to, so the options that act on the
+// source directory, and the state that goes with them, can differ per mount. The other options are shared by all
+// mounts.
+type mount struct {
+	olddir, newdir string
+
+	ro               bool
+	upper            string
+	mirrorDir        string
+	snapshot         bool
+	snapshotInterval time.Duration
+	snapshotDir      string
+	maxFiles         int64
+	singleFS         bool
+	changelog        bool
+	scratch          string
+	trash            string
+
+	root           *fs.LoopbackRoot
+	files          int64  // number of files under the root, when maxFiles is set
+	rootDev        uint64 // device of the root, when singleFS is set
+	sourceSeen     int32  // set once the source has been seen, for LazySource
+	snapshotAt     time.Time
+	snapshotInodes map[fileID]bool // inodes under the root at mount time, for snapshot without creation times
+	mirrorq        chan mirrorOp
+	mirrorDirty    int32 // set when the mirror needs to be synced with the source
+	scratchRoot    *fs.LoopbackRoot
+	changes        *changeLog
+}
+
+// allMounts holds all mounts, it is set before mounting.
+var allMounts []*mount
+
+// anyMount returns true if f returns true for one of the mounts.
+func anyMount(f func(m *mount) bool) bool {
+	for _, m := range allMounts {
+		if f(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultMount holds the per-mount options given with -o, each mount starts out with a copy of them.
+var defaultMount = &mount{}
+
+// parseOpt parses the per-mount option o and applies it to m. Other options return errUnknownOption.
+func (m *mount) parseOpt(o string) error {
+	switch {
+	case o == "ro":
+		m.ro = true
+	case o == "snapshot":
+		m.snapshot = true
+	case o == "changelog":
+		m.changelog = true
+	case o == "single-fs":
+		m.singleFS = true
+	case strings.HasPrefix(o, "scratch="):
+		m.scratch = strings.TrimPrefix(o, "scratch=")
+		if m.scratch == "" || m.scratch == "." || m.scratch == ".." || strings.Contains(m.scratch, "/") {
+			return fmt.Errorf("Wrongly specified scratch, must be a name: %s", o)
+		}
+	case strings.HasPrefix(o, "snapshot-interval="):
+		d, err := time.ParseDuration(strings.TrimPrefix(o, "snapshot-interval="))
+		if err != nil || d <= 0 {
+			return fmt.Errorf("Wrongly specified snapshot-interval: %s", o)
+		}
+		m.snapshotInterval = d
+	case strings.HasPrefix(o, "trash="):
+		m.trash = strings.TrimPrefix(o, "trash=")
+		if fi, err := os.Stat(m.trash); err != nil || !fi.IsDir() {
+			return fmt.Errorf("Wrongly specified trash, %q isn't a directory: %s", m.trash, o)
+		}
+	case strings.HasPrefix(o, "snapshot-dir="):
+		m.snapshotDir = strings.TrimPrefix(o, "snapshot-dir=")
+	case strings.HasPrefix(o, "upper="):
+		m.upper = strings.TrimPrefix(o, "upper=")
+		if fi, err := os.Stat(m.upper); err != nil || !fi.IsDir() {
+			return fmt.Errorf("Wrongly specified upper, %q isn't a directory: %s", m.upper, o)
+		}
+	case strings.HasPrefix(o, "mirror="):
+		m.mirrorDir = strings.TrimPrefix(o, "mirror=")
+		if fi, err := os.Stat(m.mirrorDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("Wrongly specified mirror, %q isn't a directory: %s", m.mirrorDir, o)
+		}
+	case strings.HasPrefix(o, "max-files="):
+		n, err := strconv.ParseInt(strings.TrimPrefix(o, "max-files="), 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("Wrongly specified max-files: %s", o)
+		}
+		m.maxFiles = n
+	default:
+		return fmt.Errorf("%w: %s", errUnknownOption, o)
+	}
+	return nil
+}
+
+// check checks the per-mount options that depend on each other.
+func (m *mount) check() error {
+	if LazySource && (m.maxFiles > 0 || m.singleFS || m.changelog) {
+		return fmt.Errorf("Option lazy-source can't be used with max-files, single-fs or changelog")
+	}
+	if (m.snapshotInterval > 0) != (m.snapshotDir != "") {
+		return fmt.Errorf("Options snapshot-interval and snapshot-dir need each other")
+	}
+	if err := m.mirrorOverlaps(m); err != nil {
+		return err
+	}
+	if RenameFrom != nil && m.upper != "" {
+		return fmt.Errorf("Option rename-map can't be used with upper")
+	}
+	return nil
+}
+
+// mirrorOverlaps returns an error when the mirror directory of m is in, or contains, the olddir, newdir or upper
+// directory of o. Syncing the mirror removes what isn't in the source, so it must be apart from these.
+func (m *mount) mirrorOverlaps(o *mount) error {
+	if m.mirrorDir == "" {
+		return nil
+	}
+	for name, dir := range map[string]string{"olddir": o.olddir, "newdir": o.newdir, "upper": o.upper} {
+		if dir != "" && (in(m.mirrorDir, dir) || in(dir, m.mirrorDir)) {
+			return fmt.Errorf("Option mirror %q can't overlap %s %q", m.mirrorDir, name, dir)
+		}
+	}
+	return nil
+}
+
+// in returns true if path is dir, or is in dir.
+func in(path, dir string) bool {
+	path, _ = filepath.Abs(path)
+	dir, _ = filepath.Abs(dir)
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// newMount returns the mount of olddir on newdir, with the per-mount options given with -o and then opts.
+func newMount(olddir, newdir string, opts []string) (*mount, error) {
+	m := &mount{}
+	*m = *defaultMount
+	m.olddir, m.newdir = olddir, newdir
+	for _, o := range opts {
+		if err := m.parseOpt(o); err != nil {
+			return nil, fmt.Errorf("Option %s can't be set per mount: %w", o, err)
+		}
+	}
+	return m, m.check()
+}
+
+// newNode is the NewNode function of the mount's loopback root.
+func (m *mount) newNode(rootData *fs.LoopbackRoot, _ *fs.Inode, _ string, _ *syscall.Stat_t) fs.InodeEmbedder {
+	return &MutNode{LoopbackNode: fs.LoopbackNode{RootData: rootData}, mount: m}
+}
+
+// setup prepares the source directory and the state of the options that act on it, before mounting. The returned
+// root node is what should be mounted.
+func (m *mount) setup() (fs.InodeEmbedder, error) {
+	m.root = &fs.LoopbackRoot{NewNode: m.newNode, Path: m.olddir}
+	if m.snapshot {
+		m.snapshotAt = time.Now()
+		if !hasBtime(m.olddir) {
+			if err := m.recordInodes(); err != nil {
+				return nil, fmt.Errorf("Can't record the inodes in %q: %s", m.olddir, err)
+			}
+		}
+	}
+	if m.mirrorDir != "" {
+		if err := m.prepareMirror(); err != nil {
+			return nil, fmt.Errorf("Can't use mirror: %s", err)
+		}
+	}
+	if m.scratch != "" {
+		if err := m.makeScratch(); err != nil {
+			return nil, fmt.Errorf("Can't create scratch directory: %s", err)
+		}
+	}
+	if LogBackingPath {
+		if err := setBackingRoot(m.olddir, m.olddir); err != nil {
+			return nil, fmt.Errorf("Can't resolve %q: %s", m.olddir, err)
+		}
+	}
+	if m.changelog {
+		m.changes = &changeLog{}
+	}
+	if m.trash != "" {
+		if err := m.checkTrash(); err != nil {
+			return nil, fmt.Errorf("Can't use trash: %s", err)
+		}
+	}
+	if m.singleFS {
+		if err := m.setRootDev(); err != nil {
+			return nil, fmt.Errorf("Can't stat %q: %s", m.olddir, err)
+		}
+	}
+	if m.maxFiles > 0 {
+		if err := m.countFiles(); err != nil {
+			return nil, fmt.Errorf("Can't count files in %q: %s", m.olddir, err)
+		}
+	}
+	return m.newNode(m.root, nil, "", nil), nil
+}
+
+// mountLine is a line from the mounts file.
+type mountLine struct {
+	olddir, newdir string
+	opts           []string
+}
+
+// readMounts reads the mounts in the file at path, each line holds an olddir, a newdir and optionally the options for
+// that mount, separated by white space. The options are separated by commas, as with -o. Empty lines and lines
+// starting with # are ignored.
+func readMounts(path string) ([]mountLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountLine
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: need olddir and newdir, and optionally the options", i)
+		}
+		ml := mountLine{olddir: fields[0], newdir: fields[1]}
+		if len(fields) == 3 {
+			for _, o := range strings.Split(fields[2], ",") {
+				if o != "" {
+					ml.opts = append(ml.opts, o)
+				}
+			}
+		}
+		mounts = append(mounts, ml)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no mounts")
+	}
+	return mounts, nil
+}
+
+// checkMounts returns an error when mounts share a directory that each of them must have for itself, or when a
+// mirror overlaps the directories of another mount.
+func checkMounts(mounts []*mount) error {
+	seen := map[string]string{}
+	for _, m := range mounts {
+		for _, o := range mounts {
+			if err := m.mirrorOverlaps(o); o != m && err != nil {
+				return err
+			}
+		}
+		for name, dir := range map[string]string{"upper": m.upper, "mirror": m.mirrorDir, "snapshot-dir": m.snapshotDir, "trash": m.trash} {
+			if dir == "" {
+				continue
+			}
+			abs, _ := filepath.Abs(dir)
+			if other, ok := seen[abs]; ok {
+				return fmt.Errorf("Option %s %q is already used as %s by another mount", name, dir, other)
+			}
+			seen[abs] = name
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestMounts(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(a []*mount) { allMounts = a }(allMounts)
+	Grace = time.Hour
+	path := filepath.Join(t.TempDir(), "mounts")
+	mounts := "# two mounts\n/a /mnt/a max-files=1\n\n/b /mnt/b\n"
+	if err := os.WriteFile(path, []byte(mounts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := readMounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mountLine{{olddir: "/a", newdir: "/mnt/a", opts: []string{"max-files=1"}}, {olddir: "/b", newdir: "/mnt/b"}}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("got mounts %+v, want %+v", lines, want)
+	}
+
+	// mount both, each with the options of its line
+	var newdirs []string
+	for _, l := range lines {
+		m, err := newMount(l.olddir, l.newdir, l.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allMounts = append(allMounts, m)
+		_, newdir := testMount(t, m, &fs.Options{}, func(olddir string) {
+			if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		})
+		newdirs = append(newdirs, newdir)
+	}
+
+	for _, newdir := range newdirs {
+		if _, err := os.Stat(filepath.Join(newdir, "file")); err != nil {
+			t.Errorf("mount %q isn't active: %s", newdir, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(newdirs[0], "new"), nil, 0644); !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("create in the mount with max-files=1: got %v, want EDQUOT", err)
+	}
+	if err := os.WriteFile(filepath.Join(newdirs[1], "new"), nil, 0644); err != nil {
+		t.Errorf("create in the mount without max-files: %s", err)
+	}
+}
+
+func TestReadMountsErrors(t *testing.T) {
+	for _, mounts := range []string{"", "# nothing\n", "/a\n", "/a /b c d\n"} {
+		path := filepath.Join(t.TempDir(), "mounts")
+		if err := os.WriteFile(path, []byte(mounts), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readMounts(path); err == nil {
+			t.Errorf("readMounts(%q): expected an error", mounts)
+		}
+	}
+}
+
+func TestCheckMounts(t *testing.T) {
+	upper := t.TempDir()
+	a, err := newMount("/a", "/mnt/a", []string{"upper=" + upper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newMount("/b", "/mnt/b", []string{"upper=" + upper})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkMounts([]*mount{a, b}); err == nil {
+		t.Errorf("expected an error for mounts sharing an upper directory")
+	}
+	if err := checkMounts([]*mount{a}); err != nil {
+		t.Errorf("got %s for a single mount", err)
+	}
+	if _, err := newMount("/a", "/mnt/a", []string{"grace=1m"}); err == nil {
+		t.Errorf("expected an error for an option that isn't per mount")
+	}
+}
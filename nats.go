@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Events is the URL of the NATS server, and subject, allowed and denied mutations are published to, e.g.
+// nats://localhost:4222/mutfs.events.
+var Events string
+
+// natsSubject is the subject used when the URL has no path.
+const natsSubject = "mutfs.events"
+
+var natsq chan event
+
+// natsBreaker stops connecting to the NATS server for a while after too many failures.
+var natsBreaker = &breaker{name: "nats", threshold: 5, cooldown: time.Minute}
+
+// parseNATS returns the address and subject from a nats:// URL.
+func parseNATS(s string) (addr, subject string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return "", "", fmt.Errorf("not a nats://host:port/subject URL: %q", s)
+	}
+	addr = u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject = strings.Trim(u.Path, "/")
+	if subject == "" {
+		subject = natsSubject
+	}
+	if strings.ContainsAny(subject, " \t\r\n/") {
+		return "", "", fmt.Errorf("bad subject %q", subject)
+	}
+	return addr, subject, nil
+}
+
+// natsConn is a connection to a NATS server, speaking just enough of the protocol to publish.
+type natsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dialNATS connects to the NATS server at addr: it reads the INFO line, sends CONNECT and waits for the PONG to
+// our PING, so errors (like needing authentication) are seen here.
+func dialNATS(addr string) (*natsConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConn{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("expected INFO, got %q", strings.TrimSpace(line))
+	}
+	if _, err := fmt.Fprint(conn, "CONNECT {\"verbose\":false,\"pedantic\":false,\"name\":\"mutfs\"}\r\nPING\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.pong(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	go c.read()
+	return c, nil
+}
+
+// pong waits for the PONG to our PING.
+func (c *natsConn) pong() error {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("server: %s", line)
+		}
+	}
+}
+
+// read answers the server's PINGs, and logs its errors, until the connection is closed.
+func (c *natsConn) read() {
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			fmt.Fprint(c.conn, "PONG\r\n")
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("NATS server error: %s", line)
+		}
+	}
+}
+
+func (c *natsConn) publish(subject string, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := fmt.Fprintf(c.conn, "PUB %s %d\r\n%s\r\n", subject, len(data), data)
+	return err
+}
+
+// startNATS starts the goroutine that publishes the queued events, (re)connecting as needed.
+func startNATS(addr, subject string) {
+	natsq = make(chan event, eventQueue)
+	go func() {
+		var c *natsConn
+		for e := range natsq {
+			data, _ := json.Marshal(e)
+			var err error
+			for i := 0; i < 3; i++ {
+				if c == nil {
+					if !natsBreaker.allow() {
+						err = fmt.Errorf("circuit breaker open")
+						break
+					}
+					c, err = dialNATS(addr)
+					natsBreaker.done(err)
+					if err != nil {
+						time.Sleep(backoff(i, 100*time.Millisecond, 2*time.Second))
+						continue
+					}
+				}
+				if err = c.publish(subject, data); err == nil {
+					break
+				}
+				c.conn.Close()
+				c = nil
+			}
+			if err != nil && Log {
+				log.Printf("Dropped NATS event for %q: %s", e.Path, err)
+			}
+		}
+	}()
+}
+
+// publishNATS queues e to be published, if the queue is full the event is dropped.
+func publishNATS(e event) {
+	if natsq == nil {
+		return
+	}
+	select {
+	case natsq <- e:
+	default:
+		if Log {
+			log.Printf("NATS queue full, dropped event for %q", e.Path)
+		}
+	}
+}
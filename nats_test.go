@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one connection on a local port and sends what is published on it to pub, as subject and data.
+func fakeNATS(t *testing.T, pub chan<- [2]string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "INFO {\"server_id\":\"fake\"}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 1 && fields[0] == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case len(fields) == 3 && fields[0] == "PUB":
+				var n int
+				fmt.Sscan(fields[2], &n)
+				data := make([]byte, n+2) // the data is followed by \r\n
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				pub <- [2]string{fields[1], string(data[:n])}
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestNATS(t *testing.T) {
+	defer func(e string) { Events = e }(Events)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	pub := make(chan [2]string, 10)
+	Events = "nats://" + fakeNATS(t, pub) + "/mutfs.test"
+	addr, subject, err := parseNATS(Events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startNATS(addr, subject)
+	defer func() { close(natsq); natsq = nil }()
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1001)
+
+	for _, tc := range []struct {
+		grace    time.Duration
+		response string
+	}{
+		{0, "FAN_DENY"},
+		{time.Hour, "FAN_ALLOW"},
+	} {
+		Grace = tc.grace
+		n.deny(ctx, "unlink", "file")
+		select {
+		case p := <-pub:
+			if p[0] != "mutfs.test" {
+				t.Errorf("published on %q, want %q", p[0], "mutfs.test")
+			}
+			e := event{}
+			if err := json.Unmarshal([]byte(p[1]), &e); err != nil {
+				t.Fatalf("bad event %q: %s", p[1], err)
+			}
+			if e.Op != "unlink" || e.Path != n.path("file") || e.Pid != 42 || e.Uid != 1000 || e.Gid != 1001 || e.Response != tc.response {
+				t.Errorf("got event %+v, want an unlink of %q with %s", e, n.path("file"), tc.response)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event published for %s", tc.response)
+		}
+	}
+}
+
+func TestParseNATS(t *testing.T) {
+	for _, tc := range []struct {
+		url           string
+		addr, subject string
+		ok            bool
+	}{
+		{"nats://localhost", "localhost:4222", natsSubject, true},
+		{"nats://localhost:4223/mutfs.a", "localhost:4223", "mutfs.a", true},
+		{"nats:///mutfs.a", "", "", false},
+		{"http://localhost/mutfs.a", "", "", false},
+		{"nats://localhost/a/b", "", "", false},
+	} {
+		addr, subject, err := parseNATS(tc.url)
+		if (err == nil) != tc.ok || addr != tc.addr || subject != tc.subject {
+			t.Errorf("parseNATS(%q) = %q, %q, %v, want %q, %q and ok %t", tc.url, addr, subject, err, tc.addr, tc.subject, tc.ok)
+		}
+	}
+}
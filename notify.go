@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// Notify enables desktop notifications for denials.
+var Notify bool
+
+// userRuntime is where the runtime directories of the users, which hold their session bus, are.
+var userRuntime = "/run/user"
+
+// notifyInterval is the minimum time between two notifications to the same user.
+const notifyInterval = 10 * time.Second
+
+var notified = struct {
+	sync.Mutex
+	last map[uint32]time.Time
+}{last: map[uint32]time.Time{}}
+
+// notify sends a desktop notification to the user of caller, telling op on path was blocked. This uses notify-send
+// to talk to the user's D-Bus session bus, if the user has no session bus nothing is sent.
+func notify(caller *fuse.Caller, op, path string) {
+	if caller == nil {
+		return
+	}
+	bus := filepath.Join(userRuntime, strconv.FormatUint(uint64(caller.Uid), 10), "bus")
+	if _, err := os.Stat(bus); err != nil {
+		return
+	}
+
+	notified.Lock()
+	if time.Since(notified.last[caller.Uid]) < notifyInterval {
+		notified.Unlock()
+		return
+	}
+	notified.last[caller.Uid] = time.Now()
+	notified.Unlock()
+
+	cmd := exec.Command("notify-send", "-a", "mutfs", "mutfs", fmt.Sprintf("mutfs blocked %s on %s", op, path))
+	cmd.Env = append(os.Environ(), "DBUS_SESSION_BUS_ADDRESS=unix:path="+bus)
+	if os.Getuid() == 0 && caller.Uid != 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: caller.Uid, Gid: caller.Gid}}
+	}
+	go func() {
+		if err := cmd.Run(); err != nil && Log {
+			log.Printf("Failed to send notification to %d: %s", caller.Uid, err)
+		}
+	}()
+}
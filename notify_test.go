@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestNotify(t *testing.T) {
+	defer func(r string) { userRuntime = r }(userRuntime)
+	userRuntime = t.TempDir()
+	if err := os.Mkdir(filepath.Join(userRuntime, "0"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(userRuntime, "0", "bus"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fake notify-send that appends its arguments to sent
+	bin := t.TempDir()
+	sent := filepath.Join(bin, "sent")
+	script := "#!/bin/sh\necho \"$@\" >> " + sent + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "notify-send"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	notified.Lock()
+	delete(notified.last, 0)
+	notified.Unlock()
+	caller := &fuse.Caller{Owner: fuse.Owner{Uid: 0, Gid: 0}, Pid: 42}
+	notify(caller, "unlink", "/olddir/file")
+	waitFor(t, "the notification", func() bool { return exists(sent) })
+	// within notifyInterval nothing is sent, and users without a session bus get nothing
+	notify(caller, "rename", "/olddir/file")
+	notify(&fuse.Caller{Owner: fuse.Owner{Uid: 1000, Gid: 1000}}, "unlink", "/olddir/file")
+	time.Sleep(100 * time.Millisecond)
+
+	buf, err := os.ReadFile(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(buf)); got != "-a mutfs mutfs mutfs blocked unlink on /olddir/file" {
+		t.Errorf("got notifications %q, want only the one for the unlink", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// RespectOpen denies deleting files during the grace period when they are open in another process.
+var RespectOpen bool
+
+// openElsewhere returns true if a process other than pid has path open, as seen in /proc/<pid>/fd. Files opened
+// through the mount are held open by mutfs itself and are found as well.
+func openElsewhere(path string, pid uint32) bool {
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return false
+	}
+	for _, p := range procs {
+		other, err := strconv.ParseUint(p.Name(), 10, 32)
+		if err != nil || uint32(other) == pid {
+			continue
+		}
+		dir := filepath.Join(procRoot, p.Name(), "fd")
+		fds, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(dir, fd.Name())); err == nil && target == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// busy returns EBUSY, after logging the denial, when r deletes a file that is open elsewhere.
+func busy(r *request) (syscall.Errno, bool) {
+	if r.op != "unlink" && r.op != "rmdir" {
+		return 0, false
+	}
+	if !openElsewhere(r.path, r.caller.Pid) {
+		return 0, false
+	}
+	denied(r.op, r.path, r.caller, "open in another process")
+	return syscall.EBUSY, true
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestRespectOpen(t *testing.T) {
+	defer func(r bool) { RespectOpen = r }(RespectOpen)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	RespectOpen, Grace = true, time.Hour
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// a child holds the file open, as this process is the caller and is skipped
+	f, err := os.Open(filepath.Join(olddir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sleep", "60")
+	cmd.Stdin = f
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't start sleep: %s", err)
+	}
+	f.Close()
+	defer cmd.Process.Kill()
+
+	file := filepath.Join(newdir, "file")
+	if err := os.Remove(file); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("remove of an open file: got %v, want EBUSY", err)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	if err := os.Remove(file); err != nil {
+		t.Errorf("remove of a closed file: %s", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// OpRules are the rules given with rule=, they are evaluated in order and the first that matches decides.
+var OpRules []opRule
+
+// opRule allows or denies a set of operations on the paths matching a pattern.
+type opRule struct {
+	allow   bool
+	ops     map[string]bool // nil means all operations
+	pattern string          // shell pattern relative to the root, a trailing /** matches the whole subtree
+}
+
+// parseOpRule parses a rule in the form <allow|deny>:<op>[+<op>...]:<pattern>. The op may be * for all operations.
+func parseOpRule(s string) (opRule, error) {
+	xs := strings.SplitN(s, ":", 3)
+	if len(xs) != 3 {
+		return opRule{}, fmt.Errorf("need <allow|deny>:<ops>:<pattern>")
+	}
+	r := opRule{}
+	switch xs[0] {
+	case "allow":
+		r.allow = true
+	case "deny":
+	default:
+		return opRule{}, fmt.Errorf("unknown action %q", xs[0])
+	}
+	if xs[1] != "*" {
+		r.ops = map[string]bool{}
+		for _, op := range strings.Split(xs[1], "+") {
+			if op == "write" {
+				op = "open"
+			}
+			if !ops[op] {
+				return opRule{}, fmt.Errorf("unknown operation %q", op)
+			}
+			r.ops[op] = true
+		}
+	}
+	r.pattern = strings.TrimPrefix(xs[2], "/")
+	if r.pattern == "" || strings.HasPrefix(filepath.Clean(r.pattern), "..") {
+		return opRule{}, fmt.Errorf("pattern must be relative to the root")
+	}
+	if _, err := filepath.Match(strings.TrimSuffix(r.pattern, "/**"), ""); err != nil {
+		return opRule{}, err
+	}
+	return r, nil
+}
+
+// match returns true if r applies to op on rel, which is relative to the root.
+func (r opRule) match(op, rel string) bool {
+	if r.ops != nil && !r.ops[op] {
+		return false
+	}
+	rel = filepath.Clean(rel)
+	if dir := strings.TrimSuffix(r.pattern, "/**"); dir != r.pattern {
+		for p := rel; p != "." && p != "/"; p = filepath.Dir(p) {
+			if ok, _ := filepath.Match(dir, p); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := filepath.Match(r.pattern, rel)
+	return ok
+}
+
+func (r opRule) String() string {
+	action := "deny"
+	if r.allow {
+		action = "allow"
+	}
+	ops := "*"
+	if r.ops != nil {
+		xs := make([]string, 0, len(r.ops))
+		for op := range r.ops {
+			xs = append(xs, op)
+		}
+		sort.Strings(xs)
+		ops = strings.Join(xs, "+")
+	}
+	return action + ":" + ops + ":" + r.pattern
+}
+
+func ruleOpRules(r *request) (syscall.Errno, bool) {
+	for _, or := range OpRules {
+		if !or.match(r.op, r.rel) {
+			continue
+		}
+		if or.allow {
+			return granted(r, "rule "+or.String()), true
+		}
+		return denied(r.op, r.path, r.caller, "rule "+or.String()), true
+	}
+	return fs.OK, false
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestOpRules(t *testing.T) {
+	defer func(r []opRule) { OpRules = r }(OpRules)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	OpRules, Grace = nil, 0
+	for _, o := range []string{"rule=deny:unlink:scratch/keep*", "rule=allow:unlink+rename:scratch/**", "rule=allow:*:tmp/*.log"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, tc := range []struct {
+		op, name string
+		want     syscall.Errno
+	}{
+		{"unlink", "scratch/a", fs.OK},
+		{"rename", "scratch/dir/a", fs.OK},
+		{"setattr", "scratch/a", syscall.EACCES},
+		{"unlink", "scratch/keep.txt", syscall.EACCES},
+		{"rename", "scratch/keep.txt", fs.OK},
+		{"unlink", "other/a", syscall.EACCES},
+		{"setattr", "tmp/app.log", fs.OK},
+		{"unlink", "tmp/sub/app.log", syscall.EACCES},
+		{"unlink", "file", syscall.EACCES},
+	} {
+		if got := n.deny(ctx, tc.op, tc.name); got != tc.want {
+			t.Errorf("%s of %s = %s, want %s", tc.op, tc.name, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestParseOpRule(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want string // the rule as a string, empty for an error
+	}{
+		{"allow:unlink+rename:scratch/**", "allow:rename+unlink:scratch/**"},
+		{"deny:write:/etc/*", "deny:open:etc/*"},
+		{"allow:*:tmp", "allow:*:tmp"},
+		{"permit:unlink:tmp", ""},
+		{"allow:delete:tmp", ""},
+		{"allow:unlink", ""},
+		{"allow:unlink:../tmp", ""},
+		{"allow:unlink:", ""},
+		{"allow:unlink:[", ""},
+	} {
+		r, err := parseOpRule(tc.s)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("parseOpRule(%q) = %s, want an error", tc.s, r)
+			}
+			continue
+		}
+		if err != nil || r.String() != tc.want {
+			t.Errorf("parseOpRule(%q) = %s, %v, want %s", tc.s, r, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Preload enables walking the source tree after mounting to warm the caches.
+var Preload bool
+
+// preload walks root and tells the kernel we will need the contents of every regular file it finds. It returns when
+// the walk is done or ctx is canceled.
+func preload(ctx context.Context, root string) {
+	start := time.Now()
+	files := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil
+		}
+		unix.Fadvise(int(f.Fd()), 0, 0, unix.FADV_WILLNEED)
+		f.Close()
+		files++
+		return nil
+	})
+	if !Log {
+		return
+	}
+	if err != nil {
+		log.Printf("Preload of %q stopped after %d files: %s", root, files, err)
+		return
+	}
+	log.Printf("Preload of %q done, %d files in %s", root, files, time.Since(start))
+}
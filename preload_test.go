@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestPreload(t *testing.T) {
+	defer func(l bool) { Log = l }(Log)
+	defer log.SetOutput(log.Writer())
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	Log = true
+	dir := testDir(t, 10)
+
+	preload(context.Background(), dir)
+	if !strings.Contains(buf.String(), "done, 10 files") {
+		t.Errorf("preload logged %q, want 10 files done", buf.String())
+	}
+
+	buf.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	preload(ctx, dir)
+	if !strings.Contains(buf.String(), "stopped after 0 files") {
+		t.Errorf("canceled preload logged %q, want it stopped", buf.String())
+	}
+}
+
+func BenchmarkPreload(b *testing.B) {
+	dir := testDir(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		preload(context.Background(), dir)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// dropPrivileges chroots to dir and changes to the user and group, each of them is optional. When chrooting, the paths
+// of the roots of mounts are adjusted, so each olddir, and trash and mirror directory, must be in dir.
+func dropPrivileges(mounts []*mount, usr, group, dir string) error {
+	uid, gid := -1, -1
+	if usr != "" {
+		u, err := lookupID(usr, func(s string) (string, error) { u, err := user.Lookup(s); return uidOf(u), err })
+		if err != nil {
+			return fmt.Errorf("unknown user %q: %s", usr, err)
+		}
+		uid = u
+	}
+	if group != "" {
+		g, err := lookupID(group, func(s string) (string, error) { g, err := user.LookupGroup(s); return gidOf(g), err })
+		if err != nil {
+			return fmt.Errorf("unknown group %q: %s", group, err)
+		}
+		gid = g
+	}
+
+	if dir != "" {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		roots, trashes, mirrors := make([]string, len(mounts)), make([]string, len(mounts)), make([]string, len(mounts))
+		for i, m := range mounts {
+			root, err := filepath.Rel(dir, m.root.Path)
+			if err != nil || strings.HasPrefix(root, "..") {
+				return fmt.Errorf("%q isn't in the chroot %q", m.root.Path, dir)
+			}
+			roots[i] = filepath.Join("/", root)
+			if m.trash != "" {
+				trash, err := filepath.Rel(dir, m.trash)
+				if err != nil || strings.HasPrefix(trash, "..") {
+					return fmt.Errorf("%q isn't in the chroot %q", m.trash, dir)
+				}
+				trashes[i] = filepath.Join("/", trash)
+			}
+			if m.mirrorDir != "" {
+				abs, _ := filepath.Abs(m.mirrorDir)
+				mirror, err := filepath.Rel(dir, abs)
+				if err != nil || strings.HasPrefix(mirror, "..") {
+					return fmt.Errorf("%q isn't in the chroot %q", m.mirrorDir, dir)
+				}
+				mirrors[i] = filepath.Join("/", mirror)
+			}
+		}
+		if err := syscall.Chroot(dir); err != nil {
+			return fmt.Errorf("chroot %q: %s", dir, err)
+		}
+		if err := syscall.Chdir("/"); err != nil {
+			return err
+		}
+		for i, m := range mounts {
+			if LogBackingPath {
+				moveBackingRoot(m.root.Path, roots[i])
+			}
+			m.root.Path = roots[i]
+			if m.trash != "" {
+				m.trash = trashes[i]
+			}
+			if m.mirrorDir != "" {
+				m.mirrorDir = mirrors[i]
+			}
+		}
+	}
+
+	if gid >= 0 {
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("setgroups: %s", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			return fmt.Errorf("setgid %d: %s", gid, err)
+		}
+	}
+	if uid >= 0 {
+		if err := syscall.Setuid(uid); err != nil {
+			return fmt.Errorf("setuid %d: %s", uid, err)
+		}
+	}
+	return nil
+}
+
+// lookupID returns s as a number, or, when it isn't numeric, the id found with lookup.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return int(id), nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return -1, err
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	return int(n), err
+}
+
+func uidOf(u *user.User) string {
+	if u == nil {
+		return ""
+	}
+	return u.Uid
+}
+
+func gidOf(g *user.Group) string {
+	if g == nil {
+		return ""
+	}
+	return g.Gid
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// TestDropPrivileges mounts and drops privileges in a child process, as they can't be regained by the test.
+func TestDropPrivileges(t *testing.T) {
+	if dir := os.Getenv("MUTFS_TEST_CHROOT"); dir != "" {
+		dropPrivilegesChild(t, dir)
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("not root")
+	}
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{"old", "new", "mirror"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old", "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the server is gone when the child exits, leaving a mount that can only be detached
+	defer syscall.Unmount(filepath.Join(dir, "new"), syscall.MNT_DETACH)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivileges$", "-test.v")
+	cmd.Env = append(os.Environ(), "MUTFS_TEST_CHROOT="+dir)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("child failed: %s\n%s", err, out)
+	}
+	t.Logf("%s", out)
+}
+
+func dropPrivilegesChild(t *testing.T, dir string) {
+	m := &mount{olddir: filepath.Join(dir, "old"), newdir: filepath.Join(dir, "new"), mirrorDir: filepath.Join(dir, "mirror")}
+	root, err := m.setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &fs.Options{MountOptions: fuse.MountOptions{AllowOther: true, Name: "mutfs", FsName: m.olddir, DirectMount: true}}
+	server, err := fs.Mount(m.newdir, root, opts)
+	if err != nil {
+		t.Skipf("can't mount: %s", err)
+	}
+	if err := server.WaitMount(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dropPrivileges([]*mount{m}, "65534", "65534", dir); err != nil {
+		t.Fatal(err)
+	}
+	if uid, euid, gid := syscall.Getuid(), syscall.Geteuid(), syscall.Getgid(); uid != 65534 || euid != 65534 || gid != 65534 {
+		t.Errorf("got uid %d, euid %d and gid %d, want 65534", uid, euid, gid)
+	}
+	if groups, err := syscall.Getgroups(); err != nil || len(groups) != 0 {
+		t.Errorf("got supplementary groups %v, %v, want none", groups, err)
+	}
+	if m.root.Path != "/old" {
+		t.Errorf("got root %q, want %q", m.root.Path, "/old")
+	}
+	if m.mirrorDir != "/mirror" {
+		t.Errorf("got mirror %q, want %q", m.mirrorDir, "/mirror")
+	}
+	if _, err := os.Stat(dir); err == nil {
+		t.Errorf("%q is visible after the chroot", dir)
+	}
+	// the server keeps working, with the source seen from within the chroot
+	if buf, err := os.ReadFile("/new/file"); err != nil || string(buf) != "contents" {
+		t.Errorf("read through the mount got %q, %v, want %q", buf, err, "contents")
+	}
+	if err := syscall.Setuid(0); err == nil {
+		t.Errorf("regained root")
+	}
+}
+
+func TestDropPrivilegesErrors(t *testing.T) {
+	// these fail before anything is changed
+	if err := dropPrivileges(nil, "nosuchuser", "", ""); err == nil {
+		t.Errorf("expected an error for an unknown user")
+	}
+	if err := dropPrivileges(nil, "", "nosuchgroup", ""); err == nil {
+		t.Errorf("expected an error for an unknown group")
+	}
+	m := &mount{root: &fs.LoopbackRoot{Path: t.TempDir()}}
+	if err := dropPrivileges([]*mount{m}, "", "", t.TempDir()); err == nil {
+		t.Errorf("expected an error for a source outside the chroot")
+	}
+	chroot := t.TempDir()
+	m = &mount{root: &fs.LoopbackRoot{Path: chroot}, mirrorDir: t.TempDir()}
+	if err := dropPrivileges([]*mount{m}, "", "", chroot); err == nil {
+		t.Errorf("expected an error for a mirror outside the chroot")
+	}
+}
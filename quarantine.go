@@ -0,0 +1,141 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quarantine is the directory where files are copied to before they are first opened for writing.
+var Quarantine string
+
+// QuarantineCompress is the compression applied to the copies in Quarantine, only "gzip" is supported.
+var QuarantineCompress string
+
+// QuarantineCoalesce is the period after a copy in which no new copy of the same file is made. When 0 a file is only
+// copied once during the life time of the mount.
+var QuarantineCoalesce time.Duration
+
+// quarantineCopy is a copy made in the Quarantine directory.
+type quarantineCopy struct {
+	dst  string
+	at   time.Time
+	done chan struct{} // closed once the copy is made, err is set by then
+	err  error
+}
+
+var quarantined = struct {
+	sync.Mutex
+	m map[string]*quarantineCopy
+}{m: map[string]*quarantineCopy{}}
+
+// quarantine copies the file at actualPath (rel is the path relative to the root) to the Quarantine directory. This
+// happens only once per file (keyed on path and btime) during the life time of the mount, or, with
+// QuarantineCoalesce, once per period. The path of the copy is returned once it is made, also when another caller
+// is making it.
+func quarantine(actualPath, rel string) (string, error) {
+	bt, err := btime(actualPath)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s@%d", rel, bt.UnixNano())
+	dst := filepath.Join(Quarantine, key)
+	t := time.Now()
+
+	quarantined.Lock()
+	if c, ok := quarantined.m[key]; ok {
+		if QuarantineCoalesce == 0 || t.Sub(c.at) < QuarantineCoalesce {
+			quarantined.Unlock()
+			<-c.done
+			if c.err != nil {
+				return "", c.err
+			}
+			return c.dst, nil
+		}
+		// a later copy is named after the time it was made as well
+		dst += fmt.Sprintf("@%d", t.UnixNano())
+	}
+	cp := copyFile
+	if QuarantineCompress == "gzip" {
+		cp = gzipFile
+		dst += ".gz"
+	}
+	c := &quarantineCopy{dst: dst, at: t, done: make(chan struct{})}
+	quarantined.m[key] = c
+	quarantined.Unlock()
+
+	c.err = cp(actualPath, dst)
+	if c.err != nil {
+		quarantined.Lock()
+		if quarantined.m[key] == c {
+			delete(quarantined.m, key)
+		}
+		quarantined.Unlock()
+	}
+	close(c.done)
+	if c.err != nil {
+		return "", c.err
+	}
+	return dst, nil
+}
+
+// copyFile copies src to dst, creating any missing directories. The mode of src is preserved.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gzipFile is like copyFile, but it compresses src with gzip.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(src)
+	zw.ModTime = fi.ModTime()
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testQuarantine sets up a Quarantine directory and returns a file to quarantine.
+func testQuarantine(t *testing.T) string {
+	t.Helper()
+	q, c, cc := Quarantine, QuarantineCompress, QuarantineCoalesce
+	t.Cleanup(func() {
+		Quarantine, QuarantineCompress, QuarantineCoalesce = q, c, cc
+		quarantined.Lock()
+		quarantined.m = map[string]*quarantineCopy{}
+		quarantined.Unlock()
+	})
+	Quarantine, QuarantineCompress, QuarantineCoalesce = t.TempDir(), "", 0
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestQuarantineOnce(t *testing.T) {
+	file := testQuarantine(t)
+
+	dsts := make([]string, 10)
+	wg := sync.WaitGroup{}
+	for i := range dsts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dst, err := quarantine(file, "file")
+			if err != nil {
+				t.Error(err)
+			}
+			dsts[i] = dst
+		}(i)
+	}
+	wg.Wait()
+	for _, dst := range dsts {
+		if dst != dsts[0] {
+			t.Errorf("got copies %q and %q, want a single one", dsts[0], dst)
+		}
+	}
+	if buf, err := os.ReadFile(dsts[0]); err != nil || string(buf) != "contents" {
+		t.Errorf("got copy %q, %v, want the contents of the file", buf, err)
+	}
+	if des, _ := os.ReadDir(Quarantine); len(des) != 1 {
+		t.Errorf("got %d entries in the quarantine directory, want 1", len(des))
+	}
+}
+
+func TestQuarantineCoalesce(t *testing.T) {
+	file := testQuarantine(t)
+	QuarantineCoalesce = 10 * time.Millisecond
+
+	first, err := quarantine(file, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := quarantine(file, "file"); again != first {
+		t.Errorf("got a new copy %q within the coalesce period", again)
+	}
+	time.Sleep(2 * QuarantineCoalesce)
+	if later, _ := quarantine(file, "file"); later == first {
+		t.Errorf("expected a new copy after the coalesce period")
+	}
+}
+
+func TestQuarantineGzip(t *testing.T) {
+	file := testQuarantine(t)
+	QuarantineCompress = "gzip"
+
+	dst, err := quarantine(file, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := io.ReadAll(zr); err != nil || string(buf) != "contents" {
+		t.Errorf("got %q, %v, want the contents of the file", buf, err)
+	}
+}
+
+func TestQuarantineFailed(t *testing.T) {
+	file := testQuarantine(t)
+	Quarantine = filepath.Join(file, "not-a-directory")
+
+	if _, err := quarantine(file, "file"); err == nil {
+		t.Fatal("expected an error")
+	}
+	quarantined.Lock()
+	n := len(quarantined.m)
+	quarantined.Unlock()
+	if n != 0 {
+		t.Errorf("a failed copy is remembered, so it won't be retried")
+	}
+}
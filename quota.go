@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// MaxDepth is the maximum depth, in levels below the root, of newly created entries, 0 means no limit.
+var MaxDepth int
+
+// countFiles sets the file count of m to the number of entries under its source.
+func (m *mount) countFiles() error {
+	n := int64(0)
+	err := filepath.WalkDir(m.olddir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != m.olddir {
+			n++
+		}
+		return nil
+	})
+	atomic.StoreInt64(&m.files, n)
+	return err
+}
+
+// quota returns EDQUOT when creating another file would exceed the maximum number of files (and directories) of m.
+func (m *mount) quota(op, actualPath string, caller *fuse.Caller) syscall.Errno {
+	if m.maxFiles == 0 || atomic.LoadInt64(&m.files) < m.maxFiles {
+		return 0
+	}
+	denied(op, actualPath, caller, "file quota reached")
+	return syscall.EDQUOT
+}
+
+// tooDeep denies creating rel, which is relative to the root, when it is more than MaxDepth levels deep.
+func tooDeep(op, actualPath, rel string, caller *fuse.Caller) syscall.Errno {
+	if MaxDepth == 0 {
+		return 0
+	}
+	depth := len(strings.Split(filepath.Clean(rel), string(filepath.Separator)))
+	if depth <= MaxDepth {
+		return 0
+	}
+	return denied(op, actualPath, caller, "deeper than "+strconv.Itoa(MaxDepth)+" levels")
+}
+
+// created adjusts the file count of m with delta after a successful create or delete.
+func (m *mount) created(delta int64) {
+	if m.maxFiles > 0 {
+		atomic.AddInt64(&m.files, delta)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestTooDeep(t *testing.T) {
+	defer func(d int) { MaxDepth = d }(MaxDepth)
+	MaxDepth = 2
+	caller := &fuse.Caller{}
+
+	for _, tc := range []struct {
+		rel  string
+		want syscall.Errno
+	}{
+		{"a", fs.OK},
+		{"a/b", fs.OK},
+		{"a/b/c", syscall.EACCES},
+	} {
+		for _, op := range []string{"create", "mkdir", "symlink", "link"} {
+			if got := tooDeep(op, "/olddir/"+tc.rel, tc.rel, caller); got != tc.want {
+				t.Errorf("tooDeep(%s, %q) = %s, want %s", op, tc.rel, errnoString(got), errnoString(tc.want))
+			}
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	m := &mount{maxFiles: 2}
+	caller := &fuse.Caller{}
+	for i := 0; i < 2; i++ {
+		if errno := m.quota("symlink", "/olddir/s", caller); errno != fs.OK {
+			t.Fatalf("quota after %d files: %s", i, errnoString(errno))
+		}
+		m.created(1)
+	}
+	if errno := m.quota("link", "/olddir/l", caller); errno != syscall.EDQUOT {
+		t.Errorf("quota when full = %s, want EDQUOT", errnoString(errno))
+	}
+	m.created(-1)
+	if errno := m.quota("link", "/olddir/l", caller); errno != fs.OK {
+		t.Errorf("quota after a delete = %s, want OK", errnoString(errno))
+	}
+}
+
+func TestMaxFiles(t *testing.T) {
+	_, newdir := testMount(t, &mount{maxFiles: 2}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "old"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := os.WriteFile(filepath.Join(newdir, "new"), nil, 0644); err != nil {
+		t.Fatalf("create below the limit: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(newdir, "dir"), 0755); !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("mkdir at the limit: got %v, want EDQUOT", err)
+	}
+	if err := os.WriteFile(filepath.Join(newdir, "other"), nil, 0644); !errors.Is(err, syscall.EDQUOT) {
+		t.Errorf("create at the limit: got %v, want EDQUOT", err)
+	}
+}
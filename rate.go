@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// CallerRate is the number of mutations a process may do in CallerRatePer, 0 disables this.
+var (
+	CallerRate    int
+	CallerRatePer time.Duration
+)
+
+// parseRate parses N/DURATION.
+func parseRate(s string) (int, time.Duration, error) {
+	ns, ds, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("rate must be N/DURATION, got %q", s)
+	}
+	n, err := strconv.Atoi(ns)
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("bad count %q", ns)
+	}
+	d, err := time.ParseDuration(ds)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("bad duration %q", ds)
+	}
+	return n, d, nil
+}
+
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+var rates = struct {
+	sync.Mutex
+	m map[uint32]*rateWindow
+}{m: map[uint32]*rateWindow{}}
+
+// overRate counts a mutation for pid and returns true when pid did more than CallerRate mutations in the current
+// window of CallerRatePer.
+func overRate(pid uint32) bool {
+	rates.Lock()
+	defer rates.Unlock()
+	t := now()
+	if len(rates.m) > 1024 {
+		for p, w := range rates.m {
+			if t.Sub(w.start) >= CallerRatePer {
+				delete(rates.m, p)
+			}
+		}
+	}
+	w, ok := rates.m[pid]
+	if !ok || t.Sub(w.start) >= CallerRatePer {
+		w = &rateWindow{start: t}
+		rates.m[pid] = w
+	}
+	w.n++
+	return w.n > CallerRate
+}
+
+// rateLimited returns EAGAIN when caller is over CallerRate, it's used for creations which don't go through the rules.
+func rateLimited(op, actualPath string, caller *fuse.Caller) syscall.Errno {
+	if CallerRate == 0 || !overRate(caller.Pid) {
+		return 0
+	}
+	denied(op, actualPath, caller, "more than "+strconv.Itoa(CallerRate)+" mutations in "+CallerRatePer.String())
+	return syscall.EAGAIN
+}
+
+func ruleCallerRate(r *request) (syscall.Errno, bool) {
+	if errno := rateLimited(r.op, r.path, r.caller); errno != fs.OK {
+		return errno, true
+	}
+	return fs.OK, false
+}
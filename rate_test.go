@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestCallerRate(t *testing.T) {
+	defer func(r int, p time.Duration) { CallerRate, CallerRatePer = r, p }(CallerRate, CallerRatePer)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(n func() time.Time) { now = n }(now)
+	if err := parseOpt(&fs.Options{}, "olddir", "caller-rate=2/1m"); err != nil {
+		t.Fatal(err)
+	}
+	Grace = time.Hour
+	rates.Lock()
+	rates.m = map[uint32]*rateWindow{}
+	rates.Unlock()
+	t0 := time.Now()
+	now = func() time.Time { return t0 }
+	n := testRoot(t)
+
+	for i, tc := range []struct {
+		pid   uint32
+		after time.Duration
+		want  syscall.Errno
+	}{
+		{42, 0, fs.OK},
+		{42, time.Second, fs.OK},
+		{42, 2 * time.Second, syscall.EAGAIN},
+		{43, 2 * time.Second, fs.OK},
+		{42, 30 * time.Second, syscall.EAGAIN},
+		{42, time.Minute, fs.OK},
+	} {
+		now = func() time.Time { return t0.Add(tc.after) }
+		if got := n.deny(callerContext(context.Background(), tc.pid, 1000, 1000), "unlink", "file"); got != tc.want {
+			t.Errorf("%d: deny for pid %d after %s = %s, want %s", i, tc.pid, tc.after, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	if n, d, err := parseRate("10/1s"); err != nil || n != 10 || d != time.Second {
+		t.Errorf("parseRate(10/1s) = %d, %s, %v", n, d, err)
+	}
+	for _, s := range []string{"10", "0/1s", "x/1s", "10/0s", "10/x"} {
+		if _, _, err := parseRate(s); err == nil {
+			t.Errorf("parseRate(%s): expected an error", s)
+		}
+	}
+}
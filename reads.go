@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// CountReads counts the reads per file, the most read files are returned by the reads control command.
+var CountReads bool
+
+var reads = struct {
+	sync.Mutex
+	m map[string]int64
+}{m: map[string]int64{}}
+
+// countRead counts a read of rel, which is relative to the root.
+func countRead(rel string) {
+	reads.Lock()
+	reads.m[rel]++
+	reads.Unlock()
+}
+
+// readCount is the number of reads of a file.
+type readCount struct {
+	rel   string
+	count int64
+}
+
+// mostRead returns the n most read files, the most read first.
+func mostRead(n int) []readCount {
+	reads.Lock()
+	rcs := make([]readCount, 0, len(reads.m))
+	for rel, c := range reads.m {
+		rcs = append(rcs, readCount{rel, c})
+	}
+	reads.Unlock()
+	sort.Slice(rcs, func(i, j int) bool {
+		if rcs[i].count != rcs[j].count {
+			return rcs[i].count > rcs[j].count
+		}
+		return rcs[i].rel < rcs[j].rel
+	})
+	if n < len(rcs) {
+		rcs = rcs[:n]
+	}
+	return rcs
+}
+
+// cmdReads writes the most read files, one per line with their read count, followed by OK. An optional argument sets
+// the number returned, the default is 10.
+func cmdReads(args []string, w io.Writer) error {
+	if !CountReads {
+		return fmt.Errorf("reads aren't counted, see count-reads")
+	}
+	n := 10
+	if len(args) > 0 {
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid count %q", args[0])
+		}
+		n = i
+	}
+	rcs := mostRead(n)
+	for _, rc := range rcs {
+		if _, err := fmt.Fprintf(w, "%d %q\n", rc.count, rc.rel); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "OK %d\n", len(rcs))
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestCountReads(t *testing.T) {
+	defer func(c bool) { CountReads = c }(CountReads)
+	CountReads = true
+	reads.Lock()
+	reads.m = map[string]int64{}
+	reads.Unlock()
+	times := map[string]int{"a": 3, "b": 1, "c": 2}
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for name := range times {
+			if err := os.WriteFile(filepath.Join(olddir, name), []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	for name, n := range times {
+		for i := 0; i < n; i++ {
+			if _, err := os.ReadFile(filepath.Join(newdir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var order []string
+	for _, rc := range mostRead(10) {
+		order = append(order, rc.rel)
+	}
+	if got := strings.Join(order, " "); got != "a c b" {
+		t.Errorf("most read files are %q, want %q", got, "a c b")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := cmdReads([]string{"1"}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%d \"a\"\nOK 1\n", reads.m["a"]); buf.String() != want {
+		t.Errorf("reads 1 = %q, want %q", buf, want)
+	}
+	if err := cmdReads([]string{"-1"}, buf); err == nil {
+		t.Errorf("expected an error for a negative count")
+	}
+	CountReads = false
+	if err := cmdReads(nil, buf); err == nil {
+		t.Errorf("expected an error without count-reads")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// recordVersion is the version of the record format, it is written in the first line of each record.
+const recordVersion = 1
+
+// Recorder, when not nil, records every operation.
+var Recorder *recorder
+
+// recordPath is the path of the record, Recorder is opened on it.
+var recordPath string
+
+type recorder struct {
+	sync.Mutex
+	f *os.File
+}
+
+// recEntry is a single recorded operation, stored as a line of JSON. Path and New are relative to the root.
+type recEntry struct {
+	Op    string  `json:"op"`
+	Path  string  `json:"path"`
+	New   string  `json:"new,omitempty"`
+	Flags uint32  `json:"flags,omitempty"`
+	Mode  uint32  `json:"mode,omitempty"`
+	Attr  string  `json:"attr,omitempty"`
+	Off   int64   `json:"off,omitempty"`
+	Len   int     `json:"len,omitempty"`
+	Size  *uint64 `json:"size,omitempty"`
+	Pid   uint32  `json:"pid,omitempty"`
+	Uid   uint32  `json:"uid,omitempty"`
+	Errno int     `json:"errno"`
+}
+
+// recHeader is the first line of a record.
+type recHeader struct {
+	Version int       `json:"mutfs-record"`
+	Time    time.Time `json:"time"`
+}
+
+func openRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	buf, _ := json.Marshal(recHeader{Version: recordVersion, Time: time.Now().UTC()})
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &recorder{f: f}, nil
+}
+
+// record records e with result errno.
+func (r *recorder) record(ctx context.Context, e recEntry, errno syscall.Errno) {
+	if caller, ok := fuse.FromContext(ctx); ok {
+		e.Pid, e.Uid = caller.Pid, caller.Uid
+	}
+	e.Errno = int(errno)
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.f.Write(append(buf, '\n'))
+}
+
+// replay replays the record in path against the mutfs mounted on mnt and writes each operation that returns a
+// different result than recorded to w. It returns the number of mismatches. Note that all operations are replayed
+// as the current user.
+func replay(path, mnt string, w *os.File) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("empty record")
+	}
+	h := recHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil || h.Version != recordVersion {
+		return 0, fmt.Errorf("not a version %d record", recordVersion)
+	}
+
+	mismatch := 0
+	line := 1
+	for scanner.Scan() {
+		line++
+		e := recEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return mismatch, fmt.Errorf("line %d: %s", line, err)
+		}
+		if got := e.replay(mnt); got != syscall.Errno(e.Errno) {
+			fmt.Fprintf(w, "line %d: %s %q: recorded %s, got %s\n", line, e.Op, e.Path, errnoString(syscall.Errno(e.Errno)), errnoString(got))
+			mismatch++
+		}
+	}
+	return mismatch, scanner.Err()
+}
+
+// replay executes e on mnt and returns the resulting errno.
+func (e recEntry) replay(mnt string) syscall.Errno {
+	p := filepath.Join(mnt, e.Path)
+	var err error
+	switch e.Op {
+	case "lookup", "getattr":
+		_, err = os.Lstat(p)
+	case "readdir":
+		_, err = os.ReadDir(p)
+	case "getxattr":
+		_, err = unix.Lgetxattr(p, e.Attr, nil)
+	case "listxattr":
+		_, err = unix.Llistxattr(p, nil)
+	case "open", "create":
+		var fd int
+		if fd, err = syscall.Open(p, int(e.Flags), e.Mode); err == nil {
+			syscall.Close(fd)
+		}
+	case "read":
+		var fd int
+		if fd, err = syscall.Open(p, syscall.O_RDONLY, 0); err == nil {
+			_, err = syscall.Pread(fd, make([]byte, e.Len), e.Off)
+			syscall.Close(fd)
+		}
+	case "unlink":
+		err = syscall.Unlink(p)
+	case "rmdir":
+		err = syscall.Rmdir(p)
+	case "rename":
+		err = syscall.Rename(p, filepath.Join(mnt, e.New))
+	case "mkdir":
+		err = syscall.Mkdir(p, e.Mode)
+	case "mknod":
+		err = syscall.Mknod(p, e.Mode, 0)
+	case "setxattr":
+		err = unix.Lsetxattr(p, e.Attr, []byte{}, int(e.Flags))
+	case "removexattr":
+		err = unix.Lremovexattr(p, e.Attr)
+	case "setattr":
+		switch {
+		case e.Size != nil:
+			err = syscall.Truncate(p, int64(*e.Size))
+		case e.Mode != 0:
+			err = syscall.Chmod(p, e.Mode)
+		default:
+			now := time.Now()
+			err = os.Chtimes(p, now, now)
+		}
+	}
+	if err == nil {
+		return 0
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return syscall.EIO
+}
+
+// setattrEntry returns the entry for a setattr of rel.
+func setattrEntry(rel string, in *fuse.SetAttrIn) recEntry {
+	e := recEntry{Op: "setattr", Path: rel}
+	if sz, ok := in.GetSize(); ok {
+		e.Size = &sz
+	}
+	if m, ok := in.GetMode(); ok {
+		e.Mode = m
+	}
+	return e
+}
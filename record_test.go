@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestRecordReplay(t *testing.T) {
+	defer func(r *recorder) { Recorder = r }(Recorder)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	prepare := func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "old"), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "record")
+	r, err := openRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Recorder = r
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, prepare)
+
+	if _, err := os.ReadFile(filepath.Join(newdir, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(newdir, "old")); err == nil {
+		t.Fatal("expected the unlink to be denied")
+	}
+	if err := os.WriteFile(filepath.Join(newdir, "new"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	Recorder = nil
+	r.f.Close()
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"op":"unlink","path":"old"`, `"op":"create","path":"new"`} {
+		if !strings.Contains(string(buf), want) {
+			t.Errorf("record doesn't contain %s:\n%s", want, buf)
+		}
+	}
+
+	// replaying against a mount of the same source gives the same results
+	_, newdir = testMount(t, &mount{}, &fs.Options{}, prepare)
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	mismatch, err := replay(path, newdir, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch > 0 {
+		buf, _ := os.ReadFile(out.Name())
+		t.Errorf("replay got %d mismatches:\n%s", mismatch, buf)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// RenameFrom and RenameTo present the entries (not directories) whose name matches RenameFrom under the name made
+// by replacing the match with RenameTo. Renamed entries are read-only.
+var (
+	RenameFrom *regexp.Regexp
+	RenameTo   string
+)
+
+// parseRenameMap parses FROM:TO, the last colon separates the two, so FROM may contain colons.
+func parseRenameMap(s string) (*regexp.Regexp, string, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return nil, "", fmt.Errorf("rename-map must be FROM:TO, got %q", s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return nil, "", err
+	}
+	return re, s[i+1:], nil
+}
+
+// renameCacheSize is the number of directories whose renamed entries are cached, the cache is emptied when it's full.
+const renameCacheSize = 4096
+
+// renameCache holds the renamed entries per directory, with the modification time of the directory they were read
+// at. An entry is used as long as the directory has that modification time.
+var renameCache = struct {
+	sync.Mutex
+	m map[string]renameEntry
+}{m: map[string]renameEntry{}}
+
+type renameEntry struct {
+	mtime     syscall.Timespec
+	byName    map[string]string
+	byBacking map[string]string
+}
+
+// renames returns the renamed entries in dir: presented name to backing name and the reverse. When a new name
+// collides with an existing entry, or with another new name, the entry keeps its name. The maps must not be
+// changed, they are cached until dir is modified.
+func renames(dir string) (byName, byBacking map[string]string) {
+	st := syscall.Stat_t{}
+	if err := syscall.Stat(dir, &st); err != nil {
+		return map[string]string{}, map[string]string{}
+	}
+	renameCache.Lock()
+	e, ok := renameCache.m[dir]
+	renameCache.Unlock()
+	if ok && e.mtime == st.Mtim {
+		return e.byName, e.byBacking
+	}
+
+	byName, byBacking = readRenames(dir)
+	renameCache.Lock()
+	defer renameCache.Unlock()
+	if len(renameCache.m) >= renameCacheSize {
+		renameCache.m = map[string]renameEntry{}
+	}
+	renameCache.m[dir] = renameEntry{mtime: st.Mtim, byName: byName, byBacking: byBacking}
+	return byName, byBacking
+}
+
+// forgetRenames drops the cached renamed entries of dir, after an entry in it was renamed through mutfs. A rename
+// within the resolution of the modification time would otherwise go unnoticed.
+func forgetRenames(dir string) {
+	renameCache.Lock()
+	defer renameCache.Unlock()
+	delete(renameCache.m, dir)
+}
+
+// readRenames reads the renamed entries in dir, see renames.
+func readRenames(dir string) (byName, byBacking map[string]string) {
+	byName, byBacking = map[string]string{}, map[string]string{}
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return byName, byBacking
+	}
+	names := make(map[string]bool, len(des))
+	for _, de := range des {
+		names[de.Name()] = true
+	}
+	count := map[string]int{}
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		name := RenameFrom.ReplaceAllString(de.Name(), RenameTo)
+		if name == de.Name() || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		byName[name] = de.Name()
+		count[name]++
+	}
+	for name, backing := range byName {
+		if names[name] || count[name] > 1 {
+			delete(byName, name)
+			continue
+		}
+		byBacking[backing] = name
+	}
+	return byName, byBacking
+}
+
+// renamed returns true if name in n is a renamed entry.
+func (n *MutNode) renamed(name string) bool {
+	byName, _ := renames(n.path(""))
+	_, ok := byName[name]
+	return ok
+}
+
+// lookupRenamed looks up name in n: renamed entries are found under their new name only. It returns false when name
+// isn't affected by the rename map.
+func (n *MutNode) lookupRenamed(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno, bool) {
+	byName, byBacking := renames(n.path(""))
+	if _, ok := byBacking[name]; ok {
+		return nil, syscall.ENOENT, true
+	}
+	backing, ok := byName[name]
+	if !ok {
+		return nil, fs.OK, false
+	}
+	p := n.path(backing)
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(p, &st); err != nil {
+		return nil, fs.ToErrno(err), true
+	}
+	out.Attr.FromStat(&st)
+	swapped := (uint64(st.Dev) << 32) | (uint64(st.Dev) >> 32)
+	swappedRootDev := (n.RootData.Dev << 32) | (n.RootData.Dev >> 32)
+	stable := fs.StableAttr{Mode: st.Mode, Gen: 1, Ino: (swapped ^ swappedRootDev) ^ st.Ino}
+	return n.NewInode(ctx, &renamedNode{path: p}, stable), fs.OK, true
+}
+
+// renamedNode is a read-only entry presented under a new name.
+type renamedNode struct {
+	fs.Inode
+	path string // path in the underlying file system
+}
+
+var (
+	_ = (fs.NodeOpener)((*renamedNode)(nil))
+	_ = (fs.NodeGetattrer)((*renamedNode)(nil))
+	_ = (fs.NodeReadlinker)((*renamedNode)(nil))
+)
+
+func (r *renamedNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_APPEND|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	fd, err := syscall.Open(r.path, int(flags), 0)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return fs.NewLoopbackFile(fd), 0, fs.OK
+}
+
+func (r *renamedNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(r.path, &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	return fs.OK
+}
+
+func (r *renamedNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	target, err := os.Readlink(r.path)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return []byte(target), fs.OK
+}
+
+// renameDirStream presents the entries of a directory under their new names.
+type renameDirStream struct {
+	fs.DirStream
+	byBacking map[string]string
+}
+
+func (ds *renameDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	e, errno := ds.DirStream.Next()
+	if name, ok := ds.byBacking[e.Name]; ok {
+		e.Name = name
+	}
+	return e, errno
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRenames(t *testing.T) {
+	defer func(re *regexp.Regexp, to string) { RenameFrom, RenameTo = re, to }(RenameFrom, RenameTo)
+	RenameFrom, RenameTo = regexp.MustCompile(`\.txt$`), ".md"
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "b.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byName, byBacking := renames(dir)
+	if byName["a.md"] != "a.txt" || byBacking["a.txt"] != "a.md" {
+		t.Errorf("expected a.txt to be presented as a.md, got %v", byName)
+	}
+	if _, ok := byName["b.md"]; ok {
+		t.Errorf("expected b.txt to keep its name, as b.md exists")
+	}
+
+	// a new entry changes the modification time of the directory, which drops the cached entries
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if byName, _ := renames(dir); byName["c.md"] != "c.txt" {
+		t.Errorf("expected c.txt to be presented as c.md after it was created, got %v", byName)
+	}
+
+	// a rename through mutfs drops them as well, even within the resolution of the modification time
+	if err := os.Rename(filepath.Join(dir, "c.txt"), filepath.Join(dir, "d.txt")); err != nil {
+		t.Fatal(err)
+	}
+	forgetRenames(dir)
+	if byName, _ := renames(dir); byName["d.md"] != "d.txt" {
+		t.Errorf("expected d.txt to be presented as d.md after the rename, got %v", byName)
+	}
+}
+
+func BenchmarkRenames(b *testing.B) {
+	defer func(re *regexp.Regexp, to string) { RenameFrom, RenameTo = re, to }(RenameFrom, RenameTo)
+	RenameFrom, RenameTo = regexp.MustCompile(`\.txt$`), ".md"
+
+	dir := b.TempDir()
+	for i := 0; i < 1000; i++ {
+		if err := os.WriteFile(filepath.Join(dir, time.Duration(i).String()+".txt"), nil, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		renames(dir)
+	}
+}
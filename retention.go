@@ -0,0 +1,39 @@
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// ProtectAfter, when set, protects files created after it.
+var ProtectAfter time.Time
+
+// ProtectBefore, when set, protects files created before it.
+var ProtectBefore time.Time
+
+// ProtectOlderThan, when set, protects files older than it.
+var ProtectOlderThan time.Duration
+
+func ruleProtectAfter(r *request) (syscall.Errno, bool) {
+	bt, err := btime(r.path)
+	if err != nil || !bt.After(ProtectAfter) {
+		return fs.OK, false
+	}
+	return denied(r.op, r.path, r.caller, "created after "+ProtectAfter.Format(time.RFC3339)), true
+}
+
+func ruleProtectBefore(r *request) (syscall.Errno, bool) {
+	bt, err := btime(r.path)
+	if err != nil {
+		return fs.OK, false
+	}
+	if !ProtectBefore.IsZero() && bt.Before(ProtectBefore) {
+		return denied(r.op, r.path, r.caller, "created before "+ProtectBefore.Format(time.RFC3339)), true
+	}
+	if ProtectOlderThan > 0 && now().Sub(bt) > ProtectOlderThan {
+		return denied(r.op, r.path, r.caller, "older than "+ProtectOlderThan.String()), true
+	}
+	return fs.OK, false
+}
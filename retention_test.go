@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// fakeBtimes makes btime return the creation time of each name in btimes, relative to t0. It is restored when t is
+// done.
+func fakeBtimes(t *testing.T, n *MutNode, t0 time.Time, btimes map[string]time.Duration) {
+	b := btime
+	t.Cleanup(func() { btime = b })
+	btime = func(path string) (time.Time, error) {
+		for name, d := range btimes {
+			if path == n.path(name) {
+				return t0.Add(d), nil
+			}
+		}
+		return time.Time{}, syscall.ENOENT
+	}
+}
+
+func TestProtectAfter(t *testing.T) {
+	defer func(p time.Time) { ProtectAfter = p }(ProtectAfter)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 100 * 24 * time.Hour
+	t0 := time.Now().Add(-time.Hour)
+	if err := parseOpt(&fs.Options{}, "olddir", "protect-after="+t0.Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	n := testRoot(t)
+	fakeBtimes(t, n, t0, map[string]time.Duration{"old": -time.Minute, "new": time.Minute})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for name, want := range map[string]syscall.Errno{"old": fs.OK, "new": syscall.EACCES} {
+		if got := n.deny(ctx, "unlink", name); got != want {
+			t.Errorf("unlink of %s = %s, want %s", name, errnoString(got), errnoString(want))
+		}
+	}
+	if err := parseOpt(&fs.Options{}, "olddir", "protect-after=yesterday"); err == nil {
+		t.Errorf("expected an error for a time that isn't RFC 3339")
+	}
+}
+
+func TestProtectBefore(t *testing.T) {
+	defer func(p, a time.Time) { ProtectBefore, ProtectAfter = p, a }(ProtectBefore, ProtectAfter)
+	defer func(p time.Duration) { ProtectOlderThan = p }(ProtectOlderThan)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(n func() time.Time) { now = n }(now)
+	Grace = time.Hour
+	t0 := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	now = func() time.Time { return t0.Add(30 * time.Minute) }
+	n := testRoot(t)
+	fakeBtimes(t, n, t0, map[string]time.Duration{"older": -time.Minute, "newer": time.Minute, "newest": 3 * time.Minute})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// files older than the cutoff are immutable, newer ones follow the grace period; with protect-after the files
+	// between both cutoffs stay mutable
+	for _, tc := range []struct {
+		opts []string
+		want map[string]syscall.Errno
+	}{
+		{
+			[]string{"protect-before=" + t0.Format(time.RFC3339)},
+			map[string]syscall.Errno{"older": syscall.EACCES, "newer": fs.OK, "newest": fs.OK},
+		},
+		{
+			[]string{"protect-before=" + t0.Format(time.RFC3339), "protect-after=" + t0.Add(2*time.Minute).Format(time.RFC3339)},
+			map[string]syscall.Errno{"older": syscall.EACCES, "newer": fs.OK, "newest": syscall.EACCES},
+		},
+		{
+			[]string{"protect-older-than=30m"},
+			map[string]syscall.Errno{"older": syscall.EACCES, "newer": fs.OK, "newest": fs.OK},
+		},
+	} {
+		ProtectBefore, ProtectAfter, ProtectOlderThan = time.Time{}, time.Time{}, 0
+		for _, o := range tc.opts {
+			if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, want := range tc.want {
+			if got := n.deny(ctx, "unlink", name); got != want {
+				t.Errorf("with %v unlink of %s = %s, want %s", tc.opts, name, errnoString(got), errnoString(want))
+			}
+		}
+	}
+
+	// once the grace period is over newer files are immutable as well
+	now = func() time.Time { return t0.Add(2 * time.Hour) }
+	if got := n.deny(ctx, "unlink", "newer"); got != syscall.EACCES {
+		t.Errorf("unlink of newer after its grace period = %s, want EACCES", errnoString(got))
+	}
+}
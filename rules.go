@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// request is a single mutation that needs a decision.
+type request struct {
+	op     string
+	path   string // path in the underlying file system
+	rel    string // path relative to the root
+	flags  uint32 // open flags for open, the valid attributes (FATTR_*) for setattr
+	caller *fuse.Caller
+	mount  *mount
+}
+
+// rule is a single step in deciding if a request is allowed. Eval returns true when the rule made a decision, the
+// returned errno is then the result: fs.OK to allow, anything else is returned to the caller. Rules that are not
+// enabled are skipped.
+type rule struct {
+	name    string
+	enabled func() bool
+	eval    func(r *request) (syscall.Errno, bool)
+}
+
+// rules are evaluated in order, the first rule that makes a decision wins. A freeze is an emergency stop, so it comes
+// first and no other rule can allow what it denies. The rules that only deny come before the ones that allow, so an
+// allowed caller, a rule or a writable subtree doesn't skip them.
+var rules = []rule{
+	{"freeze", func() bool { return Control != "" }, ruleFreeze},
+	{"single-fs", func() bool { return anyMount(func(m *mount) bool { return m.singleFS }) }, ruleSingleFS},
+	{"caller-rate", func() bool { return CallerRate > 0 }, ruleCallerRate},
+	{"no-suid-callers", func() bool { return NoSuidCallers }, ruleNoSuidCallers},
+	{"unlock-file", func() bool { return UnlockFile != "" }, ruleUnlockFile},
+	{"require-tty", func() bool { return RequireTTY }, ruleRequireTTY},
+	{"allow-cgroup", func() bool { return len(AllowCgroup) > 0 }, ruleAllowCgroup},
+	{"exe", func() bool { return len(AllowExe) > 0 || len(DenyExe) > 0 }, ruleExe},
+	{"deny-cmdline", func() bool { return len(DenyCmdline) > 0 }, ruleDenyCmdline},
+	{"caller-min-age", func() bool { return CallerMinAge > 0 }, ruleCallerMinAge},
+	{"require-env", func() bool { return len(RequireEnv) > 0 }, ruleRequireEnv},
+	{"freeze-content", func() bool { return FreezeContent }, ruleFreezeContent},
+	{"protect-type", func() bool { return len(ProtectType) > 0 }, ruleProtectType},
+	{"protect-after", func() bool { return !ProtectAfter.IsZero() }, ruleProtectAfter},
+	{"protect-before", func() bool { return !ProtectBefore.IsZero() || ProtectOlderThan > 0 }, ruleProtectBefore},
+	{"confirm-delete", func() bool { return ConfirmDelete > 0 }, ruleConfirmDelete},
+	{"allow-uid", func() bool { return len(AllowUID) > 0 }, ruleAllowUID},
+	{"rule", func() bool { return len(OpRules) > 0 }, ruleOpRules},
+	{"writable", func() bool { return len(Writable) > 0 }, ruleWritable},
+	{"strict-append", func() bool { return StrictAppend }, ruleStrictAppend},
+	{"grace", nil, ruleGrace},
+	{"immutable", nil, ruleImmutable},
+}
+
+func (n *MutNode) decide(ctx context.Context, op, name string, flags uint32) syscall.Errno {
+	caller, _ := fuse.FromContext(ctx)
+	r := &request{op: op, path: n.path(name), rel: n.rel(name), flags: flags, caller: caller, mount: n.mount}
+
+	var trace []string
+	for _, rl := range rules {
+		if rl.enabled != nil && !rl.enabled() {
+			continue
+		}
+		trace = append(trace, rl.name)
+		errno, ok := rl.eval(r)
+		if !ok {
+			continue
+		}
+		if TraceDecisions {
+			log.Printf("Decision (%s) for %q: evaluated %s, decided by %s: %s", op, r.path, strings.Join(trace, ","), rl.name, errnoString(errno))
+		}
+		return errno
+	}
+	// not reached, as the last rule always decides
+	return errnoFor(op)
+}
+
+func ruleAllowUID(r *request) (syscall.Errno, bool) {
+	if allowedCaller(r.caller) {
+		return granted(r, "allowed caller"), true
+	}
+	return fs.OK, false
+}
+
+func ruleWritable(r *request) (syscall.Errno, bool) {
+	if writable(r.rel) {
+		return granted(r, "writable subtree"), true
+	}
+	return fs.OK, false
+}
+
+func ruleRequireTTY(r *request) (syscall.Errno, bool) {
+	if !hasTTY(r.caller.Pid) {
+		return denied(r.op, r.path, r.caller, "no controlling terminal"), true
+	}
+	return fs.OK, false
+}
+
+func ruleStrictAppend(r *request) (syscall.Errno, bool) {
+	if r.op != "open" || r.flags&syscall.O_APPEND == 0 {
+		return fs.OK, false
+	}
+	if r.flags&syscall.O_ACCMODE != syscall.O_WRONLY || r.flags&syscall.O_TRUNC != 0 {
+		return denied(r.op, r.path, r.caller, "not a write-only append"), true
+	}
+	return granted(r, "append"), true
+}
+
+// now returns the current time, it can be replaced to test time based rules.
+var now = time.Now
+
+func ruleGrace(r *request) (syscall.Errno, bool) {
+	if !graceUnder(r.rel) {
+		return fs.OK, false
+	}
+	if now().Before(GraceUntil) {
+		if RespectOpen {
+			if errno, ok := busy(r); ok {
+				return errno, true
+			}
+		}
+		if errno := granted(r, "grace until "+GraceUntil.Format(time.RFC3339)); errno != fs.OK {
+			return errno, true
+		}
+		graceGranted(r, GraceUntil, GraceUntil.Sub(Stats.start))
+		return fs.OK, true
+	}
+	bt, err := btime(r.path)
+	if err != nil && transient(err) {
+		log.Printf("Backing store error for %q: %s", r.path, err)
+		return fs.ToErrno(err), true
+	}
+	if err != nil || !graceSize(r.path) {
+		return fs.OK, false
+	}
+	if StickyBtime {
+		bt = stickyBtime(r.path, bt)
+	}
+	grace := graceFor(r.op, r.path)
+	since := now().Sub(bt)
+	if since >= grace {
+		return fs.OK, false
+	}
+	if GraceCooldown > 0 && cooldown(r.path, bt, grace) {
+		return denied(r.op, r.path, r.caller, "grace period too soon after the previous one"), true
+	}
+	if RespectOpen {
+		if errno, ok := busy(r); ok {
+			return errno, true
+		}
+	}
+	if errno := granted(r, "grace: "+(grace-since).String()); errno != fs.OK {
+		return errno, true
+	}
+	graceGranted(r, bt.Add(grace), grace)
+	return fs.OK, true
+}
+
+// graceGranted records r, which was allowed by a grace period of length grace that ends at end, in the hash log,
+// auditd, the status and the stats.
+func graceGranted(r *request, end time.Time, grace time.Duration) {
+	if HashLog != nil {
+		if err := HashLog.Append(r.op, r.path, r.caller); err != nil {
+			log.Printf("Failed to write to hash log: %s", err)
+		}
+	}
+	if Audit {
+		audit(r.op, r.path, r.caller, "success")
+	}
+	if Control != "" {
+		track(r.path, end)
+	}
+	Stats.graceUsed(r.path, end.Sub(now()), grace)
+}
+
+func ruleImmutable(r *request) (syscall.Errno, bool) {
+	return denied(r.op, r.path, r.caller, "outside of grace period"), true
+}
+
+// granted logs, when enabled, why r was allowed and returns fs.OK. With TwoPersonDelete a delete is first held until it
+// is approved, when it isn't the errno of the denial is returned.
+func granted(r *request, reason string) syscall.Errno {
+	if TwoPersonDelete > 0 && (r.op == "unlink" || r.op == "rmdir") {
+		if errno := approval(r); errno != fs.OK {
+			return errno
+		}
+		reason += ", approved"
+	}
+	r.mount.logChange(r.op, r.rel)
+	remember("granted", r.op, r.path, r.caller, reason)
+	if Events != "" {
+		publishNATS(newEvent(r.op, r.path, r.caller, "FAN_ALLOW"))
+	}
+	if Log {
+		if LogBackingPath {
+			reason += ", backing path " + strconv.Quote(backing(r.path))
+		}
+		log.Printf("Access granted to %q because of %s, from pid %d and %d/%d", r.path, reason, r.caller.Pid, r.caller.Owner.Uid, r.caller.Owner.Gid)
+	}
+	return fs.OK
+}
+
+// denied logs, when enabled, why op on actualPath was denied and returns the errno for op. When Notify is set a
+// desktop notification is sent to the caller.
+func denied(op, actualPath string, caller *fuse.Caller, reason string) syscall.Errno {
+	remember("denied", op, actualPath, caller, reason)
+	if LastDenied {
+		rememberDenial(op, actualPath, caller)
+	}
+	if Notify {
+		notify(caller, op, actualPath)
+	}
+	if Audit {
+		audit(op, actualPath, caller, "failed")
+	}
+	if EventPipe != "" || Events != "" {
+		publish(op, actualPath, caller)
+	}
+	if !Log {
+		return errnoFor(op)
+	}
+	if LogBackingPath {
+		reason += ", backing path " + strconv.Quote(backing(actualPath))
+	}
+	log.Printf("Write access (%s) denied to %q: %s, from pid %d, from %d/%d", op, actualPath, reason, caller.Pid, caller.Owner.Uid, caller.Owner.Gid)
+	return errnoFor(op)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceDecisions(t *testing.T) {
+	defer func(tr bool) { TraceDecisions = tr }(TraceDecisions)
+	defer func(a []uidRule) { AllowUID = a }(AllowUID)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer log.SetOutput(log.Writer())
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	TraceDecisions = true
+	AllowUID = []uidRule{{lo: 1000, hi: 1000}}
+	Grace = 0
+	n := testRoot(t)
+
+	n.deny(callerContext(context.Background(), 42, 1000, 1000), "unlink", "file")
+	if !strings.Contains(buf.String(), "decided by allow-uid") {
+		t.Errorf("for an allowed uid got trace %q, want it decided by allow-uid", buf.String())
+	}
+
+	buf.Reset()
+	n.deny(callerContext(context.Background(), 42, 1001, 1001), "unlink", "file")
+	if !strings.Contains(buf.String(), "evaluated allow-uid,grace,immutable, decided by immutable") {
+		t.Errorf("for another uid got trace %q, want it decided by immutable", buf.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// scratchNode is a node in the scratch directory, everything is allowed here.
+type scratchNode struct {
+	fs.LoopbackNode
+}
+
+var _ = (fs.NodeRenamer)((*scratchNode)(nil))
+
+func newScratchNode(rootData *fs.LoopbackRoot, _ *fs.Inode, _ string, _ *syscall.Stat_t) fs.InodeEmbedder {
+	return &scratchNode{LoopbackNode: fs.LoopbackNode{RootData: rootData}}
+}
+
+// Rename only renames within the scratch directory.
+func (n *scratchNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if _, ok := newParent.(*scratchNode); !ok {
+		return syscall.EXDEV
+	}
+	return n.LoopbackNode.Rename(ctx, name, newParent, newName, flags)
+}
+
+// makeScratch creates the temporary directory backing the scratch directory of m, it should be removed with
+// removeScratch. The scratch directory is the fully writable directory in the root, whose contents are kept in
+// scratchRoot.Path/scratch instead of in the source.
+func (m *mount) makeScratch() error {
+	dir, err := os.MkdirTemp("", "mutfs-scratch-")
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(dir, m.scratch), 0777); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	m.scratchRoot = &fs.LoopbackRoot{Path: dir, NewNode: newScratchNode}
+	return nil
+}
+
+func (m *mount) removeScratch() {
+	if m.scratchRoot != nil {
+		os.RemoveAll(m.scratchRoot.Path)
+	}
+}
+
+// lookupScratch returns the inode of the scratch directory.
+func (n *MutNode) lookupScratch(ctx context.Context, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	st := syscall.Stat_t{}
+	scratchRoot := n.mount.scratchRoot
+	if err := syscall.Lstat(filepath.Join(scratchRoot.Path, n.mount.scratch), &st); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	out.Attr.FromStat(&st)
+	swapped := (uint64(st.Dev) << 32) | (uint64(st.Dev) >> 32)
+	stable := fs.StableAttr{Mode: st.Mode, Gen: 1, Ino: swapped ^ st.Ino}
+	return n.NewInode(ctx, newScratchNode(scratchRoot, nil, "", nil), stable), fs.OK
+}
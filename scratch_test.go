@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestScratch(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = 0
+	m := &mount{scratch: "tmp"}
+	olddir, newdir := testMount(t, m, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Cleanup(m.removeScratch)
+	scratch := filepath.Join(newdir, "tmp")
+
+	if fi, err := os.Stat(scratch); err != nil || !fi.IsDir() {
+		t.Fatalf("scratch directory: got %v, %v, want a directory", fi, err)
+	}
+	if err := os.WriteFile(filepath.Join(scratch, "a"), []byte("temp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(scratch, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(scratch, "a"), filepath.Join(scratch, "dir", "b")); err != nil {
+		t.Errorf("rename in scratch: %s", err)
+	}
+	if err := os.Rename(filepath.Join(scratch, "dir", "b"), filepath.Join(newdir, "b")); !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("rename out of scratch: got %v, want EXDEV", err)
+	}
+	if err := os.RemoveAll(filepath.Join(scratch, "dir")); err != nil {
+		t.Errorf("remove in scratch: %s", err)
+	}
+	if err := os.Remove(filepath.Join(newdir, "file")); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("remove outside of scratch: got %v, want EACCES", err)
+	}
+
+	entries, err := os.ReadDir(olddir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "file" {
+		t.Errorf("source holds %v, want only file", entries)
+	}
+	entries, err = os.ReadDir(newdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("mount holds %v, want file and tmp", entries)
+	}
+}
+
+func TestScratchOption(t *testing.T) {
+	for _, o := range []string{"scratch=", "scratch=.", "scratch=..", "scratch=a/b"} {
+		if err := (&mount{}).parseOpt(o); err == nil {
+			t.Errorf("%s: expected an error", o)
+		}
+	}
+}
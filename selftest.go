@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// selftest mounts mutfs on a temporary directory and checks that it behaves as it should. The results are written
+// to w, the number of failed checks is returned.
+func selftest(w io.Writer) (int, error) {
+	tmp, err := os.MkdirTemp("", "mutfs-selftest")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	olddir, newdir := filepath.Join(tmp, "old"), filepath.Join(tmp, "new")
+	for _, d := range []string{olddir, newdir} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(olddir, "old"), []byte("old\n"), 0644); err != nil {
+		return 0, err
+	}
+
+	// everything is immutable, except files with a .new extension
+	defer func(g time.Duration, e map[string]time.Duration) { Grace, GraceExt = g, e }(Grace, GraceExt)
+	Grace = 0
+	GraceExt = map[string]time.Duration{".new": time.Hour}
+
+	m := &mount{olddir: olddir, newdir: newdir}
+	root, err := m.setup()
+	if err != nil {
+		return 0, err
+	}
+	opts := &fs.Options{}
+	opts.MountOptions.Name, opts.MountOptions.FsName = "mutfs", olddir
+	opts.MountOptions.DirectMount = true
+	server, err := fs.Mount(newdir, root, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer server.Unmount()
+	if err := server.WaitMount(); err != nil {
+		return 0, err
+	}
+
+	old, fresh := filepath.Join(newdir, "old"), filepath.Join(newdir, "x.new")
+	checks := []struct {
+		name  string
+		allow bool
+		f     func() error
+	}{
+		{"read", true, func() error { _, err := os.ReadFile(old); return err }},
+		{"write", false, func() error { return os.WriteFile(old, []byte("new\n"), 0644) }},
+		{"unlink", false, func() error { return os.Remove(old) }},
+		{"rename", false, func() error { return os.Rename(old, old+"2") }},
+		{"chmod", false, func() error { return os.Chmod(old, 0600) }},
+		{"create", true, func() error { return os.WriteFile(fresh, []byte("new\n"), 0644) }},
+		{"write in grace period", true, func() error { return os.WriteFile(fresh, []byte("newer\n"), 0644) }},
+		{"unlink in grace period", true, func() error { return os.Remove(fresh) }},
+	}
+	failed := 0
+	for _, c := range checks {
+		err := c.f()
+		switch {
+		case c.allow && err != nil:
+			fmt.Fprintf(w, "FAIL %s: should be allowed: %s\n", c.name, err)
+			failed++
+		case !c.allow && err == nil:
+			fmt.Fprintf(w, "FAIL %s: should be denied\n", c.name)
+			failed++
+		default:
+			fmt.Fprintf(w, "PASS %s\n", c.name)
+		}
+	}
+	return failed, nil
+}
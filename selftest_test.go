@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSelftest(t *testing.T) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("no /dev/fuse")
+	}
+	defer func(g time.Duration, e map[string]time.Duration) { Grace, GraceExt = g, e }(Grace, GraceExt)
+	Grace = 5 * time.Minute
+	GraceExt = map[string]time.Duration{".log": time.Second}
+
+	buf := &bytes.Buffer{}
+	failed, err := selftest(buf)
+	if err != nil {
+		t.Skipf("can't mount: %s", err)
+	}
+	if failed > 0 {
+		t.Errorf("%d checks failed:\n%s", failed, buf)
+	}
+
+	// selftest sets its own grace periods, these must be restored
+	if Grace != 5*time.Minute || len(GraceExt) != 1 || GraceExt[".log"] != time.Second {
+		t.Errorf("selftest didn't restore the grace periods, got %s and %v", Grace, GraceExt)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"syscall"
+)
+
+// SerializeMutations makes all mutations happen one at a time, in order, on a single goroutine.
+var SerializeMutations bool
+
+var mutations chan func()
+
+// startSerializer starts the goroutine that performs the mutations.
+func startSerializer() {
+	mutations = make(chan func(), 1024)
+	go func() {
+		for f := range mutations {
+			f()
+		}
+	}()
+}
+
+// serialize runs f, with SerializeMutations set this happens on the serializing goroutine after all mutations that
+// were queued before it.
+func serialize(f func() syscall.Errno) syscall.Errno {
+	if !SerializeMutations {
+		return f()
+	}
+	done := make(chan syscall.Errno, 1)
+	mutations <- func() { done <- f() }
+	return <-done
+}
+
+// mutate is retry for mutations, these are serialized when needed.
+func mutate(ctx context.Context, f func() syscall.Errno) syscall.Errno {
+	return serialize(func() syscall.Errno { return retry(ctx, f) })
+}
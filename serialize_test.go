@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestSerializeMutations(t *testing.T) {
+	defer func(s bool) { SerializeMutations = s }(SerializeMutations)
+	defer func(m chan func()) { mutations = m }(mutations)
+	SerializeMutations = true
+	startSerializer()
+	defer close(mutations)
+
+	// hold the serializer, so the mutations below are queued in the order they are issued
+	held, hold := make(chan bool), make(chan bool)
+	go mutate(context.Background(), func() syscall.Errno { close(held); <-hold; return fs.OK })
+	<-held
+
+	var (
+		running, overlap int32
+		mu               sync.Mutex
+		order            []int
+		wg               sync.WaitGroup
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errno := mutate(context.Background(), func() syscall.Errno {
+				if atomic.AddInt32(&running, 1) > 1 {
+					atomic.StoreInt32(&overlap, 1)
+				}
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				atomic.AddInt32(&running, -1)
+				return fs.OK
+			})
+			if errno != fs.OK {
+				t.Errorf("mutation %d: %s", i, errnoString(errno))
+			}
+		}(i)
+		waitFor(t, "the mutation to be queued", func() bool { return len(mutations) == i+1 })
+	}
+	close(hold)
+	wg.Wait()
+
+	if overlap != 0 {
+		t.Errorf("mutations ran concurrently")
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("mutations ran in order %v, want the order they were issued in", order)
+		}
+	}
+}
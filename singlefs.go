@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// setRootDev records the device of the source of m as the one mutations are allowed on.
+func (m *mount) setRootDev() error {
+	st := &syscall.Stat_t{}
+	if err := syscall.Stat(m.olddir, st); err != nil {
+		return err
+	}
+	m.rootDev = uint64(st.Dev)
+	return nil
+}
+
+// device returns the device of actualPath, or of its directory when it doesn't exist (yet).
+func device(actualPath string) (uint64, error) {
+	st := &syscall.Stat_t{}
+	err := syscall.Lstat(actualPath, st)
+	if os.IsNotExist(err) {
+		err = syscall.Lstat(filepath.Dir(actualPath), st)
+	}
+	return uint64(st.Dev), err
+}
+
+// ruleSingleFS denies mutations on file systems mounted below the root, for the mounts that have single-fs set.
+func ruleSingleFS(r *request) (syscall.Errno, bool) {
+	if !r.mount.singleFS {
+		return fs.OK, false
+	}
+	dev, err := device(r.path)
+	if err != nil || dev == r.mount.rootDev {
+		return fs.OK, false
+	}
+	return denied(r.op, r.path, r.caller, "on another file system (device "+strconv.FormatUint(dev, 10)+")"), true
+}
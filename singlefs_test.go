@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestSingleFS(t *testing.T) {
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = time.Hour
+	n := testRoot(t)
+	if err := os.Mkdir(n.path("nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mount("tmpfs", n.path("nested"), "tmpfs", 0, ""); err != nil {
+		t.Skipf("can't mount a nested file system: %s", err)
+	}
+	defer syscall.Unmount(n.path("nested"), syscall.MNT_DETACH)
+	if err := os.WriteFile(n.path("nested/file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.mount.parseOpt("single-fs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.mount.setRootDev(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(a []*mount) { allMounts = a }(allMounts)
+	allMounts = []*mount{n.mount}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, tc := range []struct {
+		op, name string
+		want     syscall.Errno
+	}{
+		{"unlink", "file", fs.OK},
+		{"unlink", "nested/file", syscall.EACCES},
+		{"rename", "nested/file", syscall.EACCES},
+	} {
+		if got := n.deny(ctx, tc.op, tc.name); got != tc.want {
+			t.Errorf("%s of %s = %s, want %s", tc.op, tc.name, errnoString(got), errnoString(tc.want))
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// SlowThreshold is the duration above which an operation is logged as slow, 0 disables this.
+var SlowThreshold time.Duration
+
+// slow logs op on rel, which is relative to the root, when it took longer than SlowThreshold since start.
+func slow(op, rel string, start time.Time) {
+	if d := time.Since(start); d > SlowThreshold {
+		log.Printf("Slow operation (%s) on %q took %s", op, rel, d)
+	}
+}
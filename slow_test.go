@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// slowHandle is a file handle on a backing store that takes a while to read, and is fast to write.
+type slowHandle struct {
+	loopbackHandle
+	delay time.Duration
+}
+
+func (h slowHandle) Read(_ context.Context, buf []byte, _ int64) (fuse.ReadResult, syscall.Errno) {
+	time.Sleep(h.delay)
+	return fuse.ReadResultData(buf[:0]), fs.OK
+}
+
+func (slowHandle) Write(_ context.Context, data []byte, _ int64) (uint32, syscall.Errno) {
+	return uint32(len(data)), fs.OK
+}
+
+func TestSlowThreshold(t *testing.T) {
+	defer func(s time.Duration) { SlowThreshold = s }(SlowThreshold)
+	SlowThreshold = 10 * time.Millisecond
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	h := &handle{loopbackHandle: slowHandle{delay: 2 * SlowThreshold}, rel: "dir/file"}
+	if _, errno := h.Read(context.Background(), make([]byte, 16), 0); errno != fs.OK {
+		t.Fatal(errnoString(errno))
+	}
+	if _, errno := h.Write(context.Background(), []byte("x"), 0); errno != fs.OK {
+		t.Fatal(errnoString(errno))
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, `Slow operation (read) on "dir/file" took `) {
+		t.Errorf("log %q doesn't hold the slow read", got)
+	}
+	if strings.Contains(got, "(write)") {
+		t.Errorf("log %q holds the write, which wasn't slow", got)
+	}
+}
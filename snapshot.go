@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// afterSnapshot returns true if name was created after the snapshot was taken. With the snapshot option the view is
+// pinned to the state of the source at mount time, entries created after that are hidden.
+func (n *MutNode) afterSnapshot(name string) bool {
+	if n.mount.snapshotInodes != nil {
+		st := syscall.Stat_t{}
+		if err := syscall.Lstat(n.path(name), &st); err != nil {
+			return false
+		}
+		return !n.mount.snapshotInodes[fileID{uint64(st.Dev), st.Ino}]
+	}
+	bt, err := btime(n.path(name))
+	return err == nil && bt.After(n.mount.snapshotAt)
+}
+
+// fileID identifies a file by its device and inode number.
+type fileID struct {
+	dev, ino uint64
+}
+
+// hasBtime returns true if the creation time of path can be used, i.e. NoBtime isn't set and its file system
+// records it.
+func hasBtime(path string) bool {
+	if NoBtime {
+		return false
+	}
+	var statx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_BTIME, &statx); err != nil {
+		return false
+	}
+	return statx.Mask&unix.STATX_BTIME != 0
+}
+
+// recordInodes records the inodes under the source of m, for a snapshot when there is no creation time to tell which
+// entries are new. The change time can't be used instead, as it changes for old files too.
+func (m *mount) recordInodes() error {
+	inodes := map[fileID]bool{}
+	err := filepath.WalkDir(m.olddir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		st := syscall.Stat_t{}
+		if err := syscall.Lstat(path, &st); err != nil {
+			return err
+		}
+		inodes[fileID{uint64(st.Dev), st.Ino}] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.snapshotInodes = inodes
+	return nil
+}
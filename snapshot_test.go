@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestSnapshotWithoutBtime(t *testing.T) {
+	defer func(b bool) { NoBtime = b }(NoBtime)
+	NoBtime = true
+
+	m := &mount{snapshot: true}
+	olddir, newdir := testMount(t, m, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "old"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if m.snapshotInodes == nil {
+		t.Fatal("expected the inodes to be recorded without creation times")
+	}
+	time.Sleep(10 * time.Millisecond)
+	// changes the ctime of the old file, which must not hide it
+	if err := os.Chmod(filepath.Join(olddir, "old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(olddir, "new"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	des, err := os.ReadDir(newdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	if len(names) != 1 || names[0] != "old" {
+		t.Errorf("got entries %v, want [old]", names)
+	}
+	if _, err := os.Stat(filepath.Join(newdir, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected the new file to be hidden, got %v", err)
+	}
+}
+
+func TestSnapshotWritable(t *testing.T) {
+	_, newdir := testMount(t, &mount{snapshot: true}, &fs.Options{}, nil)
+	// snapshot doesn't imply ro, new files are allowed but hidden
+	if err := os.WriteFile(filepath.Join(newdir, "new"), nil, 0644); err != nil {
+		t.Errorf("expected creating a file to be allowed, got %s", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	m := &mount{snapshot: true}
+	olddir, newdir := testMount(t, m, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "old"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !hasBtime(olddir) {
+		t.Skip("no creation times")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(olddir, "new"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(newdir, "old")); err != nil {
+		t.Errorf("expected the old file to be visible, got %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(newdir, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected the new file to be hidden, got %v", err)
+	}
+}
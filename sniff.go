@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// ProtectType holds the content types (e.g. application/x-elf or image/*) of files that can never be changed.
+var ProtectType []string
+
+// magic holds content types that http.DetectContentType doesn't know about.
+var magic = []struct {
+	prefix []byte
+	typ    string
+}{
+	{[]byte("\x7fELF"), "application/x-elf"},
+	{[]byte("#!"), "text/x-script"},
+}
+
+// sniff returns the content type of the file at path, based on its first bytes.
+func sniff(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+	for _, m := range magic {
+		if bytes.HasPrefix(buf, m.prefix) {
+			return m.typ, nil
+		}
+	}
+	typ := http.DetectContentType(buf)
+	if i := strings.Index(typ, ";"); i > 0 {
+		typ = typ[:i]
+	}
+	return typ, nil
+}
+
+// protectedType returns true if typ matches one of the ProtectType patterns.
+func protectedType(typ string) bool {
+	for _, p := range ProtectType {
+		if p == typ {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(typ, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleProtectType denies write opens and unlinks of files with a protected content type.
+func ruleProtectType(r *request) (syscall.Errno, bool) {
+	if r.op != "open" && r.op != "unlink" {
+		return fs.OK, false
+	}
+	typ, err := sniff(r.path)
+	if err != nil || !protectedType(typ) {
+		return fs.OK, false
+	}
+	return denied(r.op, r.path, r.caller, "protected type "+typ), true
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestProtectType(t *testing.T) {
+	defer func(p []string) { ProtectType = p }(ProtectType)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	ProtectType = []string{"application/x-elf", "image/*"}
+	Grace = time.Hour
+	n := testRoot(t)
+	for name, content := range map[string]string{
+		"elf":  "\x7fELF\x02\x01\x01",
+		"png":  "\x89PNG\r\n\x1a\n",
+		"text": "hello world\n",
+	} {
+		if err := os.WriteFile(n.path(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for name, want := range map[string]syscall.Errno{"elf": syscall.EACCES, "png": syscall.EACCES, "text": fs.OK} {
+		for _, op := range []string{"open", "unlink"} {
+			if got := n.denyFlags(ctx, op, name, syscall.O_WRONLY); got != want {
+				t.Errorf("%s of %q in the grace period = %s, want %s", op, name, errnoString(got), errnoString(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Summary is the path the summary is written to on shutdown, if empty it is logged instead.
+var Summary string
+
+// Stats keeps track of the decisions made during the life time of the mount.
+var Stats = newStats()
+
+type stats struct {
+	sync.Mutex
+	start       time.Time
+	ops         map[string]int64
+	allowed     int64
+	denied      int64
+	writers     int64
+	peakWriters int64
+
+	remaining map[string]int64     // histogram of the grace period remaining when a mutation was allowed
+	windows   map[string]time.Time // end of the grace periods of files created, until they are used
+	unused    int64                // grace periods that ended unused
+}
+
+// remainingBuckets are the buckets, in percent of the grace period remaining, of the remaining histogram.
+var remainingBuckets = []int{10, 25, 50, 75, 100}
+
+func newStats() *stats {
+	return &stats{start: time.Now(), ops: map[string]int64{}, remaining: map[string]int64{}, windows: map[string]time.Time{}}
+}
+
+// record records the decision errno for op.
+func (s *stats) record(op string, errno syscall.Errno) {
+	s.Lock()
+	defer s.Unlock()
+	s.ops[op]++
+	if errno == 0 {
+		s.allowed++
+		return
+	}
+	s.denied++
+}
+
+func (s *stats) openWriter() {
+	s.Lock()
+	defer s.Unlock()
+	s.writers++
+	if s.writers > s.peakWriters {
+		s.peakWriters = s.writers
+	}
+}
+
+func (s *stats) closeWriter() {
+	s.Lock()
+	defer s.Unlock()
+	s.writers--
+}
+
+// window records that the file at actualPath was created and its grace period ends at end.
+func (s *stats) window(actualPath string, end time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.windows) > 1024 {
+		s.expire()
+	}
+	s.windows[actualPath] = end
+}
+
+// graceUsed records that a mutation on actualPath was allowed with remaining of the grace period left.
+func (s *stats) graceUsed(actualPath string, remaining, grace time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.windows, actualPath)
+	pct := int(100 * remaining / grace)
+	for i, b := range remainingBuckets {
+		if pct <= b {
+			lo := 0
+			if i > 0 {
+				lo = remainingBuckets[i-1]
+			}
+			s.remaining[fmt.Sprintf("%d-%d%%", lo, b)]++
+			return
+		}
+	}
+}
+
+// expire counts the grace periods that ended unused. s must be locked.
+func (s *stats) expire() {
+	t := now()
+	for p, end := range s.windows {
+		if end.Before(t) {
+			delete(s.windows, p)
+			s.unused++
+		}
+	}
+}
+
+// summary is the JSON summary of a mount's session.
+type summary struct {
+	Uptime      string           `json:"uptime"`
+	Ops         map[string]int64 `json:"ops"`
+	Allowed     int64            `json:"allowed"`
+	Denied      int64            `json:"denied"`
+	PeakWriters int64            `json:"peak_writers"`
+	Remaining   map[string]int64 `json:"grace_remaining,omitempty"`
+	Unused      int64            `json:"grace_unused,omitempty"`
+}
+
+// summary returns the JSON summary of s.
+func (s *stats) summary() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.expire()
+	return json.Marshal(summary{
+		Uptime:      time.Since(s.start).Round(time.Second).String(),
+		Ops:         s.ops,
+		Allowed:     s.allowed,
+		Denied:      s.denied,
+		PeakWriters: s.peakWriters,
+		Remaining:   s.remaining,
+		Unused:      s.unused,
+	})
+}
+
+func cmdStats(_ []string, w io.Writer) error {
+	buf, err := Stats.summary()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "OK %s\n", buf)
+	return err
+}
+
+// writeSummary writes the summary to path, or logs it when path is empty.
+func writeSummary(path string) {
+	buf, err := Stats.summary()
+	if err != nil {
+		log.Printf("Failed to create summary: %s", err)
+		return
+	}
+	if path == "" {
+		log.Printf("Summary: %s", buf)
+		return
+	}
+	if err := os.WriteFile(path, append(buf, '\n'), 0644); err != nil {
+		log.Printf("Failed to write summary to %q: %s", path, err)
+	}
+}
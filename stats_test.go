@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	defer func(s *stats) { Stats = s }(Stats)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Stats = newStats()
+	Grace = 0
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	n.deny(ctx, "unlink", "file")
+	n.deny(ctx, "rename", "file")
+	n.denyCreate(ctx, "create", "new")
+	Stats.openWriter()
+	Stats.openWriter()
+	Stats.closeWriter()
+
+	path := filepath.Join(t.TempDir(), "summary.json")
+	writeSummary(path)
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := summary{}
+	if err := json.Unmarshal(buf, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Allowed != 1 || s.Denied != 2 || s.PeakWriters != 2 {
+		t.Errorf("got allowed %d, denied %d, peak writers %d, want 1, 2 and 2", s.Allowed, s.Denied, s.PeakWriters)
+	}
+	for _, op := range []string{"unlink", "rename", "create"} {
+		if s.Ops[op] != 1 {
+			t.Errorf("got %d %s operations, want 1", s.Ops[op], op)
+		}
+	}
+}
+
+func TestGraceRemaining(t *testing.T) {
+	defer func(s *stats) { Stats = s }(Stats)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(n func() time.Time) { now = n }(now)
+	Stats = newStats()
+	Grace = 100 * time.Second
+	n := testRoot(t)
+	t0 := time.Now()
+	fakeBtimes(t, n, t0, map[string]time.Duration{"file": 0, "used": 0})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// two files are created, only one of them is used in its grace period
+	Stats.window(n.path("used"), t0.Add(Grace))
+	Stats.window(n.path("unused"), t0.Add(Grace))
+	for _, after := range []time.Duration{5 * time.Second, 10 * time.Second, 40 * time.Second, 80 * time.Second, 95 * time.Second} {
+		now = func() time.Time { return t0.Add(after) }
+		n.deny(ctx, "unlink", "file")
+	}
+	n.deny(ctx, "unlink", "used")
+
+	now = func() time.Time { return t0.Add(2 * Grace) }
+	buf, err := Stats.summary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := summary{}
+	if err := json.Unmarshal(buf, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"75-100%": 2, "50-75%": 1, "10-25%": 1, "0-10%": 2}
+	if !reflect.DeepEqual(s.Remaining, want) {
+		t.Errorf("got remaining %v, want %v", s.Remaining, want)
+	}
+	if s.Unused != 1 {
+		t.Errorf("got %d unused grace periods, want 1", s.Unused)
+	}
+}
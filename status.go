@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// active holds the end of the grace periods that have been used, per path.
+var active = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// track records that the grace period of actualPath ends at end.
+func track(actualPath string, end time.Time) {
+	active.Lock()
+	defer active.Unlock()
+	active.m[actualPath] = end
+}
+
+// soonest returns the path of the active grace period that ends first, and its end. Expired ones are forgotten.
+func soonest() (string, time.Time) {
+	active.Lock()
+	defer active.Unlock()
+	path, end := "", time.Time{}
+	t := now()
+	for p, e := range active.m {
+		if !e.After(t) {
+			delete(active.m, p)
+			continue
+		}
+		if end.IsZero() || e.Before(end) {
+			path, end = p, e
+		}
+	}
+	return path, end
+}
+
+func cmdStatus(_ []string, w io.Writer) error {
+	path, end := soonest()
+	if path == "" {
+		_, err := fmt.Fprintln(w, "OK no active grace periods")
+		return err
+	}
+	_, err := fmt.Fprintf(w, "OK %q grace period ends in %s at %s\n", path, end.Sub(now()).Round(time.Second), end.Format(time.RFC3339))
+	return err
+}
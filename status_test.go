@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestStatus(t *testing.T) {
+	defer func(c string) { Control = c }(Control)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	defer func(g map[string]time.Duration) { GraceExt = g }(GraceExt)
+	defer func(n func() time.Time) { now = n }(now)
+	// forget the grace periods used by other tests
+	active.Lock()
+	active.m = map[string]time.Time{}
+	active.Unlock()
+	Control = "control"
+	Grace, GraceExt = time.Minute, map[string]time.Duration{".log": 10 * time.Minute}
+	n := testRoot(t)
+	t0 := time.Now().Truncate(time.Second)
+	fakeBtimes(t, n, t0, map[string]time.Duration{"app.log": 0, "app": 0})
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	// use the grace periods of both files
+	now = func() time.Time { return t0.Add(10 * time.Second) }
+	for _, name := range []string{"app.log", "app"} {
+		if errno := n.deny(ctx, "unlink", name); errno != fs.OK {
+			t.Fatalf("unlink of %s = %s, want OK", name, errnoString(errno))
+		}
+	}
+
+	for _, tc := range []struct {
+		after time.Duration
+		want  string
+	}{
+		{10 * time.Second, fmt.Sprintf("OK %q grace period ends in 50s at %s\n", n.path("app"), t0.Add(time.Minute).Format(time.RFC3339))},
+		{2 * time.Minute, fmt.Sprintf("OK %q grace period ends in 8m0s at %s\n", n.path("app.log"), t0.Add(10*time.Minute).Format(time.RFC3339))},
+		{11 * time.Minute, "OK no active grace periods\n"},
+	} {
+		now = func() time.Time { return t0.Add(tc.after) }
+		buf := &bytes.Buffer{}
+		if err := cmdStatus(nil, buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("status after %s = %q, want %q", tc.after, buf, tc.want)
+		}
+	}
+	if errno := n.deny(ctx, "unlink", "app"); errno != syscall.EACCES {
+		t.Errorf("unlink of app after its grace period = %s, want EACCES", errnoString(errno))
+	}
+}
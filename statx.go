@@ -1,19 +1,55 @@
 package main
 
 import (
+	"syscall"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-func btime(name string) (time.Time, error) {
+// NoBtime disables the use of statx to get the creation time, the change time is used instead.
+var NoBtime bool
+
+// btime returns the creation time of name, or with NoBtime its change time. It's a variable, so tests can fake the
+// backing store.
+var btime = statxBtime
+
+func statxBtime(name string) (time.Time, error) {
+	if NoBtime {
+		return ctime(name)
+	}
+
 	flags := unix.AT_SYMLINK_NOFOLLOW
 	mask := unix.STATX_ALL
 
 	var statx unix.Statx_t
 	if err := unix.Statx(unix.AT_FDCWD, name, flags, mask, &statx); err != nil {
+		if err == unix.ENOSYS {
+			return ctime(name)
+		}
 		return time.Time{}, err
 	}
-	// fallback to ctime, and fallback to normal stat in case statx isn't supported?
+	// Not all filesystems (i.e. NFS) have a btime, fallback to ctime.
+	if statx.Mask&unix.STATX_BTIME == 0 {
+		return time.Unix(statx.Ctime.Sec, int64(statx.Ctime.Nsec)), nil
+	}
 	return time.Unix(statx.Btime.Sec, int64(statx.Btime.Nsec)), nil
 }
+
+func ctime(name string) (time.Time, error) {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(name, &st); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(st.Ctim.Sec, st.Ctim.Nsec), nil
+}
+
+// transient returns true when err is an error that a (network) backing store may return and which should be
+// returned as is, instead of being treated as a denial.
+func transient(err error) bool {
+	switch err {
+	case syscall.ESTALE, syscall.EIO, syscall.ENOTCONN, syscall.ETIMEDOUT, syscall.EHOSTDOWN:
+		return true
+	}
+	return false
+}
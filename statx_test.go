@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestNFSSafe(t *testing.T) {
+	defer func(b bool) { NoBtime = b }(NoBtime)
+	opts := &fs.Options{}
+	if err := parseOpt(opts, "olddir", "nfs-safe"); err != nil {
+		t.Fatal(err)
+	}
+	if !NoBtime || opts.AttrTimeout == nil || *opts.AttrTimeout != 0 || opts.EntryTimeout == nil || *opts.EntryTimeout != 0 {
+		t.Errorf("nfs-safe should use the change time and not cache attributes and entries")
+	}
+
+	n := testRoot(t)
+	bt, err := btime(n.path("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := ctime(n.path("file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bt.Equal(ct) {
+		t.Errorf("with nfs-safe got %s, want the change time %s", bt, ct)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	defer func(b func(string) (time.Time, error)) { btime = b }(btime)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Grace = time.Hour
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for _, tc := range []struct {
+		err  error
+		want syscall.Errno
+	}{
+		{syscall.ESTALE, syscall.ESTALE},
+		{syscall.EIO, syscall.EIO},
+		{syscall.ENOENT, syscall.EACCES},
+	} {
+		btime = func(string) (time.Time, error) { return time.Time{}, tc.err }
+		if errno := n.deny(ctx, "unlink", "file"); errno != tc.want {
+			t.Errorf("with the backing store returning %s got %s, want %s", tc.err, errnoString(errno), errnoString(tc.want))
+		}
+	}
+}
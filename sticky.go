@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// StickyBtime makes a file that is recreated at the path of a deleted file keep the creation time of the deleted one.
+var StickyBtime bool
+
+// btimeXattr holds the creation time a recreated file inherited.
+const btimeXattr = "user.mutfs.btime"
+
+// deletedBtimes holds the creation times of the deleted files, per path.
+var deletedBtimes = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: map[string]time.Time{}}
+
+// rememberBtime remembers the creation time of the file at actualPath, which is about to be deleted.
+func rememberBtime(actualPath string) {
+	bt, err := btime(actualPath)
+	if err != nil {
+		return
+	}
+	bt = stickyBtime(actualPath, bt)
+	deletedBtimes.Lock()
+	defer deletedBtimes.Unlock()
+	deletedBtimes.m[actualPath] = bt
+}
+
+// inheritBtime stores the creation time of the file that was deleted from actualPath on the new file at actualPath.
+func inheritBtime(actualPath string) {
+	deletedBtimes.Lock()
+	bt, ok := deletedBtimes.m[actualPath]
+	delete(deletedBtimes.m, actualPath)
+	deletedBtimes.Unlock()
+	if !ok {
+		return
+	}
+	if err := unix.Lsetxattr(actualPath, btimeXattr, []byte(bt.Format(time.RFC3339Nano)), 0); err != nil {
+		log.Printf("Failed to set creation time of %q: %s", actualPath, err)
+	}
+}
+
+// stickyBtime returns the creation time the file at actualPath inherited, if it's earlier than bt. Otherwise bt is
+// returned.
+func stickyBtime(actualPath string, bt time.Time) time.Time {
+	buf := make([]byte, 64)
+	n, err := unix.Lgetxattr(actualPath, btimeXattr, buf)
+	if err != nil {
+		return bt
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(buf[:n]))
+	if err != nil || !t.Before(bt) {
+		return bt
+	}
+	return t
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestStickyBtime(t *testing.T) {
+	defer func(s bool) { StickyBtime = s }(StickyBtime)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	StickyBtime = true
+	Grace = time.Second
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	time.Sleep(600 * time.Millisecond)
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	if _, err := unix.Lgetxattr(filepath.Join(olddir, "file"), btimeXattr, buf); err != nil {
+		t.Errorf("recreated file has no %s: %s", btimeXattr, err)
+	}
+
+	// the recreated file is only 600ms old, but the grace period started when the first file was created
+	time.Sleep(600 * time.Millisecond)
+	if err := os.Remove(file); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("delete of the recreated file: got %v, want EACCES", err)
+	}
+}
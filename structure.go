@@ -0,0 +1,42 @@
+package main
+
+import (
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// FreezeStructure denies all changes to the set of entries, while the contents of files may still change.
+var FreezeStructure bool
+
+// FreezeContent denies all changes to the contents of files, while the set of entries may still change.
+var FreezeContent bool
+
+// structural holds the operations that change the set of entries.
+var structural = map[string]bool{
+	"create":  true,
+	"mkdir":   true,
+	"mknod":   true,
+	"symlink": true,
+	"link":    true,
+	"unlink":  true,
+	"rmdir":   true,
+	"rename":  true,
+}
+
+// frozenStructure denies op on actualPath when FreezeStructure is set and op changes the set of entries. This is
+// checked before the rules, for creates as well as for deletes and renames, so none of the rules can allow it.
+func frozenStructure(op, actualPath string, caller *fuse.Caller) syscall.Errno {
+	if !FreezeStructure || !structural[op] {
+		return fs.OK
+	}
+	return denied(op, actualPath, caller, "structure frozen")
+}
+
+func ruleFreezeContent(r *request) (syscall.Errno, bool) {
+	if r.op == "open" || (r.op == "setattr" && r.flags&fuse.FATTR_SIZE != 0) {
+		return denied(r.op, r.path, r.caller, "content frozen"), true
+	}
+	return fs.OK, false
+}
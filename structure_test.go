@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestFrozenStructure(t *testing.T) {
+	defer func(f bool) { FreezeStructure = f }(FreezeStructure)
+	FreezeStructure = true
+	caller := &fuse.Caller{}
+
+	for op := range ops {
+		want := fs.OK
+		if structural[op] {
+			want = errnoFor(op)
+		}
+		if got := frozenStructure(op, "/olddir/file", caller); got != want {
+			t.Errorf("frozenStructure(%q) = %s, want %s", op, errnoString(got), errnoString(want))
+		}
+	}
+
+	FreezeStructure = false
+	if got := frozenStructure("unlink", "/olddir/file", caller); got != fs.OK {
+		t.Errorf("without freeze-structure got %s, want OK", errnoString(got))
+	}
+}
+
+func TestFreezeStructureNotARule(t *testing.T) {
+	// freeze-structure is checked before the rules, so it can't be overruled by a rule that decides earlier
+	for _, rl := range rules {
+		if rl.name == "freeze-structure" {
+			t.Errorf("freeze-structure is in the rules, it should be checked before them")
+		}
+	}
+}
+
+func TestFreezeContent(t *testing.T) {
+	defer func(f bool) { FreezeContent = f }(FreezeContent)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	FreezeContent, Grace = true, time.Hour
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	if _, err := os.OpenFile(file, os.O_WRONLY, 0); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("open for writing: got %v, want EACCES", err)
+	}
+	if err := os.Truncate(file, 0); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("truncate: got %v, want EACCES", err)
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Errorf("chmod: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(newdir, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(newdir, "dir", "file")
+	if err := os.Rename(file, moved); err != nil {
+		t.Errorf("rename: %s", err)
+	}
+	if buf, err := os.ReadFile(moved); err != nil || string(buf) != "contents" {
+		t.Errorf("read after the rename got %q, %v, want %q", buf, err, "contents")
+	}
+	if err := os.Remove(moved); err != nil {
+		t.Errorf("unlink: %s", err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WriteBPS is the number of bytes per second that can be written through mutfs, 0 disables throttling.
+var WriteBPS int64
+
+// bucket is a token bucket holding at most one second worth of bytes.
+var bucket = struct {
+	sync.Mutex
+	tokens float64
+	at     time.Time
+}{}
+
+// throttle waits until n bytes may be written. Writes larger than the bucket are allowed, but the debt is paid off by
+// the following writes.
+func throttle(n int) {
+	if WriteBPS == 0 {
+		return
+	}
+	bucket.Lock()
+	t := time.Now()
+	if bucket.at.IsZero() {
+		bucket.tokens = float64(WriteBPS)
+	} else {
+		bucket.tokens += t.Sub(bucket.at).Seconds() * float64(WriteBPS)
+		if bucket.tokens > float64(WriteBPS) {
+			bucket.tokens = float64(WriteBPS)
+		}
+	}
+	bucket.at = t
+	bucket.tokens -= float64(n)
+	wait := time.Duration(-bucket.tokens / float64(WriteBPS) * float64(time.Second))
+	bucket.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestWriteBPS(t *testing.T) {
+	defer func(w int64) { WriteBPS = w }(WriteBPS)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	t.Cleanup(func() { bucket.at, bucket.tokens = time.Time{}, 0 })
+	WriteBPS = 512 << 10
+	Grace = time.Hour
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, nil)
+	file := filepath.Join(newdir, "file")
+	bucket.at = time.Time{}
+
+	// the bucket starts full, so writing twice the rate takes about a second
+	data := bytes.Repeat([]byte("x"), 2*int(WriteBPS))
+	start := time.Now()
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("writing %d bytes at %d bytes per second took %s, want about 1s", len(data), WriteBPS, elapsed)
+	}
+
+	start = time.Now()
+	buf, err := os.ReadFile(file)
+	if err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("read back %d bytes, %v", len(buf), err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("reading took %s, reads are throttled", elapsed)
+	}
+}
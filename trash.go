@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// trashName is the name, in the .mutfs directory, of the trash. With the trash option deleted files are moved to the
+// trash directory, which is outside of the source, and listed here.
+const trashName = "trash"
+
+// trashNode is the trash directory, files can only be renamed out of it.
+type trashNode struct {
+	fs.Inode
+	mount *mount
+}
+
+var (
+	_ = (fs.NodeLookuper)((*trashNode)(nil))
+	_ = (fs.NodeReaddirer)((*trashNode)(nil))
+	_ = (fs.NodeGetattrer)((*trashNode)(nil))
+	_ = (fs.NodeRenamer)((*trashNode)(nil))
+	_ = (fs.NodeUnlinker)((*trashNode)(nil))
+)
+
+// trashFileNode is a file in the trash, it can only be read.
+type trashFileNode struct {
+	fs.Inode
+	path string
+}
+
+var (
+	_ = (fs.NodeGetattrer)((*trashFileNode)(nil))
+	_ = (fs.NodeOpener)((*trashFileNode)(nil))
+	_ = (fs.NodeReadlinker)((*trashFileNode)(nil))
+	_ = (fs.NodeSetattrer)((*trashFileNode)(nil))
+)
+
+// checkTrash checks that the trash directory of m is a directory outside of the source, on the same file system, so
+// deleted files can be renamed into it. The trash directory is made absolute.
+func (m *mount) checkTrash() error {
+	olddir, _ := filepath.Abs(m.olddir)
+	trashdir, _ := filepath.Abs(m.trash)
+	if rel, err := filepath.Rel(olddir, trashdir); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%q is in %q", m.trash, m.olddir)
+	}
+	m.trash = trashdir
+	src, trash := syscall.Stat_t{}, syscall.Stat_t{}
+	if err := syscall.Stat(m.olddir, &src); err != nil {
+		return err
+	}
+	if err := syscall.Stat(m.trash, &trash); err != nil {
+		return err
+	}
+	if trash.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return fmt.Errorf("%q isn't a directory", m.trash)
+	}
+	if src.Dev != trash.Dev {
+		return fmt.Errorf("%q isn't on the same file system as %q", m.trash, m.olddir)
+	}
+	return nil
+}
+
+// trash moves name to the trash directory. The file is named after its path, with the slashes escaped, and the time
+// it was trashed, e.g. dir%2Ffile@20240101T120000.000000000.
+func (n *MutNode) trash(name string) syscall.Errno {
+	dst := filepath.Join(n.mount.trash, url.PathEscape(n.rel(name))+"@"+time.Now().UTC().Format("20060102T150405.000000000"))
+	return fs.ToErrno(os.Rename(n.path(name), dst))
+}
+
+// lookupTrash returns the inode of the trash directory.
+func (d *metaNode) lookupTrash(ctx context.Context, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(d.mount.trash, &st); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	out.Attr.FromStat(&st)
+	out.Mode = syscall.S_IFDIR | 0555
+	return d.NewInode(ctx, &trashNode{mount: d.mount}, fs.StableAttr{Mode: syscall.S_IFDIR}), fs.OK
+}
+
+func (n *trashNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(n.mount.trash, &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	out.Mode = syscall.S_IFDIR | 0555
+	return fs.OK
+}
+
+func (n *trashNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := filepath.Join(n.mount.trash, name)
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(p, &st); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	out.Mode &^= 0222
+	return n.NewInode(ctx, &trashFileNode{path: p}, fs.StableAttr{Mode: st.Mode}), fs.OK
+}
+
+func (n *trashNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return fs.NewLoopbackDirStream(n.mount.trash)
+}
+
+// Rename restores a file from the trash, it must be renamed to a name that doesn't exist yet in the same mount.
+func (n *trashNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) (errno syscall.Errno) {
+	if flags&^unix.RENAME_NOREPLACE != 0 {
+		return syscall.EINVAL
+	}
+	p, ok := newParent.(*MutNode)
+	if !ok || p.mount != n.mount {
+		return syscall.EXDEV
+	}
+	src := filepath.Join(n.mount.trash, name)
+	if Recorder != nil {
+		defer func() {
+			Recorder.record(ctx, recEntry{Op: "rename", Path: filepath.Join(metaName, trashName, name), New: p.rel(newName), Flags: flags}, errno)
+		}()
+	}
+	if errno = p.restore(ctx, newName); errno != fs.OK {
+		return errno
+	}
+	if err := unix.Renameat2(unix.AT_FDCWD, src, unix.AT_FDCWD, p.path(newName), unix.RENAME_NOREPLACE); err != nil {
+		return fs.ToErrno(err)
+	}
+	p.mount.created(1)
+	p.mount.mirror(mirrorOp{op: "copy", rel: p.rel(newName)})
+	// the kernel now has the node from the trash as newName, have it looked up again as a node of the mount; this
+	// can't be done while the rename holds the directory locks.
+	go p.NotifyEntry(newName)
+	return fs.OK
+}
+
+// restore returns fs.OK if a file from the trash may be restored as name. This is denied like creating name is, and
+// when name exists.
+func (n *MutNode) restore(ctx context.Context, name string) syscall.Errno {
+	if errno := n.denyCreate(ctx, "rename", name); errno != fs.OK {
+		return errno
+	}
+	if _, err := os.Lstat(n.path(name)); err == nil {
+		caller, _ := fuse.FromContext(ctx)
+		denied("rename", n.path(name), caller, "restore over an existing file")
+		return syscall.EEXIST
+	}
+	return fs.OK
+}
+
+func (n *trashFileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(n.path, &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	out.Mode &^= 0222
+	return fs.OK
+}
+
+func (n *trashFileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_APPEND|syscall.O_TRUNC) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	fd, err := syscall.Open(n.path, syscall.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+	return fs.NewLoopbackFile(fd), 0, fs.OK
+}
+
+func (n *trashFileNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
+	buf := make([]byte, syscall.PathMax)
+	l, err := syscall.Readlink(n.path, buf)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return buf[:l], fs.OK
+}
+
+// Unlink doesn't remove files from the trash, that is left to the user running mutfs.
+func (n *trashNode) Unlink(ctx context.Context, name string) syscall.Errno { return syscall.EROFS }
+
+func (n *trashFileNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	return syscall.EROFS
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTrash(t *testing.T) {
+	dir := t.TempDir()
+	olddir := filepath.Join(dir, "src")
+	if err := os.Mkdir(olddir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		trash string
+		ok    bool
+	}{
+		{filepath.Join(dir, "trash"), true},
+		{filepath.Join(olddir, "trash"), false},
+		{olddir, false},
+	} {
+		if err := os.MkdirAll(tc.trash, 0755); err != nil {
+			t.Fatal(err)
+		}
+		m := &mount{olddir: olddir, trash: tc.trash}
+		if err := m.checkTrash(); (err == nil) != tc.ok {
+			t.Errorf("checkTrash for %q: got %v, want ok %t", tc.trash, err, tc.ok)
+		}
+	}
+}
+
+func TestParseOptTrash(t *testing.T) {
+	m := &mount{}
+	if err := m.parseOpt("trash=" + filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Errorf("expected an error for a trash directory that doesn't exist")
+	}
+	dir := t.TempDir()
+	if err := m.parseOpt("trash=" + dir); err != nil || m.trash != dir {
+		t.Errorf("parseOpt(trash=%s): got %v and %q", dir, err, m.trash)
+	}
+}
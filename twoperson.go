@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+// TwoPersonDelete, when non zero, holds deletes until two different Approvers approve them on the control socket.
+// A delete that isn't approved within TwoPersonDelete is denied.
+var (
+	TwoPersonDelete time.Duration
+	Approvers       []uint32
+)
+
+// pendingDelete is a delete waiting for approval.
+type pendingDelete struct {
+	op       string
+	path     string
+	pid, uid uint32
+	approved map[uint32]bool
+	done     chan struct{} // closed when approved
+}
+
+var pending = struct {
+	sync.Mutex
+	id int
+	m  map[int]*pendingDelete
+}{m: map[int]*pendingDelete{}}
+
+// approval holds the delete r, which the rules have allowed, until it is approved. When that doesn't happen in time
+// it is denied, and the errno for r.op is returned.
+func approval(r *request) syscall.Errno {
+	p := &pendingDelete{op: r.op, path: r.path, pid: r.caller.Pid, uid: r.caller.Uid, approved: map[uint32]bool{}, done: make(chan struct{})}
+	pending.Lock()
+	pending.id++
+	id := pending.id
+	pending.m[id] = p
+	pending.Unlock()
+	if Log {
+		log.Printf("Delete %d (%s) of %q waiting for approval, from pid %d", id, r.op, r.path, r.caller.Pid)
+	}
+
+	timer := time.NewTimer(TwoPersonDelete)
+	defer timer.Stop()
+	select {
+	case <-p.done:
+		return fs.OK
+	case <-timer.C:
+	}
+
+	pending.Lock()
+	removed := removePending(id)
+	pending.Unlock()
+	if !removed {
+		// approved while the timer fired
+		return fs.OK
+	}
+	return denied(r.op, r.path, r.caller, "delete not approved")
+}
+
+// removePending removes delete id from the pending deletes, it returns false if it was already removed. The pending
+// lock must be held.
+func removePending(id int) bool {
+	if _, ok := pending.m[id]; !ok {
+		return false
+	}
+	delete(pending.m, id)
+	return true
+}
+
+// approver returns true if uid may approve deletes.
+func approver(uid uint32) bool {
+	for _, a := range Approvers {
+		if a == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// peerUID returns the user ID of the process on the other end of the unix socket conn.
+func peerUID(conn net.Conn) (uint32, bool) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var cred *unix.Ucred
+	raw.Control(func(fd uintptr) { cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED) })
+	if err != nil || cred == nil {
+		return 0, false
+	}
+	return cred.Uid, true
+}
+
+// cmdPending replies with the deletes waiting for approval, one per line. Only approvers, and the owner of the
+// socket, may see them.
+func cmdPending(uid uint32, _ []string, w io.Writer) error {
+	if !approver(uid) && !owner(uid) {
+		return fmt.Errorf("uid %d is not an approver", uid)
+	}
+	pending.Lock()
+	ids := make([]int, 0, len(pending.m))
+	for id := range pending.m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		p := pending.m[id]
+		fmt.Fprintf(w, "%d %s %q pid %d uid %d approvals %d\n", id, p.op, p.path, p.pid, p.uid, len(p.approved))
+	}
+	pending.Unlock()
+	_, err := fmt.Fprintf(w, "OK %d\n", len(ids))
+	return err
+}
+
+// cmdApprove approves delete args[0] on behalf of uid, two approvals from different approvers let it proceed.
+func cmdApprove(uid uint32, args []string, w io.Writer) error {
+	if !approver(uid) {
+		return fmt.Errorf("uid %d is not an approver", uid)
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: approve ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("bad ID %q", args[0])
+	}
+	pending.Lock()
+	defer pending.Unlock()
+	p, ok := pending.m[id]
+	if !ok {
+		return fmt.Errorf("no pending delete %d", id)
+	}
+	if p.uid == uid {
+		return fmt.Errorf("uid %d can't approve its own delete", uid)
+	}
+	if p.approved[uid] {
+		return fmt.Errorf("delete %d already approved by uid %d", id, uid)
+	}
+	p.approved[uid] = true
+	if len(p.approved) == 2 {
+		removePending(id)
+		close(p.done)
+	}
+	_, err = fmt.Fprintf(w, "OK %d/2\n", len(p.approved))
+	return err
+}
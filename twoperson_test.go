@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestTwoPersonDelete(t *testing.T) {
+	defer func(d time.Duration, a []uint32) { TwoPersonDelete, Approvers = d, a }(TwoPersonDelete, Approvers)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	TwoPersonDelete, Approvers = 0, nil
+	for _, o := range []string{"two-person-delete=5s", "approver=1001", "approver=1002"} {
+		if err := parseOpt(&fs.Options{}, "olddir", o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Grace = time.Hour
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		for _, name := range []string{"approved", "unapproved"} {
+			if err := os.WriteFile(filepath.Join(olddir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	removed := make(chan error, 1)
+	go func() { removed <- os.Remove(filepath.Join(newdir, "approved")) }()
+	var id string
+	waitFor(t, "the pending delete", func() bool {
+		buf := &bytes.Buffer{}
+		if err := cmdPending(1001, nil, buf); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(buf.String(), "\n"); len(lines) == 3 {
+			id, _, _ = strings.Cut(lines[0], " ")
+			return true
+		}
+		return false
+	})
+	if _, err := strconv.Atoi(id); err != nil {
+		t.Fatalf("bad id %q", id)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := cmdApprove(1003, []string{id}, buf); err == nil {
+		t.Errorf("approval by a non approver succeeded")
+	}
+	if err := cmdApprove(1001, []string{id}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdApprove(1001, []string{id}, buf); err == nil {
+		t.Errorf("second approval by the same approver succeeded")
+	}
+	select {
+	case err := <-removed:
+		t.Fatalf("delete completed after one approval: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := cmdApprove(1002, []string{id}, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-removed; err != nil {
+		t.Errorf("approved delete: %s", err)
+	}
+	if exists(filepath.Join(olddir, "approved")) {
+		t.Errorf("approved delete didn't remove the file")
+	}
+
+	// without approval the delete is denied once the time is up
+	TwoPersonDelete = 100 * time.Millisecond
+	if err := os.Remove(filepath.Join(newdir, "unapproved")); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("unapproved delete: got %v, want EACCES", err)
+	}
+	if !exists(filepath.Join(olddir, "unapproved")) {
+		t.Errorf("unapproved delete removed the file")
+	}
+	// a late approval isn't counted for a denied delete
+	pending.Lock()
+	late := strconv.Itoa(pending.id)
+	pending.Unlock()
+	if err := cmdApprove(1001, []string{late}, buf); err == nil {
+		t.Errorf("approval after the delete was denied succeeded")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// UnlockFile is the path of the file that must exist for mutations to be allowed, when it doesn't exist all are
+// denied. When it does the other rules decide.
+var UnlockFile string
+
+// unlockTTL is how long the existence of the UnlockFile is cached.
+const unlockTTL = time.Second
+
+var unlock = struct {
+	sync.Mutex
+	at     time.Time
+	exists bool
+}{}
+
+// unlocked returns true if the UnlockFile exists.
+func unlocked() bool {
+	unlock.Lock()
+	defer unlock.Unlock()
+	if time.Since(unlock.at) >= unlockTTL {
+		_, err := os.Stat(UnlockFile)
+		unlock.at, unlock.exists = time.Now(), err == nil
+	}
+	return unlock.exists
+}
+
+// checkUnlockFile returns an error when the directory of path, or one of its parents, can be written to by others than
+// root and us, as they could then create the unlock file.
+func checkUnlockFile(path string) error {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		st := &syscall.Stat_t{}
+		if err := syscall.Stat(dir, st); err != nil {
+			return err
+		}
+		if st.Uid != 0 && st.Uid != uint32(os.Geteuid()) {
+			return fmt.Errorf("%q is owned by uid %d", dir, st.Uid)
+		}
+		if st.Mode&0022 != 0 {
+			return fmt.Errorf("%q is writable by others", dir)
+		}
+		if dir == "/" {
+			return nil
+		}
+	}
+}
+
+func ruleUnlockFile(r *request) (syscall.Errno, bool) {
+	if unlocked() {
+		return fs.OK, false
+	}
+	return denied(r.op, r.path, r.caller, "unlock file "+UnlockFile+" doesn't exist"), true
+}
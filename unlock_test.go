@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestRuleUnlockFile(t *testing.T) {
+	defer func(u string) { UnlockFile = u }(UnlockFile)
+	dir := t.TempDir()
+	UnlockFile = filepath.Join(dir, "unlock")
+	r := &request{op: "unlink", path: filepath.Join(dir, "file"), caller: &fuse.Caller{}}
+
+	unlock.at = time.Time{}
+	if errno, ok := ruleUnlockFile(r); !ok || errno != syscall.EACCES {
+		t.Errorf("without the unlock file got (%s, %t), want (EACCES, true)", errnoString(errno), ok)
+	}
+
+	if err := os.WriteFile(UnlockFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	unlock.at = time.Time{}
+	if errno, ok := ruleUnlockFile(r); ok {
+		t.Errorf("with the unlock file got (%s, %t), want the other rules to decide", errnoString(errno), ok)
+	}
+}
+
+func TestCheckUnlockFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	open := filepath.Join(dir, "open")
+	if err := os.Mkdir(open, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkUnlockFile(filepath.Join(open, "unlock")); err == nil {
+		t.Errorf("expected an error for an unlock file in a world writable directory")
+	}
+	// the temporary directory itself may be under a world writable /tmp
+	if fi, err := os.Stat(os.TempDir()); err == nil && fi.Mode().Perm()&0022 == 0 {
+		if err := checkUnlockFile(filepath.Join(dir, "unlock")); err != nil {
+			t.Errorf("expected no error, got %s", err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"golang.org/x/sys/unix"
+)
+
+// lower returns the path of name in the source. If name is empty the path of n is returned.
+func (n *MutNode) lower(name string) string {
+	return filepath.Join(n.LoopbackNode.RootData.Path, n.rel(name))
+}
+
+// upper returns the path of name in the upper directory. If name is empty the path of n is returned.
+func (n *MutNode) upper(name string) string {
+	return filepath.Join(n.mount.upper, n.rel(name))
+}
+
+// inUpper returns true if name exists in the upper directory.
+func (n *MutNode) inUpper(name string) bool {
+	_, err := os.Lstat(n.upper(name))
+	return err == nil
+}
+
+// copyUp copies name from the source to the upper directory, if it isn't there already.
+func (n *MutNode) copyUp(name string) error {
+	if n.inUpper(name) {
+		return nil
+	}
+	src, dst := n.lower(name), n.upper(name)
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	switch {
+	case fi.IsDir():
+		return os.Mkdir(dst, fi.Mode().Perm())
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	}
+	return copyFile(src, dst)
+}
+
+// upperInode returns the inode for name in the upper directory, which has been stat-ed into st.
+func (n *MutNode) upperInode(ctx context.Context, name string, st *syscall.Stat_t, out *fuse.EntryOut) *fs.Inode {
+	out.Attr.FromStat(st)
+	swapped := (uint64(st.Dev) << 32) | (uint64(st.Dev) >> 32)
+	swappedRootDev := (n.RootData.Dev << 32) | (n.RootData.Dev >> 32)
+	stable := fs.StableAttr{Mode: st.Mode, Gen: 1, Ino: (swapped ^ swappedRootDev) ^ st.Ino}
+	return n.NewInode(ctx, n.RootData.NewNode(n.RootData, n.EmbeddedInode(), name, st), stable)
+}
+
+func (n *MutNode) upperLookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(n.upper(name), &st); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return n.upperInode(ctx, name, &st, out), fs.OK
+}
+
+func (n *MutNode) upperGetattr(out *fuse.AttrOut) syscall.Errno {
+	st := syscall.Stat_t{}
+	if err := syscall.Lstat(n.upper(""), &st); err != nil {
+		return fs.ToErrno(err)
+	}
+	out.FromStat(&st)
+	return fs.OK
+}
+
+// upperReaddir lists the entries of the directory in the source and the upper directory.
+func (n *MutNode) upperReaddir() (fs.DirStream, syscall.Errno) {
+	seen := map[string]bool{}
+	entries := []fuse.DirEntry{}
+	found := false
+	for _, dir := range []string{n.upper(""), n.lower("")} {
+		des, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fs.ToErrno(err)
+		}
+		found = true
+		for _, de := range des {
+			if seen[de.Name()] {
+				continue
+			}
+			seen[de.Name()] = true
+			e := fuse.DirEntry{Name: de.Name(), Mode: uint32(de.Type())}
+			if fi, err := de.Info(); err == nil {
+				if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+					e.Mode, e.Ino = st.Mode, st.Ino
+				}
+			}
+			entries = append(entries, e)
+		}
+	}
+	if !found {
+		return nil, syscall.ENOENT
+	}
+	return fs.NewListDirStream(entries), fs.OK
+}
+
+// upperOpen opens n in the upper directory, when opening for writing the file is copied up first.
+func (n *MutNode) upperOpen(flags uint32) (fs.FileHandle, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		if err := n.copyUp(""); err != nil {
+			return nil, fs.ToErrno(err)
+		}
+	}
+	p := n.lower("")
+	if n.inUpper("") {
+		p = n.upper("")
+	}
+	fd, err := syscall.Open(p, int(flags&^syscall.O_APPEND), 0)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return fs.NewLoopbackFile(fd), fs.OK
+}
+
+func (n *MutNode) upperCreate(ctx context.Context, name string, flags, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, syscall.Errno) {
+	if err := os.MkdirAll(n.upper(""), 0755); err != nil {
+		return nil, nil, fs.ToErrno(err)
+	}
+	fd, err := syscall.Open(n.upper(name), int(flags)|syscall.O_CREAT, mode)
+	if err != nil {
+		return nil, nil, fs.ToErrno(err)
+	}
+	st := syscall.Stat_t{}
+	if err := syscall.Fstat(fd, &st); err != nil {
+		syscall.Close(fd)
+		return nil, nil, fs.ToErrno(err)
+	}
+	return n.upperInode(ctx, name, &st, out), fs.NewLoopbackFile(fd), fs.OK
+}
+
+func (n *MutNode) upperMkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if err := os.MkdirAll(n.upper(""), 0755); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := syscall.Mkdir(n.upper(name), mode); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return n.upperLookup(ctx, name, out)
+}
+
+func (n *MutNode) upperMknod(ctx context.Context, name string, mode, rdev uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if err := os.MkdirAll(n.upper(""), 0755); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := syscall.Mknod(n.upper(name), mode, int(rdev)); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return n.upperLookup(ctx, name, out)
+}
+
+// upperRemove removes name from the upper directory. Entries that only exist in the source can't be removed.
+func (n *MutNode) upperRemove(name string, dir bool) syscall.Errno {
+	if !n.inUpper(name) {
+		return syscall.EROFS
+	}
+	if dir {
+		return fs.ToErrno(syscall.Rmdir(n.upper(name)))
+	}
+	return fs.ToErrno(syscall.Unlink(n.upper(name)))
+}
+
+// upperRename renames name in the upper directory. Entries that only exist in the source can't be renamed.
+func (n *MutNode) upperRename(name string, newParent fs.InodeEmbedder, newName string) syscall.Errno {
+	p, ok := newParent.(*MutNode)
+	if !ok || !n.inUpper(name) {
+		return syscall.EXDEV
+	}
+	if err := os.MkdirAll(p.upper(""), 0755); err != nil {
+		return fs.ToErrno(err)
+	}
+	return fs.ToErrno(syscall.Rename(n.upper(name), p.upper(newName)))
+}
+
+// upperSetattr is LoopbackNode.Setattr for the upper directory, the file is copied up first.
+func (n *MutNode) upperSetattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if fsa, ok := f.(fs.FileSetattrer); ok && fsa != nil {
+		// the file handle already refers to the file in the upper directory
+		return fsa.Setattr(ctx, in, out)
+	}
+	if err := n.copyUp(""); err != nil {
+		return fs.ToErrno(err)
+	}
+	p := n.upper("")
+	if m, ok := in.GetMode(); ok {
+		if err := syscall.Chmod(p, m); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	uid, uok := in.GetUID()
+	gid, gok := in.GetGID()
+	if uok || gok {
+		suid, sgid := -1, -1
+		if uok {
+			suid = int(uid)
+		}
+		if gok {
+			sgid = int(gid)
+		}
+		if err := syscall.Lchown(p, suid, sgid); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	mtime, mok := in.GetMTime()
+	atime, aok := in.GetATime()
+	if mok || aok {
+		ap, mp := &atime, &mtime
+		if !aok {
+			ap = nil
+		}
+		if !mok {
+			mp = nil
+		}
+		ts := []syscall.Timespec{fuse.UtimeToTimespec(ap), fuse.UtimeToTimespec(mp)}
+		if err := syscall.UtimesNano(p, ts); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	if sz, ok := in.GetSize(); ok {
+		if err := syscall.Truncate(p, int64(sz)); err != nil {
+			return fs.ToErrno(err)
+		}
+	}
+	return n.upperGetattr(out)
+}
+
+func (n *MutNode) upperSetxattr(attr string, data []byte, flags uint32) syscall.Errno {
+	if err := n.copyUp(""); err != nil {
+		return fs.ToErrno(err)
+	}
+	return fs.ToErrno(unix.Lsetxattr(n.upper(""), attr, data, int(flags)))
+}
+
+func (n *MutNode) upperRemovexattr(attr string) syscall.Errno {
+	if err := n.copyUp(""); err != nil {
+		return fs.ToErrno(err)
+	}
+	return fs.ToErrno(unix.Lremovexattr(n.upper(""), attr))
+}
+
+func (n *MutNode) upperSymlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if err := os.MkdirAll(n.upper(""), 0755); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := syscall.Symlink(target, n.upper(name)); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return n.upperLookup(ctx, name, out)
+}
+
+// upperLink links target as name in the upper directory, target is copied up first.
+func (n *MutNode) upperLink(ctx context.Context, target fs.InodeEmbedder, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	t, ok := target.(*MutNode)
+	if !ok || t.mount != n.mount {
+		return nil, syscall.EXDEV
+	}
+	if err := t.copyUp(""); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := os.MkdirAll(n.upper(""), 0755); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	if err := syscall.Link(t.upper(""), n.upper(name)); err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return n.upperLookup(ctx, name, out)
+}
+
+// upperGetxattr reads attr from n in the upper directory, or from the source if it isn't there.
+func (n *MutNode) upperGetxattr(attr string, dest []byte) (uint32, syscall.Errno) {
+	sz, err := unix.Lgetxattr(n.path(""), attr, dest)
+	return uint32(sz), fs.ToErrno(err)
+}
+
+// upperListxattr lists the extended attributes of n in the upper directory, or in the source if it isn't there.
+func (n *MutNode) upperListxattr(dest []byte) (uint32, syscall.Errno) {
+	sz, err := unix.Llistxattr(n.path(""), dest)
+	return uint32(sz), fs.ToErrno(err)
+}
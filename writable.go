@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Writable holds the paths, relative to the root, of subtrees that are fully writable.
+var Writable []string
+
+// GraceUnder holds the paths, relative to the root, of the subtrees the grace period is limited to.
+var GraceUnder []string
+
+// writable returns true if rel, which is relative to the root, is in a writable subtree.
+func writable(rel string) bool { return under(rel, Writable) }
+
+// graceUnder returns true if the grace period applies to rel, which is relative to the root.
+func graceUnder(rel string) bool { return len(GraceUnder) == 0 || under(rel, GraceUnder) }
+
+// under returns true if rel, which is relative to the root, is in one of the subtrees dirs.
+func under(rel string, dirs []string) bool {
+	rel = filepath.Clean(rel)
+	for _, w := range dirs {
+		if w == "." || rel == w || strings.HasPrefix(rel, w+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestWritableTimeouts(t *testing.T) {
+	defer func(w []string) { Writable = w }(Writable)
+	Writable = []string{"scratch"}
+	n := testRoot(t)
+	if err := os.Mkdir(n.path("scratch"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for name, cached := range map[string]bool{"file": true, "scratch": false} {
+		out := &fuse.EntryOut{}
+		out.SetEntryTimeout(time.Second)
+		out.SetAttrTimeout(time.Second)
+		inode, errno := n.Lookup(ctx, name, out)
+		if errno != fs.OK {
+			t.Fatalf("lookup %q: %s", name, errnoString(errno))
+		}
+		if got := out.EntryTimeout() > 0 && out.AttrTimeout() > 0; got != cached {
+			t.Errorf("lookup %q: got timeouts %s and %s, want cached %t", name, out.EntryTimeout(), out.AttrTimeout(), cached)
+		}
+		n.AddChild(name, inode, false)
+
+		attr := &fuse.AttrOut{}
+		attr.SetTimeout(time.Second)
+		if errno := inode.Operations().(fs.NodeGetattrer).Getattr(ctx, nil, attr); errno != fs.OK {
+			t.Fatalf("getattr %q: %s", name, errnoString(errno))
+		}
+		if got := attr.Timeout() > 0; got != cached {
+			t.Errorf("getattr %q: got timeout %s, want cached %t", name, attr.Timeout(), cached)
+		}
+	}
+}
+
+func TestWritable(t *testing.T) {
+	defer func(w []string) { Writable = w }(Writable)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	Writable = []string{"scratch"}
+	Grace = 0
+	n := testRoot(t)
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	if errno := n.deny(ctx, "unlink", "file"); errno != syscall.EACCES {
+		t.Errorf("outside the writable subtree got %s, want EACCES", errnoString(errno))
+	}
+	for _, name := range []string{"scratch", "scratch/file", "scratch/dir/file"} {
+		if errno := n.deny(ctx, "unlink", name); errno != fs.OK {
+			t.Errorf("unlink %q got %s, want OK", name, errnoString(errno))
+		}
+	}
+	if errno := n.deny(ctx, "unlink", "scratchy"); errno != syscall.EACCES {
+		t.Errorf("next to the writable subtree got %s, want EACCES", errnoString(errno))
+	}
+}
+
+func TestGraceUnder(t *testing.T) {
+	defer func(g []string) { GraceUnder = g }(GraceUnder)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	GraceUnder, Grace = nil, time.Hour
+	for _, o := range []string{"grace-under=edit", "grace-under=/abs", "grace-under=../up"} {
+		err := parseOpt(&fs.Options{}, "olddir", o)
+		if (err == nil) != (o == "grace-under=edit") {
+			t.Errorf("parseOpt(%q) = %v", o, err)
+		}
+	}
+	n := testRoot(t)
+	for _, dir := range []string{"edit", "editor"} {
+		if err := os.Mkdir(n.path(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(n.path(dir+"/file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := callerContext(context.Background(), 42, 1000, 1000)
+
+	for name, want := range map[string]syscall.Errno{"edit/file": fs.OK, "editor/file": syscall.EACCES, "file": syscall.EACCES} {
+		if got := n.deny(ctx, "unlink", name); got != want {
+			t.Errorf("unlink of %s = %s, want %s", name, errnoString(got), errnoString(want))
+		}
+	}
+}
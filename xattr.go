@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// HideXattr holds the prefixes of extended attributes that are hidden.
+var HideXattr []string
+
+// AllowXattrNS holds the prefixes of the extended attributes that may be set or removed, when empty all may.
+var AllowXattrNS []string
+
+var (
+	_ = (fs.NodeGetxattrer)((*MutNode)(nil))
+	_ = (fs.NodeListxattrer)((*MutNode)(nil))
+)
+
+func hiddenXattr(attr string) bool {
+	for _, p := range HideXattr {
+		if strings.HasPrefix(attr, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedXattr returns true if attr may be set or removed.
+func allowedXattr(attr string) bool {
+	if len(AllowXattrNS) == 0 {
+		return true
+	}
+	for _, p := range AllowXattrNS {
+		if strings.HasPrefix(attr, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *MutNode) Getxattr(ctx context.Context, attr string, dest []byte) (sz uint32, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "getxattr", Path: n.rel(""), Attr: attr}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("getxattr", n.rel(""), time.Now())
+	}
+	if hiddenXattr(attr) {
+		return 0, syscall.ENODATA
+	}
+	if LastDenied && attr == lastDeniedXattr {
+		return getLastDenied(n.path(""), dest)
+	}
+	if n.mount.upper != "" {
+		return n.upperGetxattr(attr, dest)
+	}
+	return n.LoopbackNode.Getxattr(ctx, attr, dest)
+}
+
+func (n *MutNode) Listxattr(ctx context.Context, dest []byte) (sz uint32, errno syscall.Errno) {
+	if Recorder != nil {
+		defer func() { Recorder.record(ctx, recEntry{Op: "listxattr", Path: n.rel("")}, errno) }()
+	}
+	if SlowThreshold > 0 {
+		defer slow("listxattr", n.rel(""), time.Now())
+	}
+	listxattr := func(dest []byte) (uint32, syscall.Errno) { return n.LoopbackNode.Listxattr(ctx, dest) }
+	if n.mount.upper != "" {
+		listxattr = n.upperListxattr
+	}
+	if len(HideXattr) == 0 {
+		return listxattr(dest)
+	}
+
+	sz, errno = listxattr(nil)
+	if errno != fs.OK {
+		return 0, errno
+	}
+	buf := make([]byte, sz)
+	sz, errno = listxattr(buf)
+	if errno != fs.OK {
+		return 0, errno
+	}
+
+	list := &bytes.Buffer{}
+	for _, attr := range bytes.Split(buf[:sz], []byte{0}) {
+		if len(attr) == 0 || hiddenXattr(string(attr)) {
+			continue
+		}
+		list.Write(attr)
+		list.WriteByte(0)
+	}
+
+	if len(dest) == 0 {
+		return uint32(list.Len()), fs.OK
+	}
+	if len(dest) < list.Len() {
+		return uint32(list.Len()), syscall.ERANGE
+	}
+	return uint32(copy(dest, list.Bytes())), fs.OK
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"golang.org/x/sys/unix"
+)
+
+func TestHideXattr(t *testing.T) {
+	defer func(h []string) { HideXattr = h }(HideXattr)
+	HideXattr = []string{"security."}
+	_, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		file := filepath.Join(olddir, "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Lsetxattr(file, "security.foo", []byte("secret"), 0); err != nil {
+			t.Skipf("can't set security.foo: %s", err)
+		}
+		if err := unix.Lsetxattr(file, "user.foo", []byte("visible"), 0); err != nil {
+			t.Skipf("can't set user.foo: %s", err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	buf := make([]byte, 64)
+	if _, err := unix.Lgetxattr(file, "security.foo", buf); err != unix.ENODATA {
+		t.Errorf("getxattr of security.foo: got %v, want ENODATA", err)
+	}
+	if sz, err := unix.Lgetxattr(file, "user.foo", buf); err != nil || string(buf[:sz]) != "visible" {
+		t.Errorf("getxattr of user.foo: got %q, %v", buf[:sz], err)
+	}
+	sz, err := unix.Llistxattr(file, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := string(buf[:sz])
+	if strings.Contains(list, "security.foo") || !strings.Contains(list, "user.foo") {
+		t.Errorf("listxattr got %q, want only user.foo", list)
+	}
+}
+
+func TestAllowXattrNS(t *testing.T) {
+	defer func(a []string) { AllowXattrNS = a }(AllowXattrNS)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	AllowXattrNS, Grace = []string{"user."}, time.Hour
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	if err := unix.Lsetxattr(file, "user.foo", []byte("value"), 0); err != nil {
+		t.Skipf("can't set user.foo: %s", err)
+	}
+	if err := unix.Lsetxattr(file, "security.bar", []byte("value"), 0); err != unix.EACCES {
+		t.Errorf("setxattr of security.bar: got %v, want EACCES", err)
+	}
+	if err := unix.Lremovexattr(file, "security.bar"); err != unix.EACCES {
+		t.Errorf("removexattr of security.bar: got %v, want EACCES", err)
+	}
+	if err := unix.Lremovexattr(file, "user.foo"); err != nil {
+		t.Errorf("removexattr of user.foo: %s", err)
+	}
+
+	buf := make([]byte, 64)
+	if _, err := unix.Lgetxattr(filepath.Join(olddir, "file"), "security.bar", buf); err != unix.ENODATA {
+		t.Errorf("security.bar in the source: got %v, want ENODATA", err)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// NoZero denies truncating a non-empty file to zero, whatever else allows it.
+var NoZero bool
+
+// zeroes returns the errno for op when it truncates actualPath to zero while it isn't empty, otherwise 0.
+func zeroes(op, actualPath string, caller *fuse.Caller) syscall.Errno {
+	st := syscall.Stat_t{}
+	if err := syscall.Stat(actualPath, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG || st.Size == 0 {
+		return 0
+	}
+	return denied(op, actualPath, caller, "truncates a non-empty file")
+}
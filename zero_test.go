@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+func TestNoZero(t *testing.T) {
+	defer func(z bool) { NoZero = z }(NoZero)
+	defer func(g time.Duration) { Grace = g }(Grace)
+	NoZero, Grace = true, time.Hour
+	olddir, newdir := testMount(t, &mount{}, &fs.Options{}, func(olddir string) {
+		if err := os.WriteFile(filepath.Join(olddir, "file"), []byte("contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(olddir, "empty"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	file := filepath.Join(newdir, "file")
+
+	if _, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("open with O_TRUNC: got %v, want EACCES", err)
+	}
+	if err := os.Truncate(file, 0); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("truncate to zero: got %v, want EACCES", err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open for appending: %s", err)
+	}
+	if _, err := f.WriteString(" appended"); err != nil {
+		t.Errorf("append: %s", err)
+	}
+	f.Close()
+	if err := os.Truncate(file, 3); err != nil {
+		t.Errorf("truncate to 3: %s", err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(olddir, "file")); err != nil || string(buf) != "con" {
+		t.Errorf("source holds %q, %v, want %q", buf, err, "con")
+	}
+
+	// an empty file has nothing to lose
+	f, err = os.OpenFile(filepath.Join(newdir, "empty"), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		t.Errorf("open of an empty file with O_TRUNC: %s", err)
+	} else {
+		f.Close()
+	}
+}